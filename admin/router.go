@@ -0,0 +1,253 @@
+// Package admin 提供persist运维管理http接口, 挂载到已有的gin路由上使用
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spelens-gud/persist/core"
+)
+
+// PersistInfo persist概要信息
+type PersistInfo struct {
+	Name  string          `json:"name"`
+	Dead  bool            `json:"dead"`
+	User  bool            `json:"user"`            // 是否为用户相关persist
+	Queue *core.QueueStat `json:"queue,omitempty"` // 未实现 core.IPersistQueue 时为空
+}
+
+// LoadStateInfo 用户数据导入状态
+type LoadStateInfo struct {
+	Name  string `json:"name"`
+	State int32  `json:"state"`
+}
+
+// ProbeConfig 就绪检查阈值
+var ProbeConfig = core.DefaultHealthConfig
+
+// Register 挂载管理接口
+//
+//	GET  /healthz                    存活检查, 失败返回503
+//	GET  /readyz                     就绪检查, 失败返回503
+//	GET  /persists                   所有persist状态及队列长度
+//	GET  /persists/:name             单个persist状态及队列长度
+//	GET  /persists/:name/object?pk=  通过主键json导出内存对象, 例如 pk={"Uid":1}
+//	GET  /persists/:name/fail-queue  下载当前失败队列(bomb文件格式)
+//	GET  /persists/:name/diff        内存数据和数据库按字段比较, 只返回差异不更新
+//	POST /persists/:name/sync-data   内存数据和数据库比较并同步
+//	GET  /users/:uid/state           用户在所有persist中的导入状态
+//	POST /users/:uid/load            导入用户数据
+//	POST /users/:uid/unload          导出用户数据
+//	POST /users/:uid/sync            用户内存数据和数据库比较并同步
+//
+// registry为管理的persist注册表, 例如 data.New(cfg).Build() 返回的 Container.Registry, 为nil时使用全局注册表
+func Register(r gin.IRouter, registry *core.Registry) {
+	if registry == nil {
+		registry = core.DefaultRegistry()
+	}
+	h := &handler{registry: registry}
+	r.GET("/healthz", h.liveness)
+	r.GET("/readyz", h.readiness)
+	r.GET("/persists", h.listPersist)
+	r.GET("/persists/:name", h.getPersist)
+	r.GET("/persists/:name/object", h.dumpObject)
+	r.GET("/persists/:name/fail-queue", h.downloadFailQueue)
+	r.GET("/persists/:name/diff", h.diffData)
+	r.POST("/persists/:name/sync-data", h.syncData)
+	r.GET("/users/:uid/state", h.userLoadState)
+	r.POST("/users/:uid/load", h.userLoad)
+	r.POST("/users/:uid/unload", h.userUnload)
+	r.POST("/users/:uid/sync", h.userSync)
+}
+
+// handler 管理接口, 所有操作都作用于同一个注册表
+type handler struct {
+	registry *core.Registry
+}
+
+func newPersistInfo(persist core.IPersist) PersistInfo {
+	info := PersistInfo{Name: persist.PersistName(), Dead: persist.Dead()}
+	if _, ok := core.AsPersistUser(persist); ok {
+		info.User = true
+	}
+	if queue, ok := persist.(core.IPersistQueue); ok {
+		stat := queue.QueueStat()
+		info.Queue = &stat
+	}
+	return info
+}
+
+func abortError(c *gin.Context, code int, err error) {
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
+
+func (h *handler) findPersist(c *gin.Context) core.IPersist {
+	persist := h.registry.GetIPersistByName(c.Param("name"))
+	if persist == nil {
+		abortError(c, http.StatusNotFound, errors.New("persist not found: "+c.Param("name")))
+	}
+	return persist
+}
+
+func parseUid(c *gin.Context) (int32, bool) {
+	uid, err := strconv.ParseInt(c.Param("uid"), 10, 32)
+	if err != nil {
+		abortError(c, http.StatusBadRequest, err)
+		return 0, false
+	}
+	return int32(uid), true
+}
+
+func (h *handler) liveness(c *gin.Context) {
+	report := h.registry.CheckHealth(ProbeConfig)
+	if !report.Live {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *handler) readiness(c *gin.Context) {
+	report := h.registry.CheckHealth(ProbeConfig)
+	if !report.Ready {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *handler) listPersist(c *gin.Context) {
+	list := make([]PersistInfo, 0)
+	for _, persist := range h.registry.GetPersistList() {
+		list = append(list, newPersistInfo(persist))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	c.JSON(http.StatusOK, list)
+}
+
+func (h *handler) getPersist(c *gin.Context) {
+	persist := h.findPersist(c)
+	if persist == nil {
+		return
+	}
+	c.JSON(http.StatusOK, newPersistInfo(persist))
+}
+
+func (h *handler) dumpObject(c *gin.Context) {
+	persist := h.findPersist(c)
+	if persist == nil {
+		return
+	}
+	dump, ok := persist.(core.IPersistDump)
+	if !ok {
+		abortError(c, http.StatusNotImplemented, errors.New("persist does not support dump"))
+		return
+	}
+	obj, err := dump.PersistInterfaceByPkJson([]byte(c.Query("pk")))
+	if errors.Is(err, core.EPersistErrorNotInMemory) {
+		abortError(c, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		abortError(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, obj)
+}
+
+func (h *handler) downloadFailQueue(c *gin.Context) {
+	persist := h.findPersist(c)
+	if persist == nil {
+		return
+	}
+	failQueue, ok := persist.(core.IPersistFailQueue)
+	if !ok {
+		abortError(c, http.StatusNotImplemented, errors.New("persist does not support fail queue"))
+		return
+	}
+	data, err := failQueue.FailQueueBomb()
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename="+persist.PersistName()+".bomb")
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
+
+func (h *handler) diffData(c *gin.Context) {
+	persist := h.findPersist(c)
+	if persist == nil {
+		return
+	}
+	diff, ok := persist.(core.IPersistDiff)
+	if !ok {
+		abortError(c, http.StatusNotImplemented, errors.New("persist does not support diff"))
+		return
+	}
+	reportList, err := diff.DiffData()
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if reportList == nil {
+		reportList = make([]*core.DiffReport, 0)
+	}
+	c.JSON(http.StatusOK, reportList)
+}
+
+func (h *handler) syncData(c *gin.Context) {
+	persist := h.findPersist(c)
+	if persist == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	err := persist.SyncData(&wg, c.Query("debug") == "1")
+	wg.Wait()
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (h *handler) userLoadState(c *gin.Context) {
+	uid, ok := parseUid(c)
+	if !ok {
+		return
+	}
+	list := make([]LoadStateInfo, 0)
+	for _, persist := range h.registry.GetPersistUserList() {
+		list = append(list, LoadStateInfo{Name: persist.PersistName(), State: persist.LoadState(uid)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	c.JSON(http.StatusOK, list)
+}
+
+func (h *handler) userOp(c *gin.Context, op func(uid int32) error) {
+	uid, ok := parseUid(c)
+	if !ok {
+		return
+	}
+	if err := op(uid); err != nil {
+		abortError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (h *handler) userLoad(c *gin.Context) {
+	h.userOp(c, h.registry.Load)
+}
+
+func (h *handler) userUnload(c *gin.Context) {
+	h.userOp(c, h.registry.Unload)
+}
+
+func (h *handler) userSync(c *gin.Context) {
+	debug := c.Query("debug") == "1"
+	h.userOp(c, func(uid int32) error { return h.registry.SyncUserDataPersist(uid, debug) })
+}
@@ -0,0 +1,223 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/spelens-gud/persist/core"
+	"github.com/spelens-gud/persist/data"
+	"github.com/spelens-gud/persist/model"
+	"xorm.io/xorm"
+)
+
+// newTestRouter 挂载管理接口, 注册运行中的UserShare, 数据库为临时目录中的sqlite
+func newTestRouter(t *testing.T) (*gin.Engine, *data.UserShareManager, *xorm.Engine) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	engine, err := xorm.NewEngine("sqlite3", filepath.Join(t.TempDir(), "persist.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = engine.Close() })
+	if err = engine.Sync2(&model.UserShare{}); err != nil {
+		t.Fatal(err)
+	}
+	m := data.NewUserShareManager(engine)
+	if err = m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = m.ExitContext(context.Background()) })
+
+	registry := core.NewRegistry()
+	registry.RegisterPersist("UserShare", m)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	Register(r, registry)
+	return r, m, engine
+}
+
+func serve(r http.Handler, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+// TestProbe 测试存活和就绪检查
+func TestProbe(t *testing.T) {
+	r, m, _ := newTestRouter(t)
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		w := serve(r, http.MethodGet, path)
+		var report core.HealthReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || !report.Live || !report.Ready || len(report.Persists) != 1 {
+			t.Errorf("%s: unexpected response %d %s", path, w.Code, w.Body)
+		}
+	}
+
+	// 退出后不再存活
+	if err := m.ExitContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if w := serve(r, http.MethodGet, path); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "UserShare: dead") {
+			t.Errorf("%s: unexpected response %d %s", path, w.Code, w.Body)
+		}
+	}
+}
+
+// TestDumpObject 测试导出内存对象, 和SetField并发时返回加锁拷贝
+func TestDumpObject(t *testing.T) {
+	r, m, _ := newTestRouter(t)
+	if w := serve(r, http.MethodPost, "/users/1/load"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected load %d %s", w.Code, w.Body)
+	}
+	cls, err := m.NewUserShare(&model.UserShare{Uid: 1, UserName: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_ = m.SetField(cls, "NickName", "nick"+strconv.Itoa(i))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		w := serve(r, http.MethodGet, `/persists/UserShare/object?pk={"Uid":1}`)
+		var obj model.UserShare
+		if err = json.Unmarshal(w.Body.Bytes(), &obj); err != nil || w.Code != http.StatusOK || obj.Uid != 1 || obj.UserName != "a" {
+			t.Fatalf("unexpected object %d %s", w.Code, w.Body)
+		}
+	}
+	wg.Wait()
+
+	for path, code := range map[string]int{
+		`/persists/UserShare/object?pk={"Uid":2}`: http.StatusNotFound,
+		`/persists/UserShare/object?pk=1`:         http.StatusBadRequest,
+		`/persists/None/object?pk={"Uid":1}`:      http.StatusNotFound,
+	} {
+		if w := serve(r, http.MethodGet, path); w.Code != code {
+			t.Errorf("%s: unexpected code %d %s", path, w.Code, w.Body)
+		}
+	}
+}
+
+// TestUserLoadUnload 测试导入导出用户和查询导入状态
+func TestUserLoadUnload(t *testing.T) {
+	r, m, _ := newTestRouter(t)
+
+	if w := serve(r, http.MethodPost, "/users/1/load"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected load %d %s", w.Code, w.Body)
+	}
+	w := serve(r, http.MethodGet, "/users/1/state")
+	var list []LoadStateInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0] != (LoadStateInfo{Name: "UserShare", State: data.EUserShareLoadStateMemory}) {
+		t.Errorf("unexpected state %s", w.Body)
+	}
+
+	if w = serve(r, http.MethodPost, "/users/1/unload"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected unload %d %s", w.Code, w.Body)
+	}
+	// 写回协程中导出
+	deadline := time.Now().Add(5 * time.Second)
+	for m.LoadState(1) != data.EUserShareLoadStateDisk {
+		if time.Now().After(deadline) {
+			t.Fatalf("user not unloaded, state %d", m.LoadState(1))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if w = serve(r, http.MethodPost, "/users/abc/load"); w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected invalid uid %d", w.Code)
+	}
+}
+
+// TestPersistInfo 测试查询persist概要信息和下载失败队列
+func TestPersistInfo(t *testing.T) {
+	r, _, _ := newTestRouter(t)
+
+	w := serve(r, http.MethodGet, "/persists")
+	var list []PersistInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "UserShare" || list[0].Dead || !list[0].User || list[0].Queue == nil {
+		t.Errorf("unexpected list %s", w.Body)
+	}
+	w = serve(r, http.MethodGet, "/persists/UserShare")
+	var info PersistInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil || info.Name != "UserShare" {
+		t.Errorf("unexpected info %s", w.Body)
+	}
+	if w = serve(r, http.MethodGet, "/persists/None"); w.Code != http.StatusNotFound {
+		t.Errorf("unexpected unknown persist %d", w.Code)
+	}
+
+	w = serve(r, http.MethodGet, "/persists/UserShare/fail-queue")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "UserShare ") || w.Header().Get("Content-Disposition") != "attachment; filename=UserShare.bomb" {
+		t.Errorf("unexpected fail queue %d %s", w.Code, w.Body)
+	}
+}
+
+// TestDiffSyncData 测试比较内存和数据库差异, 同步后差异消失
+func TestDiffSyncData(t *testing.T) {
+	r, m, engine := newTestRouter(t)
+	if w := serve(r, http.MethodPost, "/users/1/load"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected load %d %s", w.Code, w.Body)
+	}
+	if _, err := m.NewUserShare(&model.UserShare{Uid: 1, UserName: "a", NickName: "n"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for has, _ := engine.Exist(&model.UserShare{Uid: 1}); !has; has, _ = engine.Exist(&model.UserShare{Uid: 1}) {
+		if time.Now().After(deadline) {
+			t.Fatal("object not written back")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if w := serve(r, http.MethodGet, "/persists/UserShare/diff"); w.Code != http.StatusOK || w.Body.String() != "[]" {
+		t.Fatalf("unexpected diff %d %s", w.Code, w.Body)
+	}
+
+	// 绕过内存修改数据库
+	if _, err := engine.ID(1).Cols("nick_name").Update(&model.UserShare{NickName: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(r, http.MethodGet, "/persists/UserShare/diff")
+	var reportList []core.DiffReport
+	if err := json.Unmarshal(w.Body.Bytes(), &reportList); err != nil {
+		t.Fatal(err)
+	}
+	if len(reportList) != 1 || len(reportList[0].Fields) != 1 || reportList[0].Fields[0] != (core.FieldDiff{Field: "NickName", Mem: "n", DB: "x"}) {
+		t.Fatalf("unexpected diff %s", w.Body)
+	}
+
+	if w = serve(r, http.MethodPost, "/persists/UserShare/sync-data"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected sync %d %s", w.Code, w.Body)
+	}
+	cls := &model.UserShare{Uid: 1}
+	if has, err := engine.Get(cls); err != nil || !has || cls.NickName != "n" {
+		t.Errorf("unexpected row %v %v %q", has, err, cls.NickName)
+	}
+	if w = serve(r, http.MethodGet, "/persists/UserShare/diff"); w.Body.String() != "[]" {
+		t.Errorf("unexpected diff after sync %s", w.Body)
+	}
+}
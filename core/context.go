@@ -0,0 +1,64 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PersistResult 单个persist执行结果
+type PersistResult struct {
+	Name     string        `json:"name"`
+	Stage    StartupStage  `json:"stage,omitempty"` // 启动阶段, 非启动时为空
+	Optional bool          `json:"optional"`        // 可选persist, 失败不影响启动
+	Err      error         `json:"-"`
+	Error    string        `json:"error,omitempty"` // Err的内容, 用于序列化
+	Duration time.Duration `json:"duration"`
+}
+
+// PersistResults 所有persist执行结果, 按名字排序
+type PersistResults []PersistResult
+
+// Err 第一个出错的persist, 全部成功返回nil
+func (r PersistResults) Err() error {
+	for _, result := range r {
+		if result.Err != nil {
+			return errors.New(result.Name + result.Err.Error())
+		}
+	}
+	return nil
+}
+
+// WaitContext 执行fn直到完成或ctx结束. ctx结束时fn仍在后台执行
+func WaitContext(ctx context.Context, fn func() error) error {
+	ch := make(chan error, 1)
+	go func() {
+		ch <- fn()
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SyncPersistContext 所有Persist同步结构
+func SyncPersistContext(ctx context.Context) PersistResults {
+	return gDefaultRegistry.SyncPersistContext(ctx)
+}
+
+// RunPersistContext 运行所有Persist
+func RunPersistContext(ctx context.Context) PersistResults {
+	return gDefaultRegistry.RunPersistContext(ctx)
+}
+
+// ExitPersistContext 退出所有Persist, 超时的persist剩余队列写入bomb文件
+func ExitPersistContext(ctx context.Context) PersistResults {
+	return gDefaultRegistry.ExitPersistContext(ctx)
+}
+
+// SyncDataPersistContext 所有Persist, 不安全的方式强制同步数据, 调用后不允许再修改数据
+func SyncDataPersistContext(ctx context.Context, sentryDebug bool) PersistResults {
+	return gDefaultRegistry.SyncDataPersistContext(ctx, sentryDebug)
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// TestPersistResultsJSON 测试序列化的结果包含错误
+func TestPersistResultsJSON(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterPersist("A", &mockPersist{name: "A"})
+	r.RegisterPersist("B", &mockPersist{name: "B", exitErr: errors.New("exit timeout")})
+
+	results := r.ExitPersistContext(context.Background())
+	if err := results.Err(); err == nil || err.Error() != "Bexit timeout" {
+		t.Errorf("unexpected error %v", err)
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []map[string]any
+	if err = json.Unmarshal(data, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0]["error"] != nil || list[1]["error"] != "exit timeout" {
+		t.Errorf("unexpected json %s", data)
+	}
+}
+
+// TestWaitContext 测试ctx结束时返回, fn仍在后台执行
+func TestWaitContext(t *testing.T) {
+	fnErr := errors.New("fn failed")
+	if err := WaitContext(context.Background(), func() error { return fnErr }); err != fnErr {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	release := make(chan struct{})
+	err := WaitContext(ctx, func() error {
+		<-release
+		close(done)
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error %v", err)
+	}
+	close(release)
+	<-done
+}
@@ -0,0 +1,47 @@
+package core
+
+import "time"
+
+// FieldDiff 单个字段内存和数据库的差异
+type FieldDiff struct {
+	Field string      `json:"field"` // 结构体字段名
+	Mem   interface{} `json:"mem"`   // 内存值
+	DB    interface{} `json:"db"`    // 数据库值
+}
+
+// DiffReport 一个对象内存和数据库的差异
+type DiffReport struct {
+	Persist string      `json:"persist"`
+	Pk      interface{} `json:"pk"`
+	Fields  []FieldDiff `json:"fields"`
+	Missing bool        `json:"missing,omitempty"` // 数据库中不存在, Fields为空
+}
+
+// FieldNames 不一致的字段名
+func (r *DiffReport) FieldNames() (names []string) {
+	for _, field := range r.Fields {
+		names = append(names, field.Field)
+	}
+	return
+}
+
+// IPersistDiff 可选接口, 比较内存和数据库数据, 只报告不更新
+type IPersistDiff interface {
+	DiffData() ([]*DiffReport, error)
+}
+
+// IPersistMarkDetector 可选接口, 检测修改了对象但没有标记写回的代码(调试用)
+type IPersistMarkDetector interface {
+	EnableMarkDetector(interval time.Duration, autoMark bool)
+	DisableMarkDetector()
+}
+
+// EnableMarkDetector 所有支持的persist开启未标记修改检测
+func EnableMarkDetector(interval time.Duration, autoMark bool) {
+	gDefaultRegistry.EnableMarkDetector(interval, autoMark)
+}
+
+// DisableMarkDetector 所有支持的persist关闭未标记修改检测
+func DisableMarkDetector() {
+	gDefaultRegistry.DisableMarkDetector()
+}
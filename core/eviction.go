@@ -0,0 +1,136 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessInfo 已导入key的最近访问时间和估算内存
+type AccessInfo struct {
+	Key        int64
+	LastAccess time.Time
+	Size       int64 // key数据估算字节数, 0表示未知
+}
+
+// IPersistEvict 可选接口, 按key导入的persist支持淘汰空闲数据
+type IPersistEvict interface {
+	AccessList() []AccessInfo // 导入完成的key, 全导入时为空
+	Evict(key int64) error    // 导出key的数据, 先写回该key未完成的修改. 用户相关persist由Registry.Unload导出, 不调用
+}
+
+// EvictConfig 淘汰配置
+type EvictConfig struct {
+	Interval time.Duration // 检查间隔
+	IdleTTL  time.Duration // 超过该时间未访问的key被淘汰, 0不按时间淘汰
+	MaxKeys  int           // 所有persist导入key数量上限, 同一用户计为一个. 超过时淘汰最久未访问的key(LRU), 0不限制
+	MaxBytes int64         // 所有persist导入key估算内存上限(AccessInfo.Size之和). 超过时淘汰最久未访问的key(LRU), 0不限制
+}
+
+// Evict 按配置淘汰一次, 返回淘汰的key数量. 用户相关persist通过Unload按用户导出所有IPersistUser, 最近访问时间取各persist中最新的, 内存为各persist之和
+// 用户相关persist的key超出int32范围时不能按用户导出, 跳过并返回错误
+func (r *Registry) Evict(config EvictConfig) (evicted int, err error) {
+	type entry struct {
+		persist IPersistEvict // 用户相关persist为nil
+		name    string
+		info    AccessInfo
+	}
+	var entryList []entry
+	var totalBytes int64
+	userIndex := make(map[int64]int)
+	for _, persist := range r.GetPersistList() {
+		evict, ok := persist.(IPersistEvict)
+		if !ok {
+			continue
+		}
+		_, user := AsPersistUser(persist)
+		for _, info := range evict.AccessList() {
+			totalBytes += info.Size
+			if !user {
+				entryList = append(entryList, entry{persist: evict, name: persist.PersistName(), info: info})
+				continue
+			}
+			if idx, ok := userIndex[info.Key]; ok {
+				if info.LastAccess.After(entryList[idx].info.LastAccess) {
+					entryList[idx].info.LastAccess = info.LastAccess
+				}
+				entryList[idx].info.Size += info.Size
+				continue
+			}
+			userIndex[info.Key] = len(entryList)
+			entryList = append(entryList, entry{name: "user " + strconv.FormatInt(info.Key, 10) + " ", info: info})
+		}
+	}
+	sort.Slice(entryList, func(i, j int) bool { return entryList[i].info.LastAccess.Before(entryList[j].info.LastAccess) })
+
+	// 淘汰失败的key仍然计入数量和内存, 继续淘汰后面的key
+	now := time.Now()
+	keys := len(entryList)
+	for _, e := range entryList {
+		idle := config.IdleTTL > 0 && now.Sub(e.info.LastAccess) > config.IdleTTL
+		over := (config.MaxKeys > 0 && keys > config.MaxKeys) || (config.MaxBytes > 0 && totalBytes > config.MaxBytes)
+		if !idle && !over {
+			break
+		}
+		var evictErr error
+		if e.persist == nil {
+			if e.info.Key < math.MinInt32 || e.info.Key > math.MaxInt32 {
+				evictErr = errors.New("key overflows int32")
+			} else {
+				evictErr = r.Unload(int32(e.info.Key))
+			}
+		} else {
+			evictErr = e.persist.Evict(e.info.Key)
+		}
+		if evictErr != nil {
+			if err == nil {
+				err = errors.New(e.name + evictErr.Error())
+			}
+			continue
+		}
+		evicted++
+		keys--
+		totalBytes -= e.info.Size
+	}
+	return
+}
+
+// StartEvictor 定时淘汰, 返回停止函数
+func (r *Registry) StartEvictor(config EvictConfig) (stop func()) {
+	if config.Interval <= 0 {
+		config.Interval = time.Minute
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := r.Evict(config); err != nil {
+					log.Println("persist evict failed", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// Evict 按配置淘汰一次, 返回淘汰的key数量
+func Evict(config EvictConfig) (int, error) {
+	return gDefaultRegistry.Evict(config)
+}
+
+// StartEvictor 定时淘汰, 返回停止函数
+func StartEvictor(config EvictConfig) (stop func()) {
+	return gDefaultRegistry.StartEvictor(config)
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockEvict 按key淘汰的persist, 记录淘汰顺序
+type mockEvict struct {
+	mockPersist
+	log      *opLog
+	list     []AccessInfo
+	evictErr map[int64]error
+}
+
+func (p *mockEvict) AccessList() []AccessInfo { return p.list }
+
+func (p *mockEvict) Evict(key int64) error {
+	if err := p.evictErr[key]; err != nil {
+		return err
+	}
+	p.log.add("evict " + p.name + " " + strconv.FormatInt(key, 10))
+	return nil
+}
+
+// mockEvictUser 按用户淘汰的persist
+type mockEvictUser struct {
+	*mockUser
+	list []AccessInfo
+}
+
+func (p *mockEvictUser) AccessList() []AccessInfo { return p.list }
+
+func (p *mockEvictUser) Evict(key int64) error { panic("user persist evicted by key") }
+
+// accessList 按照key顺序最近访问时间递增, 第一个key最久未访问
+func accessList(now time.Time, keys ...int64) (list []AccessInfo) {
+	for i, key := range keys {
+		list = append(list, AccessInfo{Key: key, LastAccess: now.Add(time.Duration(i-len(keys)) * time.Minute), Size: 100})
+	}
+	return
+}
+
+// TestEvictLRU 测试超过key数量和内存上限时按最久未访问顺序淘汰, 淘汰失败时继续淘汰后面的key
+func TestEvictLRU(t *testing.T) {
+	now := time.Now()
+	log := &opLog{}
+	r := NewRegistry()
+	a := &mockEvict{mockPersist: mockPersist{name: "A"}, log: log, list: accessList(now, 3, 1)}
+	b := &mockEvict{mockPersist: mockPersist{name: "B"}, log: log, list: accessList(now.Add(-30*time.Second), 4, 2)}
+	r.RegisterPersist("A", a)
+	r.RegisterPersist("B", b)
+
+	evicted, err := r.Evict(EvictConfig{MaxKeys: 2})
+	if err != nil || evicted != 2 {
+		t.Fatalf("unexpected evict %d %v", evicted, err)
+	}
+	if ops := log.get(); !equalOps(ops, []string{"evict B 4", "evict A 3"}) {
+		t.Errorf("unexpected ops %v", ops)
+	}
+
+	// 每个key估算100字节, 上限250字节淘汰2个key, 其中一个失败时多淘汰一个
+	log2 := &opLog{}
+	a.log, b.log = log2, log2
+	a.evictErr = map[int64]error{3: errors.New(" evict failed")}
+	evicted, err = r.Evict(EvictConfig{MaxBytes: 250})
+	if err == nil || err.Error() != "A evict failed" || evicted != 2 {
+		t.Fatalf("unexpected evict %d %v", evicted, err)
+	}
+	if ops := log2.get(); !equalOps(ops, []string{"evict B 4", "evict B 2"}) {
+		t.Errorf("unexpected ops %v", ops)
+	}
+}
+
+// TestEvictIdle 测试只淘汰超过IdleTTL未访问的key
+func TestEvictIdle(t *testing.T) {
+	now := time.Now()
+	log := &opLog{}
+	r := NewRegistry()
+	r.RegisterPersist("A", &mockEvict{mockPersist: mockPersist{name: "A"}, log: log, list: []AccessInfo{
+		{Key: 1, LastAccess: now.Add(-2 * time.Hour)},
+		{Key: 2, LastAccess: now},
+		{Key: 3, LastAccess: now.Add(-time.Hour * 3 / 2)},
+		{Key: 4, LastAccess: now.Add(-time.Hour / 2)},
+	}})
+
+	evicted, err := r.Evict(EvictConfig{IdleTTL: time.Hour})
+	if err != nil || evicted != 2 {
+		t.Fatalf("unexpected evict %d %v", evicted, err)
+	}
+	if ops := log.get(); !equalOps(ops, []string{"evict A 1", "evict A 3"}) {
+		t.Errorf("unexpected ops %v", ops)
+	}
+	if evicted, _ = r.Evict(EvictConfig{}); evicted != 0 {
+		t.Errorf("unexpected evict without config %d", evicted)
+	}
+}
+
+// TestEvictUser 测试用户persist按用户导出, 内存为各persist之和, key超出int32时跳过
+func TestEvictUser(t *testing.T) {
+	now := time.Now()
+	log := &opLog{}
+	r := NewRegistry()
+	a := &mockEvictUser{mockUser: newMockUser("A", log), list: []AccessInfo{
+		{Key: 1, LastAccess: now.Add(-time.Hour), Size: 100},
+		{Key: 1 << 32, LastAccess: now.Add(-2 * time.Hour), Size: 100},
+	}}
+	b := &mockEvictUser{mockUser: newMockUser("B", log), list: []AccessInfo{{Key: 1, LastAccess: now, Size: 100}}}
+	r.RegisterPersist("A", a)
+	r.RegisterPersist("B", b)
+	r.SetLoadOrder("A", 1)
+	if err := r.Load(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// 用户1最近访问时间取B的now, 只有超过内存上限时淘汰
+	evicted, err := r.Evict(EvictConfig{IdleTTL: time.Minute})
+	if err == nil || !strings.Contains(err.Error(), "user 4294967296 key overflows int32") || evicted != 0 {
+		t.Fatalf("unexpected evict %d %v", evicted, err)
+	}
+	if a.LoadState(0) != EPersistStateDisk || a.LoadState(1) != EPersistStateMemory {
+		t.Error("truncated key unloaded")
+	}
+	evicted, _ = r.Evict(EvictConfig{MaxBytes: 200})
+	if evicted != 1 || a.LoadState(1) != EPersistStateDisk || b.LoadState(1) != EPersistStateDisk {
+		t.Errorf("user not evicted %d", evicted)
+	}
+	if ops := log.get(); !equalOps(ops, []string{"load B", "load A", "unload A", "unload B"}) {
+		t.Errorf("unexpected ops %v", ops)
+	}
+}
@@ -0,0 +1,57 @@
+package core
+
+import "time"
+
+// Health persist健康状态
+type Health struct {
+	Name           string    `json:"name"`
+	State          int32     `json:"state"`               // 0:初始化  1:正常运行  2:非法停止
+	Dead           bool      `json:"dead"`                // 同Dead()
+	LastWriteBack  time.Time `json:"lastWriteBack"`       // 最后一次写回成功时间(包括空队列), 未运行时为零值
+	LastError      string    `json:"lastError,omitempty"` // 最后一次写回错误
+	LastErrorTime  time.Time `json:"lastErrorTime"`       // 最后一次写回错误时间
+	FailQueue      int       `json:"failQueue"`           // 失败队列长度, 非精确值
+	FailQueueSince time.Time `json:"failQueueSince"`      // 失败队列开始非空的时间, 为空时为零值
+	BombExist      bool      `json:"bombExist"`           // 是否存在bomb文件
+}
+
+// IPersistHealth 可选接口, 未实现时只根据Dead()判断
+type IPersistHealth interface {
+	Health() Health
+}
+
+// HealthConfig 就绪检查阈值, 0表示不检查
+type HealthConfig struct {
+	MaxWriteBackDelay time.Duration // 超过该时间没有写回成功则未就绪
+	MaxFailQueue      int           // 失败队列超过该长度则未就绪
+	MaxFailQueueAge   time.Duration // 失败队列持续非空超过该时间则未就绪
+	AllowBomb         bool          // 存在bomb文件时是否仍然就绪
+}
+
+// DefaultHealthConfig 默认阈值
+var DefaultHealthConfig = HealthConfig{
+	MaxWriteBackDelay: 30 * time.Second,
+	MaxFailQueue:      10000,
+	MaxFailQueueAge:   time.Minute,
+}
+
+// HealthReport 健康检查结果
+type HealthReport struct {
+	Live     bool     `json:"live"`              // 存活: 没有非法停止的persist
+	Ready    bool     `json:"ready"`             // 就绪: 存活且所有persist满足阈值
+	Reasons  []string `json:"reasons,omitempty"` // 未存活或未就绪的原因
+	Persists []Health `json:"persists"`
+}
+
+// GetHealth 查询persist健康状态
+func GetHealth(persist IPersist) Health {
+	if health, ok := persist.(IPersistHealth); ok {
+		return health.Health()
+	}
+	return Health{Name: persist.PersistName(), Dead: persist.Dead()}
+}
+
+// CheckHealth 汇总所有persist的健康状态
+func CheckHealth(config HealthConfig) HealthReport {
+	return gDefaultRegistry.CheckHealth(config)
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockHealth 返回固定健康状态的persist
+type mockHealth struct {
+	mockPersist
+	health Health
+}
+
+func (p *mockHealth) Health() Health { return p.health }
+
+// TestCheckHealth 测试按阈值汇总存活和就绪状态
+func TestCheckHealth(t *testing.T) {
+	now := time.Now()
+	config := HealthConfig{MaxWriteBackDelay: time.Minute, MaxFailQueue: 10, MaxFailQueueAge: time.Minute}
+	for _, c := range []struct {
+		name   string
+		health Health
+		live   bool
+		reason string
+	}{
+		{"ok", Health{LastWriteBack: now, FailQueue: 10, FailQueueSince: now}, true, ""},
+		{"not run", Health{}, true, ""},
+		{"dead", Health{Dead: true, State: 2}, false, "A: dead, state 2"},
+		{"write back", Health{LastWriteBack: now.Add(-2 * time.Minute)}, true, "A: last write back 2m0s ago"},
+		{"fail queue", Health{LastWriteBack: now, FailQueue: 11}, true, "A: fail queue 11 > 10"},
+		{"fail queue age", Health{LastWriteBack: now, FailQueue: 1, FailQueueSince: now.Add(-2 * time.Minute)}, true, "A: fail queue not empty for 2m0s"},
+		{"bomb", Health{LastWriteBack: now, BombExist: true}, true, "A: bomb file exist"},
+	} {
+		c.health.Name = "A"
+		r := NewRegistry()
+		r.RegisterPersist("A", &mockHealth{mockPersist: mockPersist{name: "A"}, health: c.health})
+		report := r.CheckHealth(config)
+		if report.Live != c.live || report.Ready != (c.reason == "") || len(report.Persists) != 1 {
+			t.Errorf("%s: unexpected report %+v", c.name, report)
+		}
+		if c.reason != "" && (len(report.Reasons) != 1 || report.Reasons[0] != c.reason) {
+			t.Errorf("%s: unexpected reasons %q", c.name, report.Reasons)
+		}
+	}
+
+	// 允许bomb文件, 0表示不检查
+	r := NewRegistry()
+	r.RegisterPersist("A", &mockHealth{mockPersist: mockPersist{name: "A"}, health: Health{Name: "A", LastWriteBack: now.Add(-time.Hour), FailQueue: 100, BombExist: true}})
+	if report := r.CheckHealth(HealthConfig{AllowBomb: true}); !report.Ready {
+		t.Errorf("unexpected reasons %q", report.Reasons)
+	}
+}
+
+// TestCheckHealthDefault 测试未实现IPersistHealth时只根据Dead判断, 结果按名字排序
+func TestCheckHealthDefault(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterPersist("B", &mockHealth{mockPersist: mockPersist{name: "B"}, health: Health{Name: "B", Dead: true}})
+	r.RegisterPersist("A", &mockPersist{name: "A"})
+
+	report := r.CheckHealth(DefaultHealthConfig)
+	if report.Live || report.Ready || len(report.Persists) != 2 || report.Persists[0] != (Health{Name: "A"}) || report.Persists[1].Name != "B" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(report.Reasons) != 1 || !strings.HasPrefix(report.Reasons[0], "B: dead") {
+		t.Errorf("unexpected reasons %q", report.Reasons)
+	}
+}
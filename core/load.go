@@ -0,0 +1,19 @@
+package core
+
+import "context"
+
+// DefaultLoadAllPageSize 全导入按主键分页查询的默认每页行数
+const DefaultLoadAllPageSize = 1000
+
+// LoadProgress 全导入进度, 每导入一页回调一次
+type LoadProgress struct {
+	Persist string
+	Table   string // 正在导入的表, 不分表不分片时为空
+	Loaded  int64  // 已导入行数
+}
+
+// IPersistLoadAll 可选接口, 分页流式全导入
+type IPersistLoadAll interface {
+	SetLoadAllPageSize(size int)                                           // 每页行数, 必须在导入数据之前调用
+	LoadAllContext(ctx context.Context, progress func(LoadProgress)) error // ctx结束或出错时撤销已导入的数据
+}
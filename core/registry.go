@@ -0,0 +1,333 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Registry 一组独立的persist, 并发安全. 包级函数使用默认Registry
+type Registry struct {
+	mutex          sync.RWMutex
+	persistMap     map[string]IPersist
+	persistUserMap map[string]IPersistUser
+	persistMapLazy map[string]IPersist
+	optionalMap    map[string]bool // 启动失败不影响其他persist, 失败后注销
+	loadOrderMap   map[string]int  // 按用户导入顺序, 默认为0
+	userHooks      []UserHook
+}
+
+// NewRegistry 创建空的Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		persistMap:     make(map[string]IPersist),
+		persistUserMap: make(map[string]IPersistUser),
+		persistMapLazy: make(map[string]IPersist),
+		optionalMap:    make(map[string]bool),
+		loadOrderMap:   make(map[string]int),
+	}
+}
+
+var gDefaultRegistry = NewRegistry()
+
+// DefaultRegistry 默认Registry, 生成代码的init()注册到这里
+func DefaultRegistry() *Registry {
+	return gDefaultRegistry
+}
+
+// RegisterPersistLazy 惰性注册
+func (r *Registry) RegisterPersistLazy(name string, persist IPersist) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.persistMapLazy[name]; ok {
+		panic(errors.New("repeated register lazy persist " + name))
+	}
+	r.persistMapLazy[name] = persist
+}
+
+// RegisterPersist 注册
+func (r *Registry) RegisterPersist(name string, persist IPersist) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.persistMap[name]; ok {
+		panic(errors.New("repeated register persist " + name))
+	}
+
+	persistUser, ok := AsPersistUser(persist)
+	if ok {
+		if _, ok := r.persistUserMap[name]; ok {
+			panic(errors.New("repeated register persist user " + name))
+		}
+		r.persistUserMap[name] = persistUser
+	} else {
+	}
+	r.persistMap[name] = persist
+}
+
+// ChangeRegister 非注册 更换已经注册的
+func (r *Registry) ChangeRegister(name string, persist IPersist) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.persistMap[name]; ok {
+		r.persistMap[name] = persist
+		if persistUser, ok := AsPersistUser(persist); ok {
+			r.persistUserMap[name] = persistUser
+		} else {
+			delete(r.persistUserMap, name)
+		}
+	} else {
+
+	}
+}
+
+// unregister 注销, 包括惰性注册
+func (r *Registry) unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.persistMap, name)
+	delete(r.persistUserMap, name)
+	delete(r.persistMapLazy, name)
+}
+
+// SetOptional 标记persist为可选, 启动失败时只记录在报告中并注销, 不影响其他persist
+func (r *Registry) SetOptional(name string, optional bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if optional {
+		r.optionalMap[name] = true
+	} else {
+		delete(r.optionalMap, name)
+	}
+}
+
+// IsOptional 是否为可选persist
+func (r *Registry) IsOptional(name string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.optionalMap[name]
+}
+
+// GetIPersistByName 通过名字查找persist, 不存在返回nil
+func (r *Registry) GetIPersistByName(name string) IPersist {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	persist, ok := r.persistMap[name]
+	if ok {
+		return persist
+	} else {
+		return nil
+	}
+}
+
+// GetPersistList 注册的IPersist列表
+func (r *Registry) GetPersistList() (list []IPersist) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, persist := range r.persistMap {
+		list = append(list, persist)
+	}
+	return
+}
+
+// GetPersistUserList 注册的IPersistUser列表
+func (r *Registry) GetPersistUserList() (list []IPersistUser) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, persist := range r.persistUserMap {
+		list = append(list, persist)
+	}
+	return
+}
+
+// GetGPersistUserMap 获取注册的IPersistUser, 返回副本
+func (r *Registry) GetGPersistUserMap() map[string]IPersistUser {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	persistUserMap := make(map[string]IPersistUser, len(r.persistUserMap))
+	for name, persist := range r.persistUserMap {
+		persistUserMap[name] = persist
+	}
+	return persistUserMap
+}
+
+// SetLoadState2Memory 确定数据一致性前提下，强制设置用户数据已导入
+func (r *Registry) SetLoadState2Memory(uid int32) {
+	for _, persist := range r.GetPersistUserList() {
+		persist.SetLoadState2Memory(uid)
+	}
+	return
+}
+
+// LoadState 所有用户数据导入状态
+func (r *Registry) LoadState(uid int32) (stateList []int32) {
+	for _, persist := range r.GetPersistUserList() {
+		stateList = append(stateList, persist.LoadState(uid))
+	}
+	return
+}
+
+// DeadPersist 是否存在异常状态Persist
+func (r *Registry) DeadPersist() bool {
+	for _, persist := range r.GetPersistList() {
+		dead := persist.Dead()
+		if dead {
+			return true
+		}
+	}
+	return false
+}
+
+// ExitPersist 退出所有Persist
+func (r *Registry) ExitPersist() {
+	var wg sync.WaitGroup
+	for _, persist := range r.GetPersistList() {
+		wg.Add(1)
+		go persist.Exit(&wg)
+	}
+	wg.Wait()
+}
+
+// SyncDataPersist 所有Persist, 不安全的方式强制同步数据, 调用后不允许再修改数据
+func (r *Registry) SyncDataPersist(sentryDebug bool) error {
+	persistList := r.GetPersistList()
+
+	var wg sync.WaitGroup
+	ch := make(chan error, len(persistList))
+	for _, persist := range persistList {
+		wg.Add(1)
+		go func(persist IPersist) {
+			err := persist.SyncData(&wg, sentryDebug)
+			if err != nil {
+				ch <- errors.New(persist.PersistName() + err.Error())
+			}
+		}(persist)
+	}
+
+	wg.Wait()
+	GetReporter().Flush(time.Second * 5)
+	select {
+	case err, ok := <-ch:
+		if ok {
+			return err
+		} else {
+			return nil
+		}
+	default:
+		return nil
+	}
+}
+
+// SyncUserDataPersist 用户相关Persist, 不安全的方式强制同步数据, 调用后不允许再修改数据
+func (r *Registry) SyncUserDataPersist(uid int32, sentryDebug bool) (err error) {
+	for _, persist := range r.GetPersistUserList() {
+		err = persist.SyncUserData(uid, sentryDebug)
+		if err != nil {
+			return errors.New(persist.PersistName() + err.Error())
+		}
+	}
+	GetReporter().Flush(time.Second * 5)
+	return
+}
+
+// SegmentationPersist 检查IPersist 配置切换写入表名, 返回第一个错误
+func (r *Registry) SegmentationPersist() error { // 定时任务调用 实现切表
+	persistList := r.GetPersistList()
+
+	var wg sync.WaitGroup
+	ch := make(chan error, len(persistList))
+	for _, persist := range persistList {
+		wg.Add(1)
+		go func(persist IPersist) {
+			err := persist.Segmentation(&wg)
+			if err != nil {
+				ch <- errors.New(persist.PersistName() + err.Error())
+			}
+		}(persist)
+	}
+	wg.Wait()
+	select {
+	case err := <-ch:
+		return err
+	default:
+		return nil
+	}
+}
+
+// ExitPersistContext 退出所有Persist, 超时的persist剩余队列写入bomb文件
+func (r *Registry) ExitPersistContext(ctx context.Context) PersistResults {
+	return r.runStage(ctx, "", r.copyPersistMap(false), func(ctx context.Context, persist IPersist) error {
+		return persist.ExitContext(ctx)
+	})
+}
+
+// SyncDataPersistContext 所有Persist, 不安全的方式强制同步数据, 调用后不允许再修改数据
+func (r *Registry) SyncDataPersistContext(ctx context.Context, sentryDebug bool) PersistResults {
+	results := r.runStage(ctx, "", r.copyPersistMap(false), func(ctx context.Context, persist IPersist) error {
+		return persist.SyncDataContext(ctx, sentryDebug)
+	})
+	timeout := time.Second * 5
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	GetReporter().Flush(timeout)
+	return results
+}
+
+// CheckHealth 汇总所有persist的健康状态
+func (r *Registry) CheckHealth(config HealthConfig) (report HealthReport) {
+	persistList := r.GetPersistList()
+
+	now := time.Now()
+	report.Live = true
+	report.Ready = true
+	report.Persists = make([]Health, 0, len(persistList))
+	notReady := func(format string, a ...interface{}) {
+		report.Ready = false
+		report.Reasons = append(report.Reasons, fmt.Sprintf(format, a...))
+	}
+	for _, persist := range persistList {
+		health := GetHealth(persist)
+		report.Persists = append(report.Persists, health)
+
+		if health.Dead {
+			report.Live = false
+			notReady("%s: dead, state %d", health.Name, health.State)
+			continue
+		}
+		if config.MaxWriteBackDelay > 0 && !health.LastWriteBack.IsZero() && now.Sub(health.LastWriteBack) > config.MaxWriteBackDelay {
+			notReady("%s: last write back %s ago", health.Name, now.Sub(health.LastWriteBack).Truncate(time.Second))
+		}
+		if config.MaxFailQueue > 0 && health.FailQueue > config.MaxFailQueue {
+			notReady("%s: fail queue %d > %d", health.Name, health.FailQueue, config.MaxFailQueue)
+		}
+		if config.MaxFailQueueAge > 0 && !health.FailQueueSince.IsZero() && now.Sub(health.FailQueueSince) > config.MaxFailQueueAge {
+			notReady("%s: fail queue not empty for %s", health.Name, now.Sub(health.FailQueueSince).Truncate(time.Second))
+		}
+		if !config.AllowBomb && health.BombExist {
+			notReady("%s: bomb file exist", health.Name)
+		}
+	}
+	sort.Slice(report.Persists, func(i, j int) bool { return report.Persists[i].Name < report.Persists[j].Name })
+	return
+}
+
+// EnableMarkDetector 所有支持的persist开启未标记修改检测
+func (r *Registry) EnableMarkDetector(interval time.Duration, autoMark bool) {
+	for _, persist := range r.GetPersistList() {
+		if detector, ok := persist.(IPersistMarkDetector); ok {
+			detector.EnableMarkDetector(interval, autoMark)
+		}
+	}
+}
+
+// DisableMarkDetector 所有支持的persist关闭未标记修改检测
+func (r *Registry) DisableMarkDetector() {
+	for _, persist := range r.GetPersistList() {
+		if detector, ok := persist.(IPersistMarkDetector); ok {
+			detector.DisableMarkDetector()
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// mockPersist 测试用persist, 未实现的方法调用时panic
+type mockPersist struct {
+	IPersist
+	name    string
+	lazyErr error
+	syncErr error
+	runErr  error
+	runHook func()
+	segErr  error
+	exitErr error
+}
+
+func (p *mockPersist) PersistName() string { return p.name }
+
+func (p *mockPersist) Dead() bool { return false }
+
+func (p *mockPersist) LazyInit() error { return p.lazyErr }
+
+func (p *mockPersist) SyncContext(ctx context.Context) error { return p.syncErr }
+
+func (p *mockPersist) RunContext(ctx context.Context) error {
+	if p.runHook != nil {
+		p.runHook()
+	}
+	return p.runErr
+}
+
+func (p *mockPersist) ExitContext(ctx context.Context) error { return p.exitErr }
+
+func (p *mockPersist) Segmentation(wg *sync.WaitGroup) error {
+	defer wg.Done()
+	return p.segErr
+}
+
+// mockLazyPersist 惰性初始化时注册到所属Registry
+type mockLazyPersist struct {
+	mockPersist
+}
+
+func (p *mockLazyPersist) LazyInitRegistry(registry *Registry) error {
+	if p.lazyErr != nil {
+		return p.lazyErr
+	}
+	registry.RegisterPersist(p.name, p)
+	return nil
+}
+
+// mockUserOf 按int64 key导入导出的persist
+type mockUserOf struct {
+	mockPersist
+	mutex sync.Mutex
+	state map[int64]int32
+}
+
+func (p *mockUserOf) Load(key int64) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.state[key] = EPersistStateMemory
+	return nil
+}
+
+func (p *mockUserOf) Unload(key int64) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.state, key)
+	return nil
+}
+
+func (p *mockUserOf) SetLoadState2Memory(key int64) { _ = p.Load(key) }
+
+func (p *mockUserOf) LoadState(key int64) int32 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.state[key]
+}
+
+func (p *mockUserOf) SyncUserData(key int64, sentryDebug bool) error { return nil }
+
+func (p *mockUserOf) PersistUserNilObjInterface() interface{} { return nil }
+
+func (p *mockUserOf) PersistUserNilObjInterfaceList() interface{} { return nil }
+
+func (p *mockUserOf) PersistUser() IPersistUser {
+	return NewPersistUser[int64](p, func(uid int32) int64 { return int64(uid) + 1000 })
+}
+
+func expectPanic(t *testing.T, name string, fn func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s: expected panic", name)
+		}
+	}()
+	fn()
+}
+
+// TestRegistryIsolated 测试Registry之间相互独立, 重复注册panic
+func TestRegistryIsolated(t *testing.T) {
+	r1, r2 := NewRegistry(), NewRegistry()
+	a := &mockPersist{name: "A"}
+	r1.RegisterPersist("A", a)
+	r2.RegisterPersist("A", &mockPersist{name: "A"})
+
+	if r1.GetIPersistByName("A") != a || r2.GetIPersistByName("A") == a {
+		t.Error("registries share persist")
+	}
+	if r1.GetIPersistByName("B") != nil {
+		t.Error("unexpected persist")
+	}
+	if DefaultRegistry().GetIPersistByName("A") != nil {
+		t.Error("default registry changed")
+	}
+
+	expectPanic(t, "RegisterPersist", func() { r1.RegisterPersist("A", a) })
+	r1.RegisterPersistLazy("A", a)
+	expectPanic(t, "RegisterPersistLazy", func() { r1.RegisterPersistLazy("A", a) })
+
+	b := &mockPersist{name: "A"}
+	r1.ChangeRegister("A", b)
+	r1.ChangeRegister("C", b)
+	if r1.GetIPersistByName("A") != b || r1.GetIPersistByName("C") != nil || len(r1.GetPersistList()) != 1 {
+		t.Error("unexpected ChangeRegister")
+	}
+}
+
+// TestRegistryPersistUser 测试IPersistUserProvider适配的用户persist
+func TestRegistryPersistUser(t *testing.T) {
+	r := NewRegistry()
+	user := &mockUserOf{mockPersist: mockPersist{name: "User"}, state: map[int64]int32{}}
+	r.RegisterPersist("User", user)
+	r.RegisterPersist("Global", &mockPersist{name: "Global"})
+
+	if len(r.GetPersistList()) != 2 || len(r.GetPersistUserList()) != 1 {
+		t.Fatalf("unexpected persist lists %d %d", len(r.GetPersistList()), len(r.GetPersistUserList()))
+	}
+	if err := r.Load(1); err != nil {
+		t.Fatal(err)
+	}
+	if user.LoadState(1001) != EPersistStateMemory {
+		t.Error("key not converted")
+	}
+	if states := r.LoadState(1); len(states) != 1 || states[0] != EPersistStateMemory {
+		t.Errorf("unexpected states %v", states)
+	}
+
+	// 替换为非用户persist后不再按用户导入
+	r.ChangeRegister("User", &mockPersist{name: "User"})
+	if len(r.GetGPersistUserMap()) != 0 {
+		t.Error("user persist not removed")
+	}
+}
+
+// TestRegistrySegmentation 测试切表返回第一个错误
+func TestRegistrySegmentation(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterPersist("A", &mockPersist{name: "A"})
+	if err := r.SegmentationPersist(); err != nil {
+		t.Fatal(err)
+	}
+	segErr := errors.New(" segmentation failed")
+	r.RegisterPersist("B", &mockPersist{name: "B", segErr: segErr})
+	if err := r.SegmentationPersist(); err == nil || err.Error() != "B segmentation failed" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// TestRegistryLazyInit 测试惰性初始化注册到所属Registry
+func TestRegistryLazyInit(t *testing.T) {
+	r := NewRegistry()
+	lazy := &mockLazyPersist{mockPersist: mockPersist{name: "Lazy"}}
+	r.RegisterPersistLazy("Lazy", lazy)
+
+	if err := r.SyncPersistReport(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if r.GetIPersistByName("Lazy") != lazy {
+		t.Error("lazy persist not registered")
+	}
+	if DefaultRegistry().GetIPersistByName("Lazy") != nil {
+		t.Error("lazy persist registered to default registry")
+	}
+	// 成功后不再惰性初始化
+	if report := r.SyncPersistReport(context.Background()); len(report.Results) != 1 || report.Results[0].Stage != EStartupStageSync {
+		t.Errorf("unexpected report %s", report)
+	}
+}
@@ -0,0 +1,18 @@
+package core
+
+import "time"
+
+// ReplicaMode 按key导入时的从库策略
+type ReplicaMode int8
+
+const (
+	EReplicaModePrimary   ReplicaMode = 0 // 按key导入总是读主库
+	EReplicaModeStaleness ReplicaMode = 1 // 最近MaxStaleness内写回过的key读主库, 否则读从库
+	EReplicaModeReplica   ReplicaMode = 2 // 按key导入总是读从库
+)
+
+// ReplicaPolicy 从库策略, 全导入读从库, 重放bomb后MaxStaleness内读主库
+type ReplicaPolicy struct {
+	Mode         ReplicaMode
+	MaxStaleness time.Duration // 从库最大延迟
+}
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// ReportKind 上报事件类型
+type ReportKind int8
+
+const (
+	EReportKindMismatch ReportKind = 1 // 内存和数据库数据不一致
+	EReportKindError    ReportKind = 2 // 同步数据出错
+	EReportKindUnmarked ReportKind = 3 // 修改了对象但没有标记
+)
+
+func (k ReportKind) String() string {
+	switch k {
+	case EReportKindMismatch:
+		return "mismatch"
+	case EReportKindError:
+		return "error"
+	case EReportKindUnmarked:
+		return "unmarked"
+	default:
+		return "unknown"
+	}
+}
+
+// ReportEvent 一致性检查上报事件
+type ReportEvent struct {
+	Kind    ReportKind  `json:"kind"`
+	Persist string      `json:"persist"`          // persist名
+	Pk      interface{} `json:"pk,omitempty"`     // 主键结构体, 全表检查出错时为nil
+	MemJson string      `json:"mem,omitempty"`    // 内存数据json
+	DBJson  string      `json:"db,omitempty"`     // 数据库数据json
+	Fields  []FieldDiff `json:"fields,omitempty"` // 不一致的字段, EReportKindUnmarked时DB为最后一次标记时的值
+	Err     error       `json:"-"`                // 错误, 仅EReportKindError
+	Time    time.Time   `json:"time"`
+}
+
+// Reporter 一致性检查上报接口, 实现必须支持并发调用
+type Reporter interface {
+	Report(event *ReportEvent)        // 上报一个事件
+	Flush(timeout time.Duration) bool // 等待上报完成, 超时返回false
+}
+
+// SentryReporter 上报到sentry, 默认Reporter
+type SentryReporter struct{}
+
+func (SentryReporter) Report(event *ReportEvent) {
+	sentry.WithScope(func(scope *sentry.Scope) {
+		switch event.Kind {
+		case EReportKindMismatch:
+			tag := "CompareError" + event.Persist
+			scope.SetTag(tag, event.Persist)
+			scope.SetTag("transaction", event.Persist)
+			scope.SetExtra("memClsJson", event.MemJson)
+			scope.SetExtra("dbClsJson", event.DBJson)
+			scope.SetExtra("fields", event.Fields)
+			sentry.CaptureMessage(tag)
+		case EReportKindUnmarked:
+			tag := "UnmarkedError" + event.Persist
+			scope.SetTag(tag, event.Persist)
+			scope.SetTag("transaction", event.Persist)
+			scope.SetExtra("fields", event.Fields)
+			sentry.CaptureMessage(tag)
+		default:
+			tag := "SyncDataError" + event.Persist
+			scope.SetTag("SyncDataError", event.Persist)
+			scope.SetTag("transaction", event.Persist)
+			if event.Err != nil {
+				scope.SetExtra(event.Err.Error(), 1)
+			}
+			sentry.CaptureMessage(tag)
+		}
+	})
+}
+
+func (SentryReporter) Flush(timeout time.Duration) bool {
+	return sentry.Flush(timeout)
+}
+
+// LogReporter 输出到日志, Logger为nil时使用标准库默认logger
+type LogReporter struct {
+	Logger *log.Logger
+}
+
+func (r LogReporter) Report(event *ReportEvent) {
+	logf := log.Printf
+	if r.Logger != nil {
+		logf = r.Logger.Printf
+	}
+	switch event.Kind {
+	case EReportKindMismatch, EReportKindUnmarked:
+		pk, _ := json.Marshal(event.Pk)
+		fields, _ := json.Marshal(event.Fields)
+		logf("[persist report %s] %s pk=%s fields=%s mem=%s db=%s", event.Kind, event.Persist, pk, fields, event.MemJson, event.DBJson)
+	default:
+		logf("[persist report %s] %s err=%v", event.Kind, event.Persist, event.Err)
+	}
+}
+
+func (r LogReporter) Flush(timeout time.Duration) bool {
+	return true
+}
+
+// MemoryReporter 保存在内存中, 用于测试断言
+type MemoryReporter struct {
+	mu     sync.Mutex
+	events []*ReportEvent
+}
+
+func (r *MemoryReporter) Report(event *ReportEvent) {
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+}
+
+func (r *MemoryReporter) Flush(timeout time.Duration) bool {
+	return true
+}
+
+// Events 已上报事件的副本
+func (r *MemoryReporter) Events() []*ReportEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*ReportEvent(nil), r.events...)
+}
+
+// Reset 清空已上报事件
+func (r *MemoryReporter) Reset() {
+	r.mu.Lock()
+	r.events = nil
+	r.mu.Unlock()
+}
+
+type reporterHolder struct {
+	Reporter
+}
+
+var gReporter atomic.Value
+
+func init() {
+	gReporter.Store(reporterHolder{SentryReporter{}})
+}
+
+// SetReporter 设置全局Reporter, nil恢复为SentryReporter
+func SetReporter(r Reporter) {
+	if r == nil {
+		r = SentryReporter{}
+	}
+	gReporter.Store(reporterHolder{r})
+}
+
+// GetReporter 获取全局Reporter
+func GetReporter() Reporter {
+	return gReporter.Load().(reporterHolder).Reporter
+}
+
+// Report 通过全局Reporter上报
+func Report(event *ReportEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	GetReporter().Report(event)
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReporter 测试设置全局Reporter, nil恢复为SentryReporter, 上报时补充时间
+func TestReporter(t *testing.T) {
+	defer SetReporter(nil)
+	if _, ok := GetReporter().(SentryReporter); !ok {
+		t.Fatalf("unexpected default reporter %T", GetReporter())
+	}
+
+	r := &MemoryReporter{}
+	SetReporter(r)
+	if GetReporter() != r {
+		t.Fatal("reporter not set")
+	}
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	Report(&ReportEvent{Kind: EReportKindMismatch, Persist: "A"})
+	Report(&ReportEvent{Kind: EReportKindError, Persist: "B", Time: at})
+	events := r.Events()
+	if len(events) != 2 || events[0].Persist != "A" || events[0].Time.IsZero() || events[1].Time != at {
+		t.Errorf("unexpected events %+v", events)
+	}
+	// 返回副本
+	events[0] = nil
+	if r.Events()[0] == nil {
+		t.Error("events not copied")
+	}
+	r.Reset()
+	if len(r.Events()) != 0 || !r.Flush(time.Second) {
+		t.Error("events not reset")
+	}
+
+	SetReporter(nil)
+	if _, ok := GetReporter().(SentryReporter); !ok {
+		t.Errorf("unexpected reporter %T after reset", GetReporter())
+	}
+}
+
+// TestLogReporter 测试日志格式
+func TestLogReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := LogReporter{Logger: log.New(&buf, "", 0)}
+	r.Report(&ReportEvent{
+		Kind:    EReportKindMismatch,
+		Persist: "A",
+		Pk:      struct{ Uid int64 }{1},
+		Fields:  []FieldDiff{{Field: "Name", Mem: "a", DB: "b"}},
+		MemJson: `{"Name":"a"}`,
+		DBJson:  `{"Name":"b"}`,
+	})
+	r.Report(&ReportEvent{Kind: EReportKindError, Persist: "A", Err: errors.New("failed")})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := []string{
+		`[persist report mismatch] A pk={"Uid":1} fields=[{"field":"Name","mem":"a","db":"b"}] mem={"Name":"a"} db={"Name":"b"}`,
+		`[persist report error] A err=failed`,
+	}
+	if !equalOps(lines, expected) {
+		t.Errorf("unexpected log %q", lines)
+	}
+	if ReportKind(0).String() != "unknown" || EReportKindUnmarked.String() != "unmarked" {
+		t.Error("unexpected kind string")
+	}
+}
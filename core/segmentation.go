@@ -0,0 +1,79 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// SegmentationMode 分表方式
+type SegmentationMode int8
+
+const (
+	ESegmentationModeNone    SegmentationMode = 0 // 不分表
+	ESegmentationModeDaily   SegmentationMode = 1 // 按天, 表名后缀 _20060102
+	ESegmentationModeMonthly SegmentationMode = 2 // 按月, 表名后缀 _200601
+	ESegmentationModeRows    SegmentationMode = 3 // 按行数, 第一张表为原表, 之后后缀 _1 _2 ...
+)
+
+// ESyncFlagTable 序列化PersistSync时op字节的标记位, 表示包含分表表名
+const ESyncFlagTable uint8 = 0b01000000
+
+// SegmentationConfig 分表配置
+type SegmentationConfig struct {
+	Mode    SegmentationMode
+	MaxRows int64 // ESegmentationModeRows 单表最大行数, 达到后切换到下一张表
+}
+
+// TableName 分表表名, 按时间分表使用t, 按行数分表使用seq
+func (c SegmentationConfig) TableName(base string, t time.Time, seq int) string {
+	switch c.Mode {
+	case ESegmentationModeDaily:
+		return base + "_" + t.Format("20060102")
+	case ESegmentationModeMonthly:
+		return base + "_" + t.Format("200601")
+	case ESegmentationModeRows:
+		if seq <= 0 {
+			return base
+		}
+		return base + "_" + strconv.Itoa(seq)
+	default:
+		return base
+	}
+}
+
+// PrevTime 按时间分表时, 上一个分表所在的时间
+func (c SegmentationConfig) PrevTime(t time.Time) time.Time {
+	switch c.Mode {
+	case ESegmentationModeDaily:
+		return t.AddDate(0, 0, -1)
+	case ESegmentationModeMonthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()).AddDate(0, -1, 0)
+	default:
+		return t
+	}
+}
+
+// IPersistSegmentation 可选接口, 分表配置
+type IPersistSegmentation interface {
+	SetSegmentation(config SegmentationConfig) error // 必须在Run和导入数据之前调用
+	TableName() string                               // 当前写入表名, 不分表为空
+}
+
+// SetSegmentation 设置persist分表配置
+func (r *Registry) SetSegmentation(name string, config SegmentationConfig) error {
+	persist := r.GetIPersistByName(name)
+	if persist == nil {
+		return errors.New("persist not found " + name)
+	}
+	segmentation, ok := persist.(IPersistSegmentation)
+	if !ok {
+		return errors.New("persist does not support segmentation " + name)
+	}
+	return segmentation.SetSegmentation(config)
+}
+
+// SetSegmentation 设置persist分表配置
+func SetSegmentation(name string, config SegmentationConfig) error {
+	return gDefaultRegistry.SetSegmentation(name, config)
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSegmentationTableName 测试分表表名和上一个分表的时间
+func TestSegmentationTableName(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.Local)
+	for _, c := range []struct {
+		config SegmentationConfig
+		seq    int
+		table  string
+		prev   time.Time
+	}{
+		{SegmentationConfig{}, 1, "t", now},
+		{SegmentationConfig{Mode: ESegmentationModeDaily}, 0, "t_20240331", time.Date(2024, 3, 30, 12, 0, 0, 0, time.Local)},
+		{SegmentationConfig{Mode: ESegmentationModeMonthly}, 0, "t_202403", time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)},
+		{SegmentationConfig{Mode: ESegmentationModeRows}, 0, "t", now},
+		{SegmentationConfig{Mode: ESegmentationModeRows}, 2, "t_2", now},
+	} {
+		if table := c.config.TableName("t", now, c.seq); table != c.table {
+			t.Errorf("mode %d seq %d: unexpected table %s", c.config.Mode, c.seq, table)
+		}
+		if prev := c.config.PrevTime(now); !prev.Equal(c.prev) {
+			t.Errorf("mode %d: unexpected prev time %s", c.config.Mode, prev)
+		}
+	}
+}
+
+// TestRegistrySetSegmentation 测试按名字设置分表, 未注册或者不支持时报错
+func TestRegistrySetSegmentation(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterPersist("A", &mockPersist{name: "A"})
+	if err := r.SetSegmentation("A", SegmentationConfig{}); err == nil || err.Error() != "persist does not support segmentation A" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := r.SetSegmentation("B", SegmentationConfig{}); err == nil || err.Error() != "persist not found B" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
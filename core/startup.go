@@ -0,0 +1,217 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StartupStage 启动阶段
+type StartupStage string
+
+const (
+	EStartupStageLazyInit StartupStage = "lazyInit" // 惰性初始化
+	EStartupStageSync     StartupStage = "sync"     // 同步表结构
+	EStartupStageRun      StartupStage = "run"      // 运行并导入上次失败数据
+)
+
+// StartupReport 启动报告, 包含每个persist每个阶段的结果
+type StartupReport struct {
+	Results  PersistResults `json:"results"`
+	Duration time.Duration  `json:"duration"`
+}
+
+// Failed 失败的结果, 包括可选persist
+func (r *StartupReport) Failed() (list PersistResults) {
+	for _, result := range r.Results {
+		if result.Err != nil {
+			list = append(list, result)
+		}
+	}
+	return
+}
+
+// Err 所有非可选persist的错误, 全部成功返回nil
+func (r *StartupReport) Err() error {
+	var msgList []string
+	for _, result := range r.Failed() {
+		if !result.Optional {
+			msgList = append(msgList, fmt.Sprintf("%s(%s): %v", result.Name, result.Stage, result.Err))
+		}
+	}
+	if len(msgList) == 0 {
+		return nil
+	}
+	return errors.New("persist startup failed: " + strings.Join(msgList, "; "))
+}
+
+// String 用于日志输出
+func (r *StartupReport) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "persist startup %s", r.Duration)
+	for _, result := range r.Results {
+		status := "ok"
+		if result.Err != nil {
+			status = result.Err.Error()
+			if result.Optional {
+				status = "optional, " + status
+			}
+		}
+		fmt.Fprintf(&builder, "\n\t%-8s %-24s %10s %s", result.Stage, result.Name, result.Duration.Truncate(time.Microsecond), status)
+	}
+	return builder.String()
+}
+
+// copyPersistMap 注册的persist副本
+func (r *Registry) copyPersistMap(lazy bool) map[string]IPersist {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	src := r.persistMap
+	if lazy {
+		src = r.persistMapLazy
+	}
+	dst := make(map[string]IPersist, len(src))
+	for name, persist := range src {
+		dst[name] = persist
+	}
+	return dst
+}
+
+// runStage 并发执行, 捕获panic, 记录每个persist的错误和耗时
+func (r *Registry) runStage(ctx context.Context, stage StartupStage, persistMap map[string]IPersist, fn func(ctx context.Context, persist IPersist) error) PersistResults {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	results := make(PersistResults, 0, len(persistMap))
+	for name, persist := range persistMap {
+		wg.Add(1)
+		go func(name string, persist IPersist) {
+			defer wg.Done()
+			result := PersistResult{Name: name, Stage: stage, Optional: r.IsOptional(name)}
+			bTime := time.Now()
+			func() {
+				defer func() {
+					if p := recover(); p != nil {
+						result.Err = fmt.Errorf("panic: %v", p)
+					}
+				}()
+				result.Err = fn(ctx, persist)
+			}()
+			if result.Err != nil {
+				result.Error = result.Err.Error()
+			}
+			result.Duration = time.Since(bTime)
+			mutex.Lock()
+			results = append(results, result)
+			mutex.Unlock()
+		}(name, persist)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
+
+// superviseStage 执行一个启动阶段, 失败的可选persist注销
+func (r *Registry) superviseStage(ctx context.Context, report *StartupReport, stage StartupStage, persistMap map[string]IPersist, fn func(ctx context.Context, persist IPersist) error) {
+	results := r.runStage(ctx, stage, persistMap, fn)
+	for _, result := range results {
+		if result.Err != nil && result.Optional {
+			log.Println("persist startup optional persist failed, unregister", result.Name, result.Stage, result.Err)
+			r.unregister(result.Name)
+		}
+	}
+	report.Results = append(report.Results, results...)
+}
+
+// SyncPersistReport 并发惰性初始化并同步结构, 返回启动报告
+func (r *Registry) SyncPersistReport(ctx context.Context) *StartupReport {
+	bTime := time.Now()
+	report := &StartupReport{}
+	lazyMap := r.copyPersistMap(true)
+	r.superviseStage(ctx, report, EStartupStageLazyInit, lazyMap, func(ctx context.Context, persist IPersist) error {
+		if lazy, ok := persist.(IPersistLazyRegistry); ok {
+			return WaitContext(ctx, func() error { return lazy.LazyInitRegistry(r) })
+		}
+		return WaitContext(ctx, persist.LazyInit)
+	})
+	r.mutex.Lock()
+	for _, result := range report.Results {
+		if result.Err == nil {
+			delete(r.persistMapLazy, result.Name)
+		}
+	}
+	r.mutex.Unlock()
+
+	// 惰性初始化失败的persist没有注册, 不会再同步
+	r.superviseStage(ctx, report, EStartupStageSync, r.copyPersistMap(false), func(ctx context.Context, persist IPersist) error {
+		return persist.SyncContext(ctx)
+	})
+	report.Duration = time.Since(bTime)
+	return report
+}
+
+// RunPersistReport 并发运行所有Persist, 返回启动报告
+func (r *Registry) RunPersistReport(ctx context.Context) *StartupReport {
+	bTime := time.Now()
+	report := &StartupReport{}
+	r.superviseStage(ctx, report, EStartupStageRun, r.copyPersistMap(false), func(ctx context.Context, persist IPersist) error {
+		return persist.RunContext(ctx)
+	})
+	report.Duration = time.Since(bTime)
+	return report
+}
+
+// Startup 惰性初始化, 同步结构并运行. 存在非可选persist失败时不运行
+func (r *Registry) Startup(ctx context.Context) *StartupReport {
+	bTime := time.Now()
+	report := r.SyncPersistReport(ctx)
+	if report.Err() == nil {
+		report.Results = append(report.Results, r.RunPersistReport(ctx).Results...)
+	}
+	report.Duration = time.Since(bTime)
+	return report
+}
+
+// SyncPersist 所有Persist同步结构
+func (r *Registry) SyncPersist() error {
+	return r.SyncPersistReport(context.Background()).Err()
+}
+
+// RunPersist 运行所有Persist
+func (r *Registry) RunPersist() error {
+	return r.RunPersistReport(context.Background()).Err()
+}
+
+// SyncPersistContext 所有Persist同步结构
+func (r *Registry) SyncPersistContext(ctx context.Context) PersistResults {
+	return r.SyncPersistReport(ctx).Results
+}
+
+// RunPersistContext 运行所有Persist
+func (r *Registry) RunPersistContext(ctx context.Context) PersistResults {
+	return r.RunPersistReport(ctx).Results
+}
+
+// SetOptionalPersist 标记persist为可选, 启动失败时只记录在报告中并注销
+func SetOptionalPersist(name string, optional bool) {
+	gDefaultRegistry.SetOptional(name, optional)
+}
+
+// SyncPersistReport 并发惰性初始化并同步结构, 返回启动报告
+func SyncPersistReport(ctx context.Context) *StartupReport {
+	return gDefaultRegistry.SyncPersistReport(ctx)
+}
+
+// RunPersistReport 并发运行所有Persist, 返回启动报告
+func RunPersistReport(ctx context.Context) *StartupReport {
+	return gDefaultRegistry.RunPersistReport(ctx)
+}
+
+// Startup 惰性初始化, 同步结构并运行
+func Startup(ctx context.Context) *StartupReport {
+	return gDefaultRegistry.Startup(ctx)
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestStartupOptional 测试可选persist失败时注销, 不影响启动
+func TestStartupOptional(t *testing.T) {
+	r := NewRegistry()
+	var runs int32
+	run := func() { atomic.AddInt32(&runs, 1) }
+	r.RegisterPersist("A", &mockPersist{name: "A", runHook: run})
+	r.RegisterPersist("B", &mockPersist{name: "B", runHook: run, syncErr: errors.New("sync failed")})
+	r.RegisterPersistLazy("C", &mockLazyPersist{mockPersist: mockPersist{name: "C", lazyErr: errors.New("lazy failed")}})
+	r.SetOptional("B", true)
+	r.SetOptional("C", true)
+
+	report := r.Startup(context.Background())
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if failed := report.Failed(); len(failed) != 2 || failed[0].Name != "C" || failed[0].Stage != EStartupStageLazyInit || failed[1].Name != "B" || failed[1].Stage != EStartupStageSync {
+		t.Errorf("unexpected failed %s", report)
+	}
+	if r.GetIPersistByName("B") != nil || len(r.copyPersistMap(true)) != 0 {
+		t.Error("optional persist not unregistered")
+	}
+	if runs != 1 {
+		t.Errorf("unexpected runs %d", runs)
+	}
+}
+
+// TestStartupFailed 测试非可选persist失败时汇总所有错误且不运行
+func TestStartupFailed(t *testing.T) {
+	r := NewRegistry()
+	var runs int32
+	run := func() { atomic.AddInt32(&runs, 1) }
+	r.RegisterPersist("A", &mockPersist{name: "A", runHook: run, syncErr: errors.New("a failed")})
+	r.RegisterPersist("B", &mockPersist{name: "B", runHook: run, syncErr: errors.New("b failed")})
+
+	report := r.Startup(context.Background())
+	err := report.Err()
+	if err == nil || !strings.Contains(err.Error(), "A(sync): a failed") || !strings.Contains(err.Error(), "B(sync): b failed") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if runs != 0 {
+		t.Errorf("unexpected runs %d", runs)
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"name":"A","stage":"sync","optional":false,"error":"a failed"`) || !strings.Contains(string(data), `"error":"b failed"`) {
+		t.Errorf("unexpected json %s", data)
+	}
+	if r.GetIPersistByName("A") == nil {
+		t.Error("persist unregistered")
+	}
+}
+
+// TestStartupPanic 测试捕获panic
+func TestStartupPanic(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterPersist("A", &mockPersist{name: "A", runHook: func() { panic("run panic") }})
+
+	results := r.RunPersistReport(context.Background()).Failed()
+	if len(results) != 1 || results[0].Stage != EStartupStageRun || !strings.Contains(results[0].Err.Error(), "run panic") {
+		t.Errorf("unexpected results %v", results)
+	}
+	if err := r.RunPersist(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// TestStartupContext 测试ctx结束时惰性初始化返回
+func TestStartupContext(t *testing.T) {
+	r := NewRegistry()
+	block := make(chan struct{})
+	defer close(block)
+	r.RegisterPersistLazy("A", &blockLazyPersist{mockPersist: mockPersist{name: "A"}, block: block})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	failed := r.SyncPersistReport(ctx).Failed()
+	if len(failed) != 1 || !errors.Is(failed[0].Err, context.Canceled) {
+		t.Errorf("unexpected failed %v", failed)
+	}
+	if len(r.copyPersistMap(true)) != 1 {
+		t.Error("unfinished lazy persist removed")
+	}
+}
+
+// blockLazyPersist 惰性初始化阻塞到block关闭
+type blockLazyPersist struct {
+	mockPersist
+	block chan struct{}
+}
+
+func (p *blockLazyPersist) LazyInit() error {
+	<-p.block
+	return nil
+}
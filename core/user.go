@@ -0,0 +1,62 @@
+package core
+
+// IPersistUserOf 按K类型key导入导出的persist, 通过NewPersistUser适配为IPersistUser参与按用户导入导出
+type IPersistUserOf[K comparable] interface {
+	IPersist
+	Load(key K) (err error)
+	Unload(key K) (err error)
+	SetLoadState2Memory(key K)
+	LoadState(key K) int32
+	SyncUserData(key K, sentryDebug bool) (err error)
+	PersistUserNilObjInterface() interface{}
+	PersistUserNilObjInterfaceList() interface{}
+}
+
+// IPersistUserProvider 可选接口, key不是int32的persist提供适配后的IPersistUser, 注册时使用
+type IPersistUserProvider interface {
+	PersistUser() IPersistUser
+}
+
+// persistUserAdapter 用户uid转换为key后调用IPersistUserOf
+type persistUserAdapter[K comparable] struct {
+	IPersistUserOf[K]
+	key func(uid int32) K
+}
+
+// NewPersistUser 适配为IPersistUser, key为用户uid到persist key的转换
+func NewPersistUser[K comparable](persist IPersistUserOf[K], key func(uid int32) K) IPersistUser {
+	return &persistUserAdapter[K]{IPersistUserOf: persist, key: key}
+}
+
+func (a *persistUserAdapter[K]) Load(uid int32) error {
+	return a.IPersistUserOf.Load(a.key(uid))
+}
+
+func (a *persistUserAdapter[K]) Unload(uid int32) error {
+	return a.IPersistUserOf.Unload(a.key(uid))
+}
+
+func (a *persistUserAdapter[K]) SetLoadState2Memory(uid int32) {
+	a.IPersistUserOf.SetLoadState2Memory(a.key(uid))
+}
+
+func (a *persistUserAdapter[K]) LoadState(uid int32) int32 {
+	return a.IPersistUserOf.LoadState(a.key(uid))
+}
+
+func (a *persistUserAdapter[K]) SyncUserData(uid int32, sentryDebug bool) error {
+	return a.IPersistUserOf.SyncUserData(a.key(uid), sentryDebug)
+}
+
+// AsPersistUser persist本身实现IPersistUser或者提供适配后的IPersistUser
+func AsPersistUser(persist IPersist) (IPersistUser, bool) {
+	if persistUser, ok := persist.(IPersistUser); ok {
+		return persistUser, true
+	}
+	if provider, ok := persist.(IPersistUserProvider); ok {
+		if persistUser := provider.PersistUser(); persistUser != nil {
+			return persistUser, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+)
+
+// mockUserOfString 按string key导入导出的persist, 记录调用的key
+type mockUserOfString struct {
+	mockPersist
+	log   *opLog
+	state map[string]int32
+}
+
+func (p *mockUserOfString) Load(key string) error {
+	p.log.add("load " + key)
+	p.state[key] = EPersistStateMemory
+	return nil
+}
+
+func (p *mockUserOfString) Unload(key string) error {
+	p.log.add("unload " + key)
+	delete(p.state, key)
+	return nil
+}
+
+func (p *mockUserOfString) SetLoadState2Memory(key string) {
+	p.log.add("memory " + key)
+	p.state[key] = EPersistStateMemory
+}
+
+func (p *mockUserOfString) LoadState(key string) int32 { return p.state[key] }
+
+func (p *mockUserOfString) SyncUserData(key string, sentryDebug bool) error {
+	p.log.add("sync " + key + " " + strconv.FormatBool(sentryDebug))
+	return nil
+}
+
+func (p *mockUserOfString) PersistUserNilObjInterface() interface{} { return nil }
+
+func (p *mockUserOfString) PersistUserNilObjInterfaceList() interface{} { return nil }
+
+// mockNilProvider 不提供IPersistUser的persist
+type mockNilProvider struct {
+	mockPersist
+}
+
+func (p *mockNilProvider) PersistUser() IPersistUser { return nil }
+
+// TestNewPersistUser 测试适配后每个方法都把uid转换为key
+func TestNewPersistUser(t *testing.T) {
+	log := &opLog{}
+	p := &mockUserOfString{mockPersist: mockPersist{name: "A"}, log: log, state: map[string]int32{}}
+	user := NewPersistUser[string](p, func(uid int32) string { return "u" + strconv.Itoa(int(uid)) })
+
+	if err := user.Load(1); err != nil {
+		t.Fatal(err)
+	}
+	if user.LoadState(1) != EPersistStateMemory || user.LoadState(2) != EPersistStateDisk {
+		t.Error("unexpected load state")
+	}
+	user.SetLoadState2Memory(2)
+	if err := user.SyncUserData(1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.Unload(1); err != nil {
+		t.Fatal(err)
+	}
+	if user.LoadState(1) != EPersistStateDisk || user.PersistName() != "A" {
+		t.Error("unexpected state after unload")
+	}
+	if ops := log.get(); !equalOps(ops, []string{"load u1", "memory u2", "sync u1 true", "unload u1"}) {
+		t.Errorf("unexpected ops %v", ops)
+	}
+}
+
+// TestAsPersistUser 测试识别用户persist和提供适配的persist
+func TestAsPersistUser(t *testing.T) {
+	direct := newMockUser("A", &opLog{})
+	if user, ok := AsPersistUser(direct); !ok || user != direct {
+		t.Error("direct user persist not found")
+	}
+	if user, ok := AsPersistUser(&mockUserOf{mockPersist: mockPersist{name: "B"}, state: map[int64]int32{}}); !ok || user == nil {
+		t.Error("provided user persist not found")
+	}
+	if _, ok := AsPersistUser(&mockNilProvider{mockPersist{name: "C"}}); ok {
+		t.Error("nil provider is user persist")
+	}
+	if _, ok := AsPersistUser(&mockPersist{name: "D"}); ok {
+		t.Error("global persist is user persist")
+	}
+}
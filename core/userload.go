@@ -0,0 +1,286 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unloadRollbackTimeout 导出失败回滚时, 等待已经接受导出的persist完成导出再重新导入的最长时间
+const unloadRollbackTimeout = 5 * time.Second
+
+// UserHook 按用户导入导出钩子, 在Registry.Load/Unload所有IPersistUser前后调用
+type UserHook struct {
+	BeforeLoad   func(uid int32) error // 导入之前, 返回错误时取消导入
+	AfterLoad    func(uid int32) error // 所有persist导入成功之后, 返回错误时导出所有persist
+	BeforeUnload func(uid int32)       // 导出之前
+	AfterUnload  func(uid int32)       // 所有persist接受导出之后, 数据在写回队列中异步移出内存
+}
+
+// PersistErrors 多个persist按用户导入导出失败, key为persist名字
+type PersistErrors struct {
+	Op       string           // load 或 unload
+	Errs     map[string]error // 失败的persist
+	Rollback map[string]error // 回滚失败的persist, 这些persist的用户数据状态不一致
+}
+
+func (e *PersistErrors) Error() string {
+	var b strings.Builder
+	b.WriteString("persist: ")
+	b.WriteString(e.Op)
+	b.WriteString(" failed:")
+	for _, name := range sortedNames(e.Errs) {
+		b.WriteString(" ")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(e.Errs[name].Error())
+		b.WriteString(";")
+	}
+	for _, name := range sortedNames(e.Rollback) {
+		b.WriteString(" rollback ")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(e.Rollback[name].Error())
+		b.WriteString(";")
+	}
+	return b.String()
+}
+
+// Unwrap 所有失败原因, 支持errors.Is
+func (e *PersistErrors) Unwrap() []error {
+	var errs []error
+	for _, name := range sortedNames(e.Errs) {
+		errs = append(errs, e.Errs[name])
+	}
+	return errs
+}
+
+func sortedNames(errs map[string]error) []string {
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddUserHook 添加按用户导入导出钩子, 按添加顺序调用
+func (r *Registry) AddUserHook(hook UserHook) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.userHooks = append(r.userHooks[:len(r.userHooks):len(r.userHooks)], hook)
+}
+
+func (r *Registry) getUserHooks() []UserHook {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.userHooks
+}
+
+// SetLoadOrder 设置按用户导入的顺序, order小的先导入, 相同order并发导入, 导出顺序相反. 默认为0
+func (r *Registry) SetLoadOrder(name string, order int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if order == 0 {
+		delete(r.loadOrderMap, name)
+	} else {
+		r.loadOrderMap[name] = order
+	}
+}
+
+// userPersistGroups 按导入顺序分组的IPersistUser
+func (r *Registry) userPersistGroups() (groups [][]IPersistUser) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	orderMap := make(map[int][]IPersistUser)
+	var orders []int
+	for name, persist := range r.persistUserMap {
+		order := r.loadOrderMap[name]
+		if _, ok := orderMap[order]; !ok {
+			orders = append(orders, order)
+		}
+		orderMap[order] = append(orderMap[order], persist)
+	}
+	sort.Ints(orders)
+	for _, order := range orders {
+		groups = append(groups, orderMap[order])
+	}
+	return
+}
+
+// runUserGroup 并发执行一组persist, 返回成功和失败的persist
+func runUserGroup(group []IPersistUser, fn func(persist IPersistUser) error) (done []IPersistUser, errs map[string]error) {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, persist := range group {
+		wg.Add(1)
+		go func(persist IPersistUser) {
+			defer wg.Done()
+			err := fn(persist)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if errs == nil {
+					errs = make(map[string]error)
+				}
+				errs[persist.PersistName()] = err
+			} else {
+				done = append(done, persist)
+			}
+		}(persist)
+	}
+	wg.Wait()
+	return
+}
+
+// runUser 按组依次执行fn, 任一persist失败时对已经成功的persist执行rollback, 返回*PersistErrors
+func runUser(op string, groups [][]IPersistUser, fn, rollback func(persist IPersistUser) error) error {
+	var doneList []IPersistUser
+	for _, group := range groups {
+		done, errs := runUserGroup(group, fn)
+		doneList = append(doneList, done...)
+		if errs == nil {
+			continue
+		}
+		result := &PersistErrors{Op: op, Errs: errs}
+		_, result.Rollback = runUserGroup(doneList, rollback)
+		return result
+	}
+	return nil
+}
+
+// Load 按照用户uid导入所有IPersistUser, 任一失败时导出本次导入成功的persist, 调用前已经导入的persist不导出, 返回*PersistErrors
+// BeforeLoad钩子返回错误时不导入, AfterLoad钩子返回错误时导出本次导入的persist, 并对已经执行AfterLoad的钩子按相反顺序调用BeforeUnload和AfterUnload
+func (r *Registry) Load(uid int32) error {
+	hooks := r.getUserHooks()
+	for _, hook := range hooks {
+		if hook.BeforeLoad != nil {
+			if err := hook.BeforeLoad(uid); err != nil {
+				return fmt.Errorf("persist: before load hook: %w", err)
+			}
+		}
+	}
+	groups := r.userPersistGroups()
+	// 只回滚本次从未导入切换到导入的persist, 调用前已经导入的用户数据保留
+	var loadedMutex sync.Mutex
+	loadedPersist := make(map[IPersistUser]bool)
+	err := runUser("load", groups, func(persist IPersistUser) error {
+		state := persist.LoadState(uid)
+		if err := persist.Load(uid); err != nil {
+			return err
+		}
+		if state == EPersistStateDisk {
+			loadedMutex.Lock()
+			loadedPersist[persist] = true
+			loadedMutex.Unlock()
+		}
+		return nil
+	}, func(persist IPersistUser) error {
+		if !loadedPersist[persist] {
+			return nil
+		}
+		return persist.Unload(uid)
+	})
+	if err != nil {
+		return err
+	}
+	for i, hook := range hooks {
+		if hook.AfterLoad != nil {
+			if err = hook.AfterLoad(uid); err != nil {
+				loaded := hooks[:i]
+				for j := len(loaded) - 1; j >= 0; j-- {
+					if loaded[j].BeforeUnload != nil {
+						loaded[j].BeforeUnload(uid)
+					}
+				}
+				var persistList []IPersistUser
+				for _, group := range groups {
+					for _, persist := range group {
+						if loadedPersist[persist] {
+							persistList = append(persistList, persist)
+						}
+					}
+				}
+				result := &PersistErrors{Op: "load", Errs: map[string]error{"hook": fmt.Errorf("after load hook: %w", err)}}
+				_, result.Rollback = runUserGroup(persistList, func(persist IPersistUser) error {
+					return persist.Unload(uid)
+				})
+				for j := len(loaded) - 1; j >= 0; j-- {
+					if loaded[j].AfterUnload != nil {
+						loaded[j].AfterUnload(uid)
+					}
+				}
+				return result
+			}
+		}
+	}
+	return nil
+}
+
+// Unload 按照用户uid导出所有IPersistUser, 顺序和导入相反, 返回*PersistErrors
+// 导出前检查所有persist, 任一正在导入时不导出任何persist. 导出时仍然失败则重新导入已经接受导出的persist, 正在导出的等待导出完成后导入
+func (r *Registry) Unload(uid int32) error {
+	groups := r.userPersistGroups()
+	for i, j := 0, len(groups)-1; i < j; i, j = i+1, j-1 {
+		groups[i], groups[j] = groups[j], groups[i]
+	}
+	var errs map[string]error
+	for _, group := range groups {
+		for _, persist := range group {
+			if persist.LoadState(uid) == EPersistStateLoading {
+				if errs == nil {
+					errs = make(map[string]error)
+				}
+				errs[persist.PersistName()] = EPersistErrorLoading
+			}
+		}
+	}
+	if errs != nil {
+		return &PersistErrors{Op: "unload", Errs: errs}
+	}
+	hooks := r.getUserHooks()
+	for _, hook := range hooks {
+		if hook.BeforeUnload != nil {
+			hook.BeforeUnload(uid)
+		}
+	}
+	err := runUser("unload", groups, func(persist IPersistUser) error {
+		return persist.Unload(uid)
+	}, func(persist IPersistUser) error {
+		return reloadUser(persist, uid)
+	})
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if hook.AfterUnload != nil {
+			hook.AfterUnload(uid)
+		}
+	}
+	return nil
+}
+
+// reloadUser 导出失败回滚, 准备导出时Load直接取消导出, 正在导出时等待写回协程完成导出后重新导入
+func reloadUser(persist IPersistUser, uid int32) (err error) {
+	deadline := time.Now().Add(unloadRollbackTimeout)
+	for {
+		err = persist.Load(uid)
+		if !errors.Is(err, EPersistErrorUnloading) || time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// AddUserHook 添加按用户导入导出钩子, 按添加顺序调用
+func AddUserHook(hook UserHook) {
+	gDefaultRegistry.AddUserHook(hook)
+}
+
+// SetLoadOrder 设置按用户导入的顺序, order小的先导入, 相同order并发导入, 导出顺序相反
+func SetLoadOrder(name string, order int) {
+	gDefaultRegistry.SetLoadOrder(name, order)
+}
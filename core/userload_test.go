@@ -0,0 +1,275 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// opLog 按顺序记录操作
+type opLog struct {
+	mutex sync.Mutex
+	list  []string
+}
+
+func (l *opLog) add(op string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.list = append(l.list, op)
+}
+
+func (l *opLog) get() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string(nil), l.list...)
+}
+
+func equalOps(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mockUser 测试用用户persist, 按uid记录导入状态
+type mockUser struct {
+	mockPersist
+	log       *opLog
+	mutex     sync.Mutex
+	state     map[int32]int32
+	loadErr   error
+	unloadErr error
+	reloadErr []error // Load依次返回的错误, 用完后正常导入
+}
+
+func newMockUser(name string, log *opLog) *mockUser {
+	return &mockUser{mockPersist: mockPersist{name: name}, log: log, state: map[int32]int32{}}
+}
+
+func (p *mockUser) Load(uid int32) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.reloadErr) > 0 {
+		err := p.reloadErr[0]
+		p.reloadErr = p.reloadErr[1:]
+		return err
+	}
+	if p.loadErr != nil {
+		return p.loadErr
+	}
+	p.log.add("load " + p.name)
+	p.state[uid] = EPersistStateMemory
+	return nil
+}
+
+func (p *mockUser) Unload(uid int32) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.unloadErr != nil {
+		return p.unloadErr
+	}
+	p.log.add("unload " + p.name)
+	delete(p.state, uid)
+	return nil
+}
+
+func (p *mockUser) SetLoadState2Memory(uid int32) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.state[uid] = EPersistStateMemory
+}
+
+func (p *mockUser) LoadState(uid int32) int32 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.state[uid]
+}
+
+func (p *mockUser) SyncUserData(uid int32, sentryDebug bool) error { return nil }
+
+func (p *mockUser) PersistUserNilObjInterface() interface{} { return nil }
+
+func (p *mockUser) PersistUserNilObjInterfaceList() interface{} { return nil }
+
+// TestUserLoadOrder 测试按顺序导入, 相反顺序导出
+func TestUserLoadOrder(t *testing.T) {
+	r := NewRegistry()
+	log := &opLog{}
+	r.RegisterPersist("A", newMockUser("A", log))
+	r.RegisterPersist("B", newMockUser("B", log))
+	r.SetLoadOrder("A", 2)
+	r.SetLoadOrder("B", 1)
+
+	if err := r.Load(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Unload(1); err != nil {
+		t.Fatal(err)
+	}
+	if ops := log.get(); !equalOps(ops, []string{"load B", "load A", "unload A", "unload B"}) {
+		t.Errorf("unexpected ops %v", ops)
+	}
+}
+
+// TestUserLoadRollback 测试任一persist导入失败时导出已经导入的persist
+func TestUserLoadRollback(t *testing.T) {
+	r := NewRegistry()
+	log := &opLog{}
+	a, b, c := newMockUser("A", log), newMockUser("B", log), newMockUser("C", log)
+	b.loadErr = EPersistErrorAlreadyLoadAll
+	r.RegisterPersist("A", a)
+	r.RegisterPersist("B", b)
+	r.RegisterPersist("C", c)
+	r.SetLoadOrder("C", 1)
+
+	err := r.Load(1)
+	var persistErrs *PersistErrors
+	if !errors.As(err, &persistErrs) || persistErrs.Op != "load" || len(persistErrs.Errs) != 1 || persistErrs.Errs["B"] == nil || len(persistErrs.Rollback) != 0 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !errors.Is(err, EPersistErrorAlreadyLoadAll) {
+		t.Error("error not wrapped")
+	}
+	// 后面顺序的C没有导入
+	if a.LoadState(1) != EPersistStateDisk || b.LoadState(1) != EPersistStateDisk || c.LoadState(1) != EPersistStateDisk {
+		t.Error("user partially loaded")
+	}
+	if ops := log.get(); !equalOps(ops, []string{"load A", "unload A"}) {
+		t.Errorf("unexpected ops %v", ops)
+	}
+}
+
+// TestUserLoadHook 测试钩子, AfterLoad失败时导出所有persist并撤销已经执行的AfterLoad
+func TestUserLoadHook(t *testing.T) {
+	r := NewRegistry()
+	log := &opLog{}
+	a := newMockUser("A", log)
+	r.RegisterPersist("A", a)
+	hook := func(name string, afterLoadErr error) UserHook {
+		return UserHook{
+			BeforeLoad:   func(uid int32) error { log.add("beforeLoad " + name); return nil },
+			AfterLoad:    func(uid int32) error { log.add("afterLoad " + name); return afterLoadErr },
+			BeforeUnload: func(uid int32) { log.add("beforeUnload " + name) },
+			AfterUnload:  func(uid int32) { log.add("afterUnload " + name) },
+		}
+	}
+	r.AddUserHook(hook("1", nil))
+	r.AddUserHook(hook("2", nil))
+	r.AddUserHook(hook("3", errors.New("hook failed")))
+
+	if err := r.Load(1); err == nil {
+		t.Fatal("expected error")
+	}
+	if a.LoadState(1) != EPersistStateDisk {
+		t.Error("user not unloaded")
+	}
+	expect := []string{
+		"beforeLoad 1", "beforeLoad 2", "beforeLoad 3", "load A",
+		"afterLoad 1", "afterLoad 2", "afterLoad 3",
+		"beforeUnload 2", "beforeUnload 1", "unload A", "afterUnload 2", "afterUnload 1",
+	}
+	if ops := log.get(); !equalOps(ops, expect) {
+		t.Errorf("unexpected ops %v", ops)
+	}
+
+	// BeforeLoad失败时不导入
+	r2 := NewRegistry()
+	r2.RegisterPersist("A", newMockUser("A", log))
+	r2.AddUserHook(UserHook{BeforeLoad: func(uid int32) error { return errors.New("before load failed") }})
+	if err := r2.Load(1); err == nil {
+		t.Error("expected error")
+	}
+	if r2.LoadState(1)[0] != EPersistStateDisk {
+		t.Error("user loaded")
+	}
+}
+
+// TestUserUnloadLoading 测试任一persist正在导入时不导出任何persist
+func TestUserUnloadLoading(t *testing.T) {
+	r := NewRegistry()
+	log := &opLog{}
+	a, b := newMockUser("A", log), newMockUser("B", log)
+	r.RegisterPersist("A", a)
+	r.RegisterPersist("B", b)
+	if err := r.Load(1); err != nil {
+		t.Fatal(err)
+	}
+	b.state[1] = EPersistStateLoading
+
+	err := r.Unload(1)
+	var persistErrs *PersistErrors
+	if !errors.As(err, &persistErrs) || persistErrs.Op != "unload" || !errors.Is(err, EPersistErrorLoading) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if a.LoadState(1) != EPersistStateMemory {
+		t.Error("persist unloaded")
+	}
+}
+
+// TestUserUnloadRollback 测试导出失败时重新导入已经导出的persist, 正在导出时等待后导入
+func TestUserUnloadRollback(t *testing.T) {
+	r := NewRegistry()
+	log := &opLog{}
+	a, b := newMockUser("A", log), newMockUser("B", log)
+	r.RegisterPersist("A", a)
+	r.RegisterPersist("B", b)
+	r.SetLoadOrder("A", 1)
+	if err := r.Load(1); err != nil {
+		t.Fatal(err)
+	}
+	a.reloadErr = []error{EPersistErrorUnloading, EPersistErrorUnloading}
+	b.unloadErr = EPersistErrorAlreadyUnload
+
+	err := r.Unload(1)
+	var persistErrs *PersistErrors
+	if !errors.As(err, &persistErrs) || persistErrs.Errs["B"] == nil || len(persistErrs.Rollback) != 0 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if a.LoadState(1) != EPersistStateMemory || b.LoadState(1) != EPersistStateMemory {
+		t.Error("user not reloaded")
+	}
+	if ops := log.get(); !equalOps(ops, []string{"load B", "load A", "unload A", "load A"}) {
+		t.Errorf("unexpected ops %v", ops)
+	}
+}
+
+// TestUserLoadRollbackLoaded 测试导入失败时不导出调用前已经导入的persist
+func TestUserLoadRollbackLoaded(t *testing.T) {
+	r := NewRegistry()
+	log := &opLog{}
+	a, b, c := newMockUser("A", log), newMockUser("B", log), newMockUser("C", log)
+	r.RegisterPersist("A", a)
+	r.RegisterPersist("B", b)
+	r.RegisterPersist("C", c)
+	r.SetLoadOrder("C", 1)
+	a.SetLoadState2Memory(1)
+	c.loadErr = EPersistErrorAlreadyLoadAll
+
+	if err := r.Load(1); err == nil {
+		t.Fatal("expected error")
+	}
+	if a.LoadState(1) != EPersistStateMemory {
+		t.Error("online user unloaded")
+	}
+	if b.LoadState(1) != EPersistStateDisk {
+		t.Error("user not rolled back")
+	}
+	if ops := log.get(); !equalOps(ops, []string{"load A", "load B", "unload B"}) && !equalOps(ops, []string{"load B", "load A", "unload B"}) {
+		t.Errorf("unexpected ops %v", ops)
+	}
+
+	// AfterLoad钩子失败时同样只导出本次导入的persist
+	c.loadErr = nil
+	r.AddUserHook(UserHook{AfterLoad: func(uid int32) error { return errors.New("hook failed") }})
+	if err := r.Load(1); err == nil {
+		t.Fatal("expected error")
+	}
+	if a.LoadState(1) != EPersistStateMemory || b.LoadState(1) != EPersistStateDisk || c.LoadState(1) != EPersistStateDisk {
+		t.Error("unexpected states after hook rollback")
+	}
+}
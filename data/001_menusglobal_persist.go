@@ -4,15 +4,17 @@
 package data
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"log"
 	"math"
 	"runtime/debug"
 
-	"github.com/getsentry/sentry-go"
 	jsoniter "github.com/json-iterator/go"
+	"xorm.io/builder"
 	"xorm.io/xorm"
 
 	"reflect"
@@ -93,6 +95,7 @@ const (
 	EMenusGlobalOpUpdate = 2 // 修改
 	EMenusGlobalOpDelete = 3 // 删除
 	EMenusGlobalOpUnload = 4 // 导出
+	EMenusGlobalOpBatch  = 5 // 批量, Batch中的操作一起进入写回队列
 
 	EMenusGlobalCollectStateNormal    = 0 // 正常
 	EMenusGlobalCollectStateSaveSync  = 1 // 开始退出, 清理同步队列
@@ -205,6 +208,15 @@ var MenusGlobalStructFiledMap = [EMenusGlobalFiledIndexLength]string{
 
 var MenusGlobalDBFiledMap [EMenusGlobalFiledIndexLength]string
 
+// MenusGlobalHashFiledMap 生成的hash索引, 前缀索引和树索引包含的字段, 只能通过SetIndexKey*和MoveSubtree修改
+var MenusGlobalHashFiledMap = [EMenusGlobalFiledIndexLength]bool{
+	EMenusGlobalFieldIndexAuthId:   true,
+	EMenusGlobalFieldIndexType:     true,
+	EMenusGlobalFieldIndexPath:     true,
+	EMenusGlobalFieldIndexParentId: true,
+	EMenusGlobalFieldIndexTreePath: true,
+}
+
 // EMenusGlobalWordSize the EMenusGlobalWordSize of a bit set
 const EMenusGlobalWordSize = MenusGlobalFieldIndex(64)
 
@@ -288,6 +300,8 @@ type MenusGlobalSync struct {
 	Data   *model.MenusGlobal
 	Op     int8
 	BitSet MenusGlobalBitSet
+	Table  string             // 分表时写入的表名, 不分表为空
+	Batch  []*MenusGlobalSync // EMenusGlobalOpBatch的操作, 只在syncChan中使用
 }
 
 // MenusGlobalManager 结构定义
@@ -306,6 +320,45 @@ type MenusGlobalManager struct {
 	FailQueue         []*MenusGlobalSync
 	lastWriteBackTime time.Duration
 
+	// 写回队列锁, 写回协程修改syncQueue, cacheQueue, FailQueue, InsertQueue以及其他协程读取时加锁, 写回协程自己读取不加锁
+	queueMutex sync.Mutex
+	// FailQueue长度, 写回协程修改FailQueue后更新, 其他协程读取
+	failQueueLength int32
+
+	// 健康状态
+	healthMutex    sync.Mutex
+	lastSaveTime   time.Time
+	lastError      error
+	lastErrorTime  time.Time
+	failQueueSince time.Time
+
+	// 分表
+	segmentMutex  sync.Mutex
+	segmentation  persistCore.SegmentationConfig
+	segmentSeq    int
+	tableName     atomic.Value // string 当前写入表名, 不分表为空
+	prevTableName atomic.Value // string 上一个分表, 导入时一起查询
+	tableMap      sync.Map     // map[MenusGlobalAuthId]string 分表时对象所在的表
+
+	// 分片, 不能和分表同时使用
+	shardRouter ShardRouter[MenusGlobalAuthId]
+
+	// 对象锁, 按主键分段. SetField和SetIndexKey*修改对象, 管理接口和检测协程拷贝对象时加锁
+	objectMutex [64]sync.RWMutex
+
+	// 从库
+	replica       *xorm.Engine
+	replicaPolicy persistCore.ReplicaPolicy
+	lastWriteMap  sync.Map  // map[MenusGlobalAuthId]time.Time 最近写回时间, 仅EReplicaModeStaleness
+	replayTime    int64     // 最近重放bomb时间(UnixNano), 之后MaxStaleness内全导入读主库
+	pruneTime     time.Time // 最近清理lastWriteMap时间, 仅Collect协程使用
+
+	loadAllPageSize int // 全导入每页行数, 0使用默认值
+
+	// 部分全导入条件, LoadAllWhere设置, UnloadAll清除
+	loadAllCond  builder.Cond
+	loadAllMatch func(cls *model.MenusGlobal) bool
+
 	InsertQueue []*MenusGlobalSync
 
 	syncBegin chan bool
@@ -313,15 +366,41 @@ type MenusGlobalManager struct {
 	exitBegin chan bool
 	exitEnd   chan bool
 
+	// 退出超时导出队列, Collect协程导出后关闭请求中的chan并停止收集
+	dumpChan chan chan struct{}
+	// 已经导出队列, 写回协程不再写数据库和bomb文件
+	dumped int32
+	// 写回协程是否在运行
+	saving int32
+	// Collect协程是否在运行, 导出队列后等待写回协程结束才退出
+	collecting int32
+	// bomb文件写入删除互斥
+	bombMutex sync.Mutex
+
 	engine *xorm.Engine
 
 	hashAuthId MenusGlobalHashAuthId
 
+	runtimeIndexes RuntimeIndexes[model.MenusGlobal] // 运行时添加的索引
+
+	aggregates Aggregates[model.MenusGlobal] // 注册的分组聚合
+
 	hashAuthIdType MenusGlobalHashAuthIdType
 
+	prefixPath PrefixIndex[model.MenusGlobal] // Path前缀索引
+
+	treeParentId TreeIndex[model.MenusGlobal, int64] // ParentId树索引, TreePath为物化路径
+	treeMutex    sync.Mutex                          // MoveSubtree互斥
+
 	// hashAuthIdMark MenusGlobalHashAuthIdMark
 
 	bitSetAll MenusGlobalBitSet
+
+	// 未标记修改检测(调试用), 保存每个对象最后一次标记时的影子拷贝
+	markDetector      int32
+	markDetectorMutex sync.Mutex // 开启关闭互斥, markDetectorStop在markDetector置1之前创建
+	markDetectorStop  chan struct{}
+	shadowMap         sync.Map // map[MenusGlobalKeyTypeHashAuthId]*model.MenusGlobal
 }
 
 var gMenusGlobalNil = &model.MenusGlobal{}
@@ -336,6 +415,7 @@ func NewMenusGlobalManager(engine *xorm.Engine) (m *MenusGlobalManager) {
 	m.syncBegin = make(chan bool)
 	m.exitBegin = make(chan bool)
 	m.exitEnd = make(chan bool)
+	m.dumpChan = make(chan chan struct{})
 	tmpCacheQueue := make([]*MenusGlobalSync, 0)
 	m.cacheQueue = &tmpCacheQueue
 	m.lastWriteBackTime = 1 * time.Millisecond
@@ -385,11 +465,17 @@ func (m *MenusGlobalManager) Run() error {
 		if err := m.LoadFile(); err != nil {
 			return err
 		}
+		atomic.StoreInt32(&m.collecting, 1)
 		go m.Collect()
+	} else if atomic.LoadInt32(&m.collecting) == 1 {
+		// 退出超时导出队列后, Collect协程等待写回协程结束, 结束前不能重新运行
+		return persistCore.EPersistErrorSaving
 	} else if atomic.CompareAndSwapInt32(&m.managerState, EMenusGlobalManagerStatePanic, EMenusGlobalManagerStateNormal) {
+		atomic.StoreInt32(&m.dumped, 0)
 		if err := m.LoadFile(); err != nil {
 			return err
 		}
+		atomic.StoreInt32(&m.collecting, 1)
 		go m.Collect()
 	} else {
 	}
@@ -1233,10 +1319,17 @@ func (m *MenusGlobalManager) BytesToPersistSync(data []byte) (persistSync *Menus
 	persistSync = &MenusGlobalSync{}
 	lenPersistData := len(data) - bitSetSize - 1
 
-	persistSync.Data = m.BytesToPersist(data[:lenPersistData])
+	op := data[lenPersistData]
+	if op&persistCore.ESyncFlagTable != 0 {
+		lenTable := int(binary.LittleEndian.Uint16(data[lenPersistData-2:]))
+		persistSync.Table = string(data[lenPersistData-2-lenTable : lenPersistData-2])
+		persistSync.Data = m.BytesToPersist(data[:lenPersistData-2-lenTable])
+	} else {
+		persistSync.Data = m.BytesToPersist(data[:lenPersistData])
+	}
 
 	i += lenPersistData
-	persistSync.Op = int8(data[i])
+	persistSync.Op = int8(op &^ persistCore.ESyncFlagTable)
 	i += 1
 	for j := 0; j < bitSetSize/8; j++ {
 		persistSync.BitSet.set[j] = binary.LittleEndian.Uint64(data[i:])
@@ -1267,6 +1360,11 @@ func (m *MenusGlobalManager) PersistSyncToBytes(persistSync *MenusGlobalSync) (d
 
 	pData := m.PersistToBytes(persistSync.Data, persistSync.BitSet)
 	size += len(pData) + 1 + bitSetSize
+	op := uint8(persistSync.Op)
+	if persistSync.Table != "" {
+		size += len(persistSync.Table) + 2
+		op |= persistCore.ESyncFlagTable
+	}
 
 	data = make([]byte, size)
 
@@ -1274,7 +1372,13 @@ func (m *MenusGlobalManager) PersistSyncToBytes(persistSync *MenusGlobalSync) (d
 
 	copy(data[i:], pData)
 	i += len(pData)
-	data[i] = uint8(persistSync.Op)
+	if persistSync.Table != "" {
+		copy(data[i:], persistSync.Table)
+		i += len(persistSync.Table)
+		binary.LittleEndian.PutUint16(data[i:], uint16(len(persistSync.Table)))
+		i += 2
+	}
+	data[i] = op
 	i += 1
 	for _, setItem := range persistSync.BitSet.set {
 		binary.LittleEndian.PutUint64(data[i:], setItem)
@@ -1397,8 +1501,8 @@ func (m *MenusGlobalManager) releaseDeepCopyObject(cls *model.MenusGlobal) {
 
 // CheckOverload 检查负载
 func (m *MenusGlobalManager) CheckOverload() {
-	// queueLength 不是精确值,  cacheQueue, FailQueue 一写多读
-	queueLength := len(*m.cacheQueue) + len(m.FailQueue)
+	// 写回协程调用
+	queueLength := len(*m.cacheQueue) + int(atomic.LoadInt32(&m.failQueueLength))
 	if queueLength > 10000 {
 		if v, ok := ((interface{})(gMenusGlobalNil)).(MenusGlobalOverload); ok {
 			go utils.SafeGoRecoverWarpFunc(func() { v.Overload(queueLength, m.lastWriteBackTime) })
@@ -1408,12 +1512,206 @@ func (m *MenusGlobalManager) CheckOverload() {
 	}
 }
 
+// QueueStat 写回队列长度
+func (m *MenusGlobalManager) QueueStat() persistCore.QueueStat {
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+	return persistCore.QueueStat{
+		Chan:   len(m.syncChan),
+		Cache:  len(*m.cacheQueue),
+		Sync:   len(*m.syncQueue),
+		Insert: len(m.InsertQueue),
+		Fail:   len(m.FailQueue),
+	}
+}
+
+// FailQueueBomb 失败队列按照bomb文件格式序列化
+func (m *MenusGlobalManager) FailQueueBomb() ([]byte, error) {
+	m.queueMutex.Lock()
+	failQueue := append([]*MenusGlobalSync(nil), m.FailQueue...)
+	m.queueMutex.Unlock()
+	data, err := m.MarshalFailQueue(failQueue)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("MenusGlobal "), data...), nil
+}
+
+// PersistInterfaceByPkJson 通过主键json查找对象, 返回深拷贝
+func (m *MenusGlobalManager) PersistInterfaceByPkJson(data []byte) (interface{}, error) {
+	pk := MenusGlobalAuthId{}
+	if err := json.Unmarshal(data, &pk); err != nil {
+		return nil, err
+	}
+	cls := m.GetMenusGlobalByAuthId(pk.AuthId)
+	if cls == nil {
+		return nil, persistCore.EPersistErrorNotInMemory
+	}
+	m.objectLock(pk.AuthId).RLock()
+	defer m.objectLock(pk.AuthId).RUnlock()
+	return m.acquireDeepCopyObject(cls), nil
+}
+
+// EnableMarkDetector 开启未标记修改检测(调试用), 每隔interval比较对象和最后一次标记时的影子拷贝, 不一致的字段上报, autoMark为true时自动标记写回
+func (m *MenusGlobalManager) EnableMarkDetector(interval time.Duration, autoMark bool) {
+	m.markDetectorMutex.Lock()
+	defer m.markDetectorMutex.Unlock()
+	if atomic.LoadInt32(&m.markDetector) != 0 {
+		return
+	}
+	stop := make(chan struct{})
+	m.markDetectorStop = stop
+	atomic.StoreInt32(&m.markDetector, 1)
+	for _, cls := range m.GetAll() {
+		m.shadowStore(cls)
+	}
+	go utils.SafeGoRecoverWarpFunc(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				m.DetectUnmarked(autoMark)
+			}
+		}
+	})()
+}
+
+// DisableMarkDetector 关闭未标记修改检测
+func (m *MenusGlobalManager) DisableMarkDetector() {
+	m.markDetectorMutex.Lock()
+	defer m.markDetectorMutex.Unlock()
+	if !atomic.CompareAndSwapInt32(&m.markDetector, 1, 0) {
+		return
+	}
+	close(m.markDetectorStop)
+	m.shadowMap.Range(func(k, v interface{}) bool {
+		m.shadowMap.Delete(k)
+		return true
+	})
+}
+
+// DetectUnmarked 比较所有对象和影子拷贝, 上报没有标记的修改. 读取对象时不加锁, 只用于调试
+func (m *MenusGlobalManager) DetectUnmarked(autoMark bool) (reportList []*persistCore.DiffReport) {
+	if atomic.LoadInt32(&m.markDetector) == 0 {
+		return
+	}
+	for _, cls := range m.GetAll() {
+		key := MenusGlobalKeyTypeHashAuthId{cls.AuthId}
+		// 和SetField等修改对象并发, 加锁拷贝
+		m.objectLock(cls.AuthId).RLock()
+		memCls := m.acquireDeepCopyObject(cls)
+		m.objectLock(cls.AuthId).RUnlock()
+		value, ok := m.shadowMap.Load(key)
+		if !ok {
+			m.shadowMap.Store(key, memCls)
+			continue
+		}
+		// DB为最后一次标记时的值
+		diff, bitSet := m.DiffMenusGlobal(memCls, value.(*model.MenusGlobal))
+		if len(diff) == 0 {
+			continue
+		}
+		report := &persistCore.DiffReport{Persist: "MenusGlobal", Pk: MenusGlobalAuthId{AuthId: memCls.AuthId}, Fields: diff}
+		reportList = append(reportList, report)
+		log.Println("MarkDetector error. missing mark. [sql error MenusGlobal]", report.FieldNames(), m.PersistSyncToString(&MenusGlobalSync{
+			Data:   memCls,
+			Op:     0,
+			BitSet: bitSet,
+		}))
+		persistCore.Report(&persistCore.ReportEvent{
+			Kind:    persistCore.EReportKindUnmarked,
+			Persist: "MenusGlobal",
+			Pk:      report.Pk,
+			Fields:  diff,
+		})
+		if autoMark {
+			if err := m.MarkUpdateByBitSet(cls, bitSet); err == nil {
+				continue
+			}
+		}
+		// 同一处修改只上报一次
+		m.shadowMap.Store(key, memCls)
+	}
+	return
+}
+
+// shadowStore 保存对象的影子拷贝
+func (m *MenusGlobalManager) shadowStore(cls *model.MenusGlobal) {
+	if atomic.LoadInt32(&m.markDetector) == 0 {
+		return
+	}
+	m.shadowMap.Store(MenusGlobalKeyTypeHashAuthId{cls.AuthId}, m.acquireDeepCopyObject(cls))
+}
+
+// shadowMark 标记时按照bitSet更新影子拷贝
+func (m *MenusGlobalManager) shadowMark(cls *model.MenusGlobal, bitSet MenusGlobalBitSet) {
+	if atomic.LoadInt32(&m.markDetector) == 0 {
+		return
+	}
+	key := MenusGlobalKeyTypeHashAuthId{cls.AuthId}
+	value, ok := m.shadowMap.Load(key)
+	if !ok || bitSet.IsSetAll() {
+		m.shadowMap.Store(key, m.acquireDeepCopyObject(cls))
+		return
+	}
+	shadow := m.acquireDeepCopyObject(value.(*model.MenusGlobal))
+	m.PersistToPersistByBitSet(shadow, cls, bitSet)
+	m.shadowMap.Store(key, shadow)
+}
+
+// shadowDelete 删除影子拷贝
+func (m *MenusGlobalManager) shadowDelete(cls *model.MenusGlobal) {
+	m.shadowMap.Delete(MenusGlobalKeyTypeHashAuthId{cls.AuthId})
+}
+
+// updateHealth 每次写回后更新健康状态
+func (m *MenusGlobalManager) updateHealth(err error) {
+	now := time.Now()
+	m.healthMutex.Lock()
+	defer m.healthMutex.Unlock()
+	if err != nil {
+		m.lastError = err
+		m.lastErrorTime = now
+	} else {
+		m.lastSaveTime = now
+	}
+	if atomic.LoadInt32(&m.failQueueLength) == 0 {
+		m.failQueueSince = time.Time{}
+	} else if m.failQueueSince.IsZero() {
+		m.failQueueSince = now
+	}
+}
+
+// Health 健康状态
+func (m *MenusGlobalManager) Health() persistCore.Health {
+	m.healthMutex.Lock()
+	defer m.healthMutex.Unlock()
+	health := persistCore.Health{
+		Name:           "MenusGlobal",
+		State:          atomic.LoadInt32(&m.managerState),
+		Dead:           m.Dead(),
+		LastWriteBack:  m.lastSaveTime,
+		LastErrorTime:  m.lastErrorTime,
+		FailQueue:      int(atomic.LoadInt32(&m.failQueueLength)),
+		FailQueueSince: m.failQueueSince,
+		BombExist:      utils.Exists("_./_Users_xt_go_pumppill_data/MenusGlobal.bomb"),
+	}
+	if m.lastError != nil {
+		health.LastError = m.lastError.Error()
+	}
+	return health
+}
+
 // addMenusGlobal添加一个对象
 func (m *MenusGlobalManager) addMenusGlobal(cls *model.MenusGlobal) (*model.MenusGlobal, bool) {
 
 	actual, loaded := m.hashAuthId.LoadOrStore(MenusGlobalKeyTypeHashAuthId{cls.AuthId}, cls)
 	if !loaded {
 		actual = cls
+		m.shadowStore(cls)
 
 		if v, ok := m.hashAuthIdType.LoadOrStore(MenusGlobalKeyTypeHashAuthIdType{cls.AuthId, cls.Type}, &MenusGlobalSet{}); !ok {
 			v.Store(cls, true)
@@ -1421,6 +1719,13 @@ func (m *MenusGlobalManager) addMenusGlobal(cls *model.MenusGlobal) (*model.Menu
 			v.Store(cls, true)
 		}
 
+		m.prefixPath.Insert(cls.Path, cls)
+
+		m.treeParentId.Insert(cls.AuthId, cls.ParentId, cls)
+
+		m.runtimeIndexes.Insert(cls)
+
+		m.aggregates.Insert(cls)
 	}
 	return actual, !loaded
 }
@@ -1445,6 +1750,18 @@ func (m *MenusGlobalManager) removeMenusGlobal(cls *model.MenusGlobal) {
 
 	m.hashAuthId.Delete(MenusGlobalKeyTypeHashAuthId{cls.AuthId})
 
+	m.prefixPath.Remove(cls)
+
+	m.treeParentId.Remove(cls.AuthId)
+
+	m.runtimeIndexes.Remove(cls)
+
+	m.aggregates.Remove(cls)
+
+	m.shadowDelete(cls)
+
+	m.tableMap.Delete(MenusGlobalAuthId{AuthId: cls.AuthId})
+
 	return
 }
 
@@ -1494,6 +1811,7 @@ func (m *MenusGlobalManager) InitDS(cls *model.MenusGlobal) {
 }
 
 // NewMenusGlobal 添加对象并异步写回数据库, (1 数据没有导入或已经导出, 2 数据已存在, 3 对象为空) 会返回失败
+// 不满足LoadAllWhere条件的对象只写回数据库, 返回EPersistErrorOutOfLoadSet
 func (m *MenusGlobalManager) NewMenusGlobal(cls *model.MenusGlobal) (*model.MenusGlobal, error) {
 
 	if cls == nil {
@@ -1504,6 +1822,25 @@ func (m *MenusGlobalManager) NewMenusGlobal(cls *model.MenusGlobal) (*model.Menu
 		return nil, persistCore.EPersistErrorNotInMemory
 	}
 
+	if !m.inLoadSet(cls) {
+		if actual := m.GetMenusGlobalByAuthId(cls.AuthId); actual != nil {
+			return actual, persistCore.EPersistErrorAlreadyExist
+		}
+		// 不满足全导入条件, 只写回数据库
+		m.InitDS(cls)
+		bitSet := MenusGlobalBitSet{}
+		bitSet.SetAll()
+		newCls := m.acquireDeepCopyObject(cls)
+
+		persistSync := &MenusGlobalSync{Data: newCls, Op: EMenusGlobalOpInsert, BitSet: bitSet, Table: m.TableName()}
+
+		log.Println("[sql trace MenusGlobal]", m.PersistSyncToString(persistSync))
+
+		m.syncChan <- persistSync
+
+		return nil, persistCore.EPersistErrorOutOfLoadSet
+	}
+
 	actual, success := m.addMenusGlobal(cls)
 
 	if success {
@@ -1512,7 +1849,7 @@ func (m *MenusGlobalManager) NewMenusGlobal(cls *model.MenusGlobal) (*model.Menu
 		bitSet.SetAll()
 		newCls := m.acquireDeepCopyObject(cls)
 
-		persistSync := &MenusGlobalSync{Data: newCls, Op: EMenusGlobalOpInsert, BitSet: bitSet}
+		persistSync := &MenusGlobalSync{Data: newCls, Op: EMenusGlobalOpInsert, BitSet: bitSet, Table: m.insertTable(MenusGlobalAuthId{AuthId: cls.AuthId})}
 
 		log.Println("[sql trace MenusGlobal]", m.PersistSyncToString(persistSync))
 
@@ -1540,6 +1877,7 @@ func (m *MenusGlobalManager) DeleteMenusGlobal(cls *model.MenusGlobal) error {
 		return persistCore.EPersistErrorOutOfDate
 	}
 
+	table := m.segmentTable(MenusGlobalAuthId{AuthId: cls.AuthId})
 	m.removeMenusGlobal(cls)
 
 	// 主键不能修改
@@ -1547,7 +1885,7 @@ func (m *MenusGlobalManager) DeleteMenusGlobal(cls *model.MenusGlobal) error {
 	bitSet.SetAll()
 	newCls := m.acquireDeepCopyObject(cls)
 
-	persistSync := &MenusGlobalSync{Data: newCls, Op: EMenusGlobalOpDelete, BitSet: bitSet}
+	persistSync := &MenusGlobalSync{Data: newCls, Op: EMenusGlobalOpDelete, BitSet: bitSet, Table: table}
 
 	log.Println("[sql trace MenusGlobal]", m.PersistSyncToString(persistSync))
 
@@ -1568,6 +1906,7 @@ func (m *MenusGlobalManager) DeleteAll() {
 		if cls == nil {
 			continue
 		}
+		table := m.segmentTable(MenusGlobalAuthId{AuthId: cls.AuthId})
 		m.removeMenusGlobal(cls)
 
 		// 主键不能修改
@@ -1575,7 +1914,7 @@ func (m *MenusGlobalManager) DeleteAll() {
 		bitSet.SetAll()
 		newCls := m.acquireDeepCopyObject(cls)
 
-		persistSync := &MenusGlobalSync{Data: newCls, Op: EMenusGlobalOpDelete, BitSet: bitSet}
+		persistSync := &MenusGlobalSync{Data: newCls, Op: EMenusGlobalOpDelete, BitSet: bitSet, Table: table}
 
 		log.Println("[sql trace MenusGlobal]", m.PersistSyncToString(persistSync))
 
@@ -1600,6 +1939,8 @@ func (m *MenusGlobalManager) SetIndexKeyAuthIdType(cls *model.MenusGlobal, Type
 		return persistCore.EPersistErrorNotInMemory
 	}
 
+	m.runtimeIndexes.Remove(cls)
+
 	if v, ok := m.hashAuthIdType.Load(MenusGlobalKeyTypeHashAuthIdType{cls.AuthId, cls.Type}); ok {
 		v.Delete(cls)
 	}
@@ -1614,7 +1955,9 @@ func (m *MenusGlobalManager) SetIndexKeyAuthIdType(cls *model.MenusGlobal, Type
 		}
 	}
 
+	m.objectLock(cls.AuthId).Lock()
 	cls.Type = Type
+	m.objectLock(cls.AuthId).Unlock()
 
 	if v, ok := m.hashAuthIdType.LoadOrStore(MenusGlobalKeyTypeHashAuthIdType{cls.AuthId, cls.Type}, &MenusGlobalSet{}); !ok {
 		v.Store(cls, true)
@@ -1622,6 +1965,10 @@ func (m *MenusGlobalManager) SetIndexKeyAuthIdType(cls *model.MenusGlobal, Type
 		v.Store(cls, true)
 	}
 
+	m.runtimeIndexes.Insert(cls)
+
+	m.aggregates.Insert(cls)
+
 	// return m.MarkUpdate(cls)
 	bitSet := MenusGlobalBitSet{}
 
@@ -1642,6 +1989,8 @@ func (m *MenusGlobalManager) SetIndexKeyType(cls *model.MenusGlobal, Type string
 		return persistCore.EPersistErrorNotInMemory
 	}
 
+	m.runtimeIndexes.Remove(cls)
+
 	if v, ok := m.hashAuthIdType.Load(MenusGlobalKeyTypeHashAuthIdType{cls.AuthId, cls.Type}); ok {
 		v.Delete(cls)
 	}
@@ -1656,7 +2005,9 @@ func (m *MenusGlobalManager) SetIndexKeyType(cls *model.MenusGlobal, Type string
 		}
 	}
 
+	m.objectLock(cls.AuthId).Lock()
 	cls.Type = Type
+	m.objectLock(cls.AuthId).Unlock()
 
 	if v, ok := m.hashAuthIdType.LoadOrStore(MenusGlobalKeyTypeHashAuthIdType{cls.AuthId, cls.Type}, &MenusGlobalSet{}); !ok {
 		v.Store(cls, true)
@@ -1664,13 +2015,178 @@ func (m *MenusGlobalManager) SetIndexKeyType(cls *model.MenusGlobal, Type string
 		v.Store(cls, true)
 	}
 
+	m.runtimeIndexes.Insert(cls)
+
+	m.aggregates.Insert(cls)
+
 	// return m.MarkUpdate(cls)
 	bitSet := MenusGlobalBitSet{}
 	bitSet.Set(EMenusGlobalFieldIndexType)
 	return m.MarkUpdateByBitSet(cls, bitSet)
 }
 
+// SetIndexKeyPath 修改包含索引字段, 标记脏对象并异步写回数据库, 需要保证一致性, 生成时开启 EOptimizeFlagIndexMutex
+// (1 数据没有导入或已经导出, 2 数据已经重新导入, 3 对象为空) 会返回失败
+func (m *MenusGlobalManager) SetIndexKeyPath(cls *model.MenusGlobal, Path string) error {
+
+	if cls == nil {
+		return persistCore.EPersistErrorNil
+	}
+
+	if m.LoadAllState() != EMenusGlobalLoadStateMemory {
+		return persistCore.EPersistErrorNotInMemory
+	}
+
+	p := m.GetMenusGlobalByAuthId(cls.AuthId)
+	if p == nil || p != cls {
+		return persistCore.EPersistErrorOutOfDate
+	}
+
+	m.runtimeIndexes.Remove(cls)
+
+	m.objectLock(cls.AuthId).Lock()
+	cls.Path = Path
+	m.objectLock(cls.AuthId).Unlock()
+
+	m.prefixPath.Insert(cls.Path, cls)
+
+	m.runtimeIndexes.Insert(cls)
+
+	m.aggregates.Insert(cls)
+
+	// return m.MarkUpdate(cls)
+	bitSet := MenusGlobalBitSet{}
+	bitSet.Set(EMenusGlobalFieldIndexPath)
+	return m.MarkUpdateByBitSet(cls, bitSet)
+}
+
+// AddIndex 运行时添加字段索引, 用现有对象建立索引, 之后由增删, SetField和SetIndexKey*维护. 索引名为字段名
+func (m *MenusGlobalManager) AddIndex(field string, unique bool) error {
+	return m.runtimeIndexes.AddField(field, unique, m.rangeAll)
+}
+
+// AddIndexFunc 运行时添加计算key的索引, key函数只能使用通过SetField和SetIndexKey*修改的字段, 可以使用IndexFunc转换
+func (m *MenusGlobalManager) AddIndexFunc(name string, unique bool, key func(cls *model.MenusGlobal) any) error {
+	return m.runtimeIndexes.Add(name, unique, key, m.rangeAll)
+}
+
+// GetMenusGlobalByIndex 通过运行时索引查找对象, 非唯一索引返回任意一个
+func (m *MenusGlobalManager) GetMenusGlobalByIndex(name string, key any) *model.MenusGlobal {
+	if data, ok := m.runtimeIndexes.Get(name, key); ok {
+		return data
+	}
+	return nil
+}
+
+// GetMenusGlobalsByIndex 通过运行时索引查找所有对象
+func (m *MenusGlobalManager) GetMenusGlobalsByIndex(name string, key any) []*model.MenusGlobal {
+	return m.runtimeIndexes.GetAll(name, key)
+}
+
+// AddAggregate 添加按字段分组的聚合并用现有对象初始化, 之后由增删, SetField和SetIndexKey*维护. 多个字段为组合分组, valueField为数值字段, 为空只计数
+func (m *MenusGlobalManager) AddAggregate(name string, groupFields []string, valueField string) error {
+	return m.aggregates.AddField(name, groupFields, valueField, m.rangeAll)
+}
+
+// AddAggregateFunc 添加计算分组的聚合, 分组和值函数只能使用通过SetField和SetIndexKey*修改的字段
+func (m *MenusGlobalManager) AddAggregateFunc(name string, group func(cls *model.MenusGlobal) any, value func(cls *model.MenusGlobal) float64) error {
+	return m.aggregates.Add(name, group, value, m.rangeAll)
+}
+
+// Aggregate 分组的聚合结果, 字段分组时key依次为各个分组字段的值
+func (m *MenusGlobalManager) Aggregate(name string, key ...any) (AggregateValue, bool) {
+	return m.aggregates.Get(name, key...)
+}
+
+// RangeAggregate 遍历聚合的所有分组
+func (m *MenusGlobalManager) RangeAggregate(name string, f func(key any, value AggregateValue) bool) {
+	m.aggregates.Range(name, f)
+}
+
+// SetField 修改字段并维护运行时索引和聚合, 标记脏对象并异步写回数据库. 主键和hash索引字段使用SetIndexKey*修改
+// (1 数据没有导入或已经导出, 2 数据已经重新导入, 3 对象为空, 4 字段不存在或类型不匹配) 会返回失败
+func (m *MenusGlobalManager) SetField(cls *model.MenusGlobal, field string, value any) error {
+	if cls == nil {
+		return persistCore.EPersistErrorNil
+	}
+	fieldIndex := EMenusGlobalFiledIndexLength
+	for idx, name := range MenusGlobalStructFiledMap {
+		if name == field {
+			fieldIndex = MenusGlobalFieldIndex(idx)
+			break
+		}
+	}
+	if fieldIndex == EMenusGlobalFiledIndexLength {
+		return errors.New("MenusGlobal: unknown field " + field)
+	}
+	if MenusGlobalHashFiledMap[fieldIndex] {
+		return errors.New("MenusGlobal: hash index field " + field + " must be set by SetIndexKey")
+	}
+
+	p := m.GetMenusGlobalByAuthId(cls.AuthId)
+	if p == nil || p != cls {
+		return persistCore.EPersistErrorOutOfDate
+	}
+
+	err := m.aggregates.Update(cls, func() error {
+		return m.runtimeIndexes.Update(cls, func() error {
+			m.objectLock(cls.AuthId).Lock()
+			defer m.objectLock(cls.AuthId).Unlock()
+			return setStructField(cls, field, value)
+		})
+	})
+	if err != nil {
+		return errors.New("MenusGlobal: " + err.Error())
+	}
+	return m.MarkUpdateByFieldIndex(cls, fieldIndex)
+}
+
+// Query 内存查询, 存在索引时使用索引, 默认返回深拷贝
+func (m *MenusGlobalManager) Query() *Query[model.MenusGlobal] {
+	return NewQuery[model.MenusGlobal](m)
+}
+
+// Page 按字段稳定排序分页, 返回总数和下一页游标
+func (m *MenusGlobalManager) Page(page PageQuery) (*PageResult[model.MenusGlobal], error) {
+	return m.Query().Page(page)
+}
+
+// QueryPrimaryField 主键字段名
+func (m *MenusGlobalManager) QueryPrimaryField() string {
+	return "AuthId"
+}
+
+// QueryRange 遍历所有对象
+func (m *MenusGlobalManager) QueryRange(f func(cls *model.MenusGlobal) bool) {
+	m.rangeAll(f)
+}
+
+// QueryLookup 字段等值索引查找, 使用单字段的hash索引和运行时字段索引
+func (m *MenusGlobalManager) QueryLookup(field string, value any) ([]*model.MenusGlobal, bool) {
+	switch field {
+	case "AuthId":
+		if cls := m.GetMenusGlobalByAuthId(value.(int64)); cls != nil {
+			return []*model.MenusGlobal{cls}, true
+		}
+		return nil, true
+	}
+	return m.runtimeIndexes.LookupField(field, value)
+}
+
+// QueryCopy 深拷贝对象
+func (m *MenusGlobalManager) QueryCopy(cls *model.MenusGlobal) *model.MenusGlobal {
+	return m.acquireDeepCopyObject(cls)
+}
+
+// rangeAll 遍历所有对象
+func (m *MenusGlobalManager) rangeAll(f func(cls *model.MenusGlobal) bool) {
+	m.hashAuthId.Range(func(k MenusGlobalKeyTypeHashAuthId, v *model.MenusGlobal) bool {
+		return f(v)
+	})
+}
+
 // MarkUpdate 标记脏对象并异步写回数据库, (1 数据没有导入或已经导出, 2 数据已经重新导入, 3 对象为空) 会返回失败
+// 修改后不满足LoadAllWhere条件时, 写回后从内存删除并返回EPersistErrorOutOfLoadSet
 func (m *MenusGlobalManager) MarkUpdate(cls *model.MenusGlobal) error {
 	if cls == nil {
 		return persistCore.EPersistErrorNil
@@ -1689,17 +2205,19 @@ func (m *MenusGlobalManager) MarkUpdate(cls *model.MenusGlobal) error {
 	bitSet := MenusGlobalBitSet{}
 	bitSet.SetAll()
 	newCls := m.acquireDeepCopyObject(cls)
+	m.shadowMark(cls, bitSet)
 
-	persistSync := &MenusGlobalSync{Data: newCls, Op: EMenusGlobalOpUpdate, BitSet: bitSet}
+	persistSync := &MenusGlobalSync{Data: newCls, Op: EMenusGlobalOpUpdate, BitSet: bitSet, Table: m.segmentTable(MenusGlobalAuthId{AuthId: cls.AuthId})}
 
 	log.Println("[sql trace MenusGlobal]", m.PersistSyncToString(persistSync))
 
 	m.syncChan <- persistSync
 
-	return nil
+	return m.leaveLoadSet(cls)
 }
 
 // MarkUpdateByBitSet 标记脏对象并异步写回数据库, (1 数据没有导入或已经导出, 2 数据已经重新导入, 3 对象为空) 会返回失败
+// 修改后不满足LoadAllWhere条件时, 写回后从内存删除并返回EPersistErrorOutOfLoadSet
 func (m *MenusGlobalManager) MarkUpdateByBitSet(cls *model.MenusGlobal, bitSet MenusGlobalBitSet) error {
 	if cls == nil {
 		return persistCore.EPersistErrorNil
@@ -1717,16 +2235,75 @@ func (m *MenusGlobalManager) MarkUpdateByBitSet(cls *model.MenusGlobal, bitSet M
 	m.InitDS(cls)
 
 	newCls := m.acquireDeepCopyObject(cls)
+	m.shadowMark(cls, bitSet)
 
-	persistSync := &MenusGlobalSync{Data: newCls, Op: EMenusGlobalOpUpdate, BitSet: bitSet}
+	persistSync := &MenusGlobalSync{Data: newCls, Op: EMenusGlobalOpUpdate, BitSet: bitSet, Table: m.segmentTable(MenusGlobalAuthId{AuthId: cls.AuthId})}
 
 	log.Println("[sql trace MenusGlobal]", m.PersistSyncToString(persistSync))
 
 	m.syncChan <- persistSync
 
+	return m.leaveLoadSet(cls)
+}
+
+// MarkUpdateBatch 批量标记脏对象, 所有对象一起进入写回队列, 任一对象 (1 数据没有导入或已经导出, 2 数据已经重新导入, 3 对象为空) 时都不标记
+// 修改后不满足LoadAllWhere条件的对象, 写回后从内存删除并返回EPersistErrorOutOfLoadSet
+func (m *MenusGlobalManager) MarkUpdateBatch(clsList []*model.MenusGlobal, bitSet MenusGlobalBitSet) error {
+	if err := m.checkUpdateBatch(clsList); err != nil {
+		return err
+	}
+	return m.markUpdateBatch(clsList, bitSet)
+}
+
+// checkUpdateBatch 检查批量标记的所有对象, 任一对象不能标记时返回失败
+func (m *MenusGlobalManager) checkUpdateBatch(clsList []*model.MenusGlobal) error {
+	for _, cls := range clsList {
+		if cls == nil {
+			return persistCore.EPersistErrorNil
+		}
+
+		if m.LoadAllState() != EMenusGlobalLoadStateMemory {
+			return persistCore.EPersistErrorNotInMemory
+		}
+
+		p := m.GetMenusGlobalByAuthId(cls.AuthId)
+		if p == nil || p != cls {
+			return persistCore.EPersistErrorOutOfDate
+		}
+	}
 	return nil
 }
 
+// markUpdateBatch 批量标记脏对象, 调用前需要checkUpdateBatch
+func (m *MenusGlobalManager) markUpdateBatch(clsList []*model.MenusGlobal, bitSet MenusGlobalBitSet) (err error) {
+	if len(clsList) == 0 {
+		return nil
+	}
+
+	batch := &MenusGlobalSync{Op: EMenusGlobalOpBatch, Batch: make([]*MenusGlobalSync, 0, len(clsList))}
+	for _, cls := range clsList {
+		m.InitDS(cls)
+
+		newCls := m.acquireDeepCopyObject(cls)
+		m.shadowMark(cls, bitSet)
+
+		persistSync := &MenusGlobalSync{Data: newCls, Op: EMenusGlobalOpUpdate, BitSet: bitSet, Table: m.segmentTable(MenusGlobalAuthId{AuthId: cls.AuthId})}
+
+		log.Println("[sql trace MenusGlobal]", m.PersistSyncToString(persistSync))
+
+		batch.Batch = append(batch.Batch, persistSync)
+	}
+
+	m.syncChan <- batch
+
+	for _, cls := range clsList {
+		if e := m.leaveLoadSet(cls); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
 // MarkUpdateByFieldIndex 标记脏对象并异步写回数据库, (1 数据没有导入或已经导出, 2 数据已经重新导入, 3 对象为空) 会返回失败
 func (m *MenusGlobalManager) MarkUpdateByFieldIndex(cls *model.MenusGlobal, fieldIndex MenusGlobalFieldIndex) error {
 	return m.MarkUpdateByBitSet(cls, *((&MenusGlobalBitSet{}).Set(fieldIndex)))
@@ -1753,6 +2330,85 @@ func (m *MenusGlobalManager) GetMenusGlobalsByAuthIdType(AuthId int64, Type stri
 	return
 }
 
+// PrefixSearchPath 通过前缀索引查找Path以prefix开头的对象, 按Path字典序, limit<=0不限制
+func (m *MenusGlobalManager) PrefixSearchPath(prefix string, limit int) []*model.MenusGlobal {
+	return m.prefixPath.Search(prefix, limit)
+}
+
+// Children 通过树索引查找直接子节点, 按导入顺序
+func (m *MenusGlobalManager) Children(AuthId int64) []*model.MenusGlobal {
+	return m.treeParentId.Children(AuthId)
+}
+
+// Descendants 通过树索引查找所有子孙节点, 按层序
+func (m *MenusGlobalManager) Descendants(AuthId int64) []*model.MenusGlobal {
+	return m.treeParentId.Descendants(AuthId)
+}
+
+// Ancestors 通过树索引查找所有祖先节点, 从父节点到根节点
+func (m *MenusGlobalManager) Ancestors(AuthId int64) []*model.MenusGlobal {
+	return m.treeParentId.Ancestors(AuthId)
+}
+
+// MoveSubtree 把节点移动到ParentId下, 重写整个子树的TreePath, 所有修改的对象批量标记写回
+// (1 数据没有导入或已经导出, 2 数据已经重新导入, 3 对象为空, 4 移动到自身或子孙节点下) 会返回失败
+func (m *MenusGlobalManager) MoveSubtree(cls *model.MenusGlobal, ParentId int64) error {
+	if cls == nil {
+		return persistCore.EPersistErrorNil
+	}
+
+	if m.LoadAllState() != EMenusGlobalLoadStateMemory {
+		return persistCore.EPersistErrorNotInMemory
+	}
+
+	p := m.GetMenusGlobalByAuthId(cls.AuthId)
+	if p == nil || p != cls {
+		return persistCore.EPersistErrorOutOfDate
+	}
+
+	m.treeMutex.Lock()
+	defer m.treeMutex.Unlock()
+
+	if m.treeParentId.IsDescendant(ParentId, cls.AuthId) {
+		return persistCore.EPersistErrorTreeCycle
+	}
+
+	// 层序遍历, 父节点的路径先于子节点更新, 全部检查通过后再修改
+	clsList := append([]*model.MenusGlobal{cls}, m.treeParentId.Descendants(cls.AuthId)...)
+	if err := m.checkUpdateBatch(clsList); err != nil {
+		return err
+	}
+
+	parentPath := ""
+	if parent := m.GetMenusGlobalByAuthId(ParentId); parent != nil {
+		parentPath = parent.TreePath
+	}
+	m.runtimeIndexes.Remove(cls)
+	m.objectLock(cls.AuthId).Lock()
+	cls.ParentId = ParentId
+	cls.TreePath = JoinTreePath(parentPath, ParentId)
+	m.objectLock(cls.AuthId).Unlock()
+	m.treeParentId.Insert(cls.AuthId, cls.ParentId, cls)
+	m.runtimeIndexes.Insert(cls)
+	m.aggregates.Insert(cls)
+
+	for _, child := range clsList[1:] {
+		if parent := m.GetMenusGlobalByAuthId(child.ParentId); parent != nil {
+			m.runtimeIndexes.Remove(child)
+			m.objectLock(child.AuthId).Lock()
+			child.TreePath = JoinTreePath(parent.TreePath, child.ParentId)
+			m.objectLock(child.AuthId).Unlock()
+			m.runtimeIndexes.Insert(child)
+			m.aggregates.Insert(child)
+		}
+	}
+
+	bitSet := MenusGlobalBitSet{}
+	bitSet.Set(EMenusGlobalFieldIndexParentId)
+	bitSet.Set(EMenusGlobalFieldIndexTreePath)
+	return m.markUpdateBatch(clsList, bitSet)
+}
+
 // GetAll 通过主键查找所有对象
 func (m *MenusGlobalManager) GetAll() (ret []*model.MenusGlobal) {
 
@@ -1770,28 +2426,150 @@ func (m *MenusGlobalManager) LoadAllState() int32 {
 
 // LoadAll (非线程安全) 导入所有数据, 全导入后只能全导出, 不能再按照key导入导出
 func (m *MenusGlobalManager) LoadAll() (err error) {
-	log.Println("MenusGlobalManager LoadAll begin")
-	// 未全导入状态切换到全导入
-	if atomic.CompareAndSwapInt32(&m.loadAll, EMenusGlobalTableStateDisk, EMenusGlobalTableStateLoading) {
-		rows := make([]*model.MenusGlobal, 0)
-		err = m.engine.Find(&rows, gMenusGlobalNil)
-		if err != nil {
-			atomic.StoreInt32(&m.loadAll, EMenusGlobalTableStateDisk)
-			return err
-		} else {
+	return m.LoadAllContext(context.Background(), nil)
+}
+
+// SetLoadAllPageSize 全导入每页行数, 必须在导入数据之前调用
+func (m *MenusGlobalManager) SetLoadAllPageSize(size int) {
+	m.loadAllPageSize = size
+}
+
+// LoadAllContext (非线程安全) 按主键分页导入所有数据, 每页导入后回调progress. ctx结束或出错时撤销已导入的数据
+func (m *MenusGlobalManager) LoadAllContext(ctx context.Context, progress func(persistCore.LoadProgress)) (err error) {
+	return m.LoadAllWhereContext(ctx, nil, nil, progress)
+}
+
+// LoadAllWhere (非线程安全) 只导入满足条件的数据. cond为数据库查询条件, match为相同条件的内存判断
+// 之后新增或修改的对象不满足match时只写回数据库, 不保留在内存中
+func (m *MenusGlobalManager) LoadAllWhere(cond builder.Cond, match func(cls *model.MenusGlobal) bool) (err error) {
+	return m.LoadAllWhereContext(context.Background(), cond, match, nil)
+}
 
+// LoadAllWhereContext (非线程安全) 按条件分页导入数据, 同LoadAllWhere和LoadAllContext
+func (m *MenusGlobalManager) LoadAllWhereContext(ctx context.Context, cond builder.Cond, match func(cls *model.MenusGlobal) bool, progress func(persistCore.LoadProgress)) (err error) {
+	if (cond == nil) != (match == nil) {
+		return errors.New("MenusGlobal: LoadAllWhere cond and match must be set together")
+	}
+	log.Println("MenusGlobalManager LoadAll begin")
+	// 未全导入状态切换到全导入
+	if !atomic.CompareAndSwapInt32(&m.loadAll, EMenusGlobalTableStateDisk, EMenusGlobalTableStateLoading) {
+		return persistCore.EPersistErrorIncorrectState
+	}
+	m.loadAllCond, m.loadAllMatch = cond, match
+
+	var added []*model.MenusGlobal
+	defer func() {
+		if err != nil {
+			for _, cls := range added {
+				m.removeMenusGlobal(cls)
+			}
+			m.loadAllCond, m.loadAllMatch = nil, nil
+			atomic.StoreInt32(&m.loadAll, EMenusGlobalTableStateDisk)
+		}
+	}()
+
+	shards, segment, err := m.loadAllShards(m.readEngine(nil))
+	if err != nil {
+		return
+	}
+	pageSize := m.loadAllPageSize
+	if pageSize <= 0 {
+		pageSize = persistCore.DefaultLoadAllPageSize
+	}
+	var loaded int64
+	for _, shard := range shards {
+		column := shard.Engine.Quote(shard.Engine.GetColumnMapper().Obj2Table("AuthId"))
+		var last int64
+		for first := true; ; first = false {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			session := shard.Engine.Context(ctx)
+			if shard.Table != "" {
+				session = session.Table(shard.Table)
+			}
+			if cond != nil {
+				session = session.And(cond)
+			}
+			if !first {
+				session = session.And(column+" > ?", last)
+			}
+			rows := make([]*model.MenusGlobal, 0, pageSize)
+			err = session.OrderBy(column).Limit(pageSize).Find(&rows, gMenusGlobalNil)
+			if err != nil {
+				return
+			}
 			for _, row := range rows {
-				m.addMenusGlobal(row)
+				last = row.AuthId
+				if segment {
+					// 同一主键以当前分表为准
+					if _, ok := m.tableMap.LoadOrStore(MenusGlobalAuthId{AuthId: row.AuthId}, shard.Table); ok {
+						continue
+					}
+				}
+				if cls, ok := m.addMenusGlobal(row); ok {
+					added = append(added, cls)
+				}
+			}
+			loaded += int64(len(rows))
+			if progress != nil {
+				progress(persistCore.LoadProgress{Persist: m.PersistName(), Table: shard.Table, Loaded: loaded})
+			}
+			if len(rows) < pageSize {
+				break
 			}
-			atomic.StoreInt32(&m.loadAll, EMenusGlobalTableStateMemory)
 		}
-	} else {
-		return persistCore.EPersistErrorIncorrectState
 	}
-	log.Println("MenusGlobalManager LoadAll end")
+	atomic.StoreInt32(&m.loadAll, EMenusGlobalTableStateMemory)
+	log.Println("MenusGlobalManager LoadAll end", loaded)
 	return
 }
 
+// LoadAllCond LoadAllWhere的数据库查询条件, 导入所有数据时为nil
+func (m *MenusGlobalManager) LoadAllCond() builder.Cond {
+	return m.loadAllCond
+}
+
+// inLoadSet 对象是否满足LoadAllWhere条件
+func (m *MenusGlobalManager) inLoadSet(cls *model.MenusGlobal) bool {
+	return m.loadAllMatch == nil || m.loadAllMatch(cls)
+}
+
+// leaveLoadSet 修改后不满足LoadAllWhere条件的对象从内存删除, 修改已经进入写回队列
+func (m *MenusGlobalManager) leaveLoadSet(cls *model.MenusGlobal) error {
+	if m.inLoadSet(cls) {
+		return nil
+	}
+	m.removeMenusGlobal(cls)
+	return persistCore.EPersistErrorOutOfLoadSet
+}
+
+// loadAllShards 全导入需要查询的数据库和表. 分片时为所有分片, 分表时依次为当前和上一个分表, segment表示记录对象所在分表
+func (m *MenusGlobalManager) loadAllShards(engine *xorm.Engine) (shards []Shard, segment bool, err error) {
+	if m.shardRouter != nil {
+		return m.shardRouter.Shards(), false, nil
+	}
+	current := m.TableName()
+	if current == "" {
+		return []Shard{{Engine: engine}}, false, nil
+	}
+	prev, _ := m.prevTableName.Load().(string)
+	for _, table := range []string{current, prev} {
+		if table == "" {
+			continue
+		}
+		var exist bool
+		exist, err = engine.IsTableExist(table)
+		if err != nil {
+			return
+		}
+		if exist {
+			shards = append(shards, Shard{Engine: engine, Table: table})
+		}
+	}
+	return shards, true, nil
+}
+
 // UnloadAll (非线程安全) 导出所有数据, 调用UnloadAll后,不允许再修改相关的数据(必须先导入才能修改数据)
 func (m *MenusGlobalManager) UnloadAll() (err error) {
 	var clsList []*model.MenusGlobal
@@ -1805,6 +2583,7 @@ func (m *MenusGlobalManager) UnloadAll() (err error) {
 		for _, cls := range clsList {
 			m.removeMenusGlobal(cls)
 		}
+		m.loadAllCond, m.loadAllMatch = nil, nil
 		atomic.StoreInt32(&m.loadAll, EMenusGlobalTableStateDisk)
 	} else {
 		return persistCore.EPersistErrorIncorrectState
@@ -1814,28 +2593,23 @@ func (m *MenusGlobalManager) UnloadAll() (err error) {
 
 var GMenusGlobalManager *MenusGlobalManager
 
-// init 注册管理类
-func init() {
-
-	engine := GetDB()
-	if engine == nil {
-		// log.Println(persistCore.EPersistErrorEngineNil)
-		persistCore.RegisterPersistLazy("MenusGlobal", GMenusGlobalManager)
-		return
-	}
-
-	GMenusGlobalManager = NewMenusGlobalManager(engine)
-	Register("MenusGlobal", GMenusGlobalManager)
-	// go GMenusGlobalManager.Collect()
-
-	//for idx, name := range MenusGlobalStructFiledMap {
-	//	MenusGlobalDBFiledMap[idx] = engine.GetColumnMapper().Obj2Table(name)
-	//}
-
+// MenusGlobalModule 显式创建MenusGlobalManager, 用于 data.New(cfg).With(MenusGlobalModule).Build()
+var MenusGlobalModule = Module{
+	Name: "MenusGlobal",
+	Build: func(c *Container, engine, replica *xorm.Engine) persistCore.IPersist {
+		c.MenusGlobal = NewMenusGlobalManager(engine)
+		c.MenusGlobal.SetReplica(replica, persistCore.ReplicaPolicy{})
+		return c.MenusGlobal
+	},
 }
 
 // LazyInit 惰性创建注册初始化
 func (m *MenusGlobalManager) LazyInit() (err error) {
+	return m.LazyInitRegistry(persistCore.DefaultRegistry())
+}
+
+// LazyInitRegistry 惰性创建注册到registry, 注册到默认Registry时替换GMenusGlobalManager
+func (m *MenusGlobalManager) LazyInitRegistry(registry *persistCore.Registry) (err error) {
 
 	if GetDB == nil {
 		err = errors.New("GetDB is nil")
@@ -1846,8 +2620,12 @@ func (m *MenusGlobalManager) LazyInit() (err error) {
 		err = errors.New("engine is nil")
 		return
 	}
-	GMenusGlobalManager = NewMenusGlobalManager(engine)
-	Register("MenusGlobal", GMenusGlobalManager)
+	manager := NewMenusGlobalManager(engine)
+	manager.SetReplica(GetReplicaDB(), persistCore.ReplicaPolicy{})
+	if registry == persistCore.DefaultRegistry() {
+		GMenusGlobalManager = manager
+	}
+	registry.RegisterPersist("MenusGlobal", manager)
 
 	return
 }
@@ -1860,13 +2638,17 @@ func (m *MenusGlobalManager) noneFunc() {
 	_ = sync.Mutex{}
 	_ = reflect.Value{}
 	_ = time.Now()
-	_ = sentry.Client{}
 	_ = strings.Builder{}
 	log.Println("none")
 }
 
 // SaveDB xorm写数据库
 func (m *MenusGlobalManager) SaveDB(session *xorm.Session, persistSync *MenusGlobalSync) (err error) {
+	defer func() {
+		if err == nil && persistSync.Op != EMenusGlobalOpUnload {
+			m.markWrite(MenusGlobalAuthId{AuthId: persistSync.Data.AuthId})
+		}
+	}()
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println("recovered in ", r)
@@ -1879,7 +2661,7 @@ func (m *MenusGlobalManager) SaveDB(session *xorm.Session, persistSync *MenusGlo
 	switch persistSync.Op {
 	case EMenusGlobalOpInsert:
 
-		_, err = session.Insert(persistSync.Data)
+		_, err = m.routeSession(session, MenusGlobalAuthId{AuthId: persistSync.Data.AuthId}, persistSync.Table).Insert(persistSync.Data)
 
 		if err != nil {
 			log.Println("insert error ", err, "[sql error MenusGlobal]", m.PersistSyncToString(persistSync))
@@ -1890,7 +2672,7 @@ func (m *MenusGlobalManager) SaveDB(session *xorm.Session, persistSync *MenusGlo
 		cls := persistSync.Data
 		bitSet := persistSync.BitSet
 		if bitSet.IsSetAll() {
-			_, err = session.ID(core.NewPK(cls.AuthId)).AllCols().Update(cls)
+			_, err = m.routeSession(session, MenusGlobalAuthId{AuthId: persistSync.Data.AuthId}, persistSync.Table).ID(core.NewPK(cls.AuthId)).AllCols().Update(cls)
 			if err != nil {
 				log.Println("update error ", err, "[sql error MenusGlobal]", m.PersistSyncToString(persistSync))
 				return
@@ -1903,13 +2685,13 @@ func (m *MenusGlobalManager) SaveDB(session *xorm.Session, persistSync *MenusGlo
 				}
 			}
 			if nameList != nil {
-				_, err = session.ID(core.NewPK(cls.AuthId)).Cols(nameList...).Update(cls)
+				_, err = m.routeSession(session, MenusGlobalAuthId{AuthId: persistSync.Data.AuthId}, persistSync.Table).ID(core.NewPK(cls.AuthId)).Cols(nameList...).Update(cls)
 				if err != nil {
 					log.Println("update error ", err, "[sql error MenusGlobal]", m.PersistSyncToString(persistSync))
 					return
 				}
 			} else {
-				_, err = session.ID(core.NewPK(cls.AuthId)).AllCols().Update(cls)
+				_, err = m.routeSession(session, MenusGlobalAuthId{AuthId: persistSync.Data.AuthId}, persistSync.Table).ID(core.NewPK(cls.AuthId)).AllCols().Update(cls)
 				if err != nil {
 					log.Println("update error ", err, "[sql error MenusGlobal]", m.PersistSyncToString(persistSync))
 					return
@@ -1919,7 +2701,7 @@ func (m *MenusGlobalManager) SaveDB(session *xorm.Session, persistSync *MenusGlo
 
 	case EMenusGlobalOpDelete:
 		cls := persistSync.Data
-		_, err = session.ID(core.NewPK(cls.AuthId)).Delete(gMenusGlobalNil)
+		_, err = m.routeSession(session, MenusGlobalAuthId{AuthId: persistSync.Data.AuthId}, persistSync.Table).ID(core.NewPK(cls.AuthId)).Delete(gMenusGlobalNil)
 		if err != nil {
 			log.Println("delete error ", err, "[sql error MenusGlobal]", m.PersistSyncToString(persistSync))
 			return
@@ -1935,6 +2717,9 @@ func (m *MenusGlobalManager) DataToFailQueue() {
 
 	// 一旦失败标记所有的数据都是失败, 不允许导出
 
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+
 	m.FailQueue = append(m.FailQueue, m.InsertQueue...)
 	m.InsertQueue = m.InsertQueue[0:0]
 
@@ -1948,6 +2733,7 @@ func (m *MenusGlobalManager) DataToFailQueue() {
 		}
 	}
 	*m.syncQueue = (*m.syncQueue)[0:0]
+	atomic.StoreInt32(&m.failQueueLength, int32(len(m.FailQueue)))
 }
 
 // LoadFile 文件读取写回失败数据
@@ -1969,10 +2755,15 @@ func (m *MenusGlobalManager) LoadFile() error {
 			return persistCore.EPersistErrorInvalidBombFile
 		}
 		persistData := data[pos+1:]
-		err = m.UnmarshalFailQueue(persistData, &m.FailQueue)
+		var failQueue []*MenusGlobalSync
+		err = m.UnmarshalFailQueue(persistData, &failQueue)
 		if err != nil {
 			return err
 		}
+		m.queueMutex.Lock()
+		m.FailQueue = failQueue
+		m.queueMutex.Unlock()
+		atomic.StoreInt32(&m.failQueueLength, int32(len(failQueue)))
 
 		session := m.engine.NewSession()
 		defer session.Close()
@@ -1981,14 +2772,20 @@ func (m *MenusGlobalManager) LoadFile() error {
 
 		for i := range m.FailQueue {
 			persistSync = m.FailQueue[i]
-			err = m.SaveDB(session, persistSync)
+			err = m.replayDB(session, persistSync)
 			if err != nil {
+				m.queueMutex.Lock()
 				m.FailQueue = m.FailQueue[i:]
+				m.queueMutex.Unlock()
+				atomic.StoreInt32(&m.failQueueLength, int32(len(m.FailQueue)))
 				m.SaveFile()
 				return err
 			}
 		}
+		m.queueMutex.Lock()
 		m.FailQueue = m.FailQueue[0:0]
+		m.queueMutex.Unlock()
+		atomic.StoreInt32(&m.failQueueLength, 0)
 		m.RemoveFile()
 
 	}
@@ -2004,8 +2801,22 @@ func (m *MenusGlobalManager) SaveFile() {
 	if err != nil {
 		log.Println("SaveFile marshal error ", err)
 	}
+	m.writeBombFile(data)
+}
+
+// writeBombFile 写入bomb文件, 退出超时导出队列后不再写入
+func (m *MenusGlobalManager) writeBombFile(data []byte) {
+	m.bombMutex.Lock()
+	defer m.bombMutex.Unlock()
+	if atomic.LoadInt32(&m.dumped) == 1 {
+		return
+	}
+	m.writeBomb(data)
+}
+
+func (m *MenusGlobalManager) writeBomb(data []byte) {
 	_ = os.Mkdir("_./_Users_xt_go_pumppill_data", 0770)
-	err = ioutil.WriteFile("_./_Users_xt_go_pumppill_data/MenusGlobal.tmp", append([]byte("MenusGlobal "), data...), 0660)
+	err := ioutil.WriteFile("_./_Users_xt_go_pumppill_data/MenusGlobal.tmp", append([]byte("MenusGlobal "), data...), 0660)
 	if err != nil {
 		log.Println("SaveFile write temp file error ", err)
 	}
@@ -2016,13 +2827,75 @@ func (m *MenusGlobalManager) SaveFile() {
 	_ = os.Remove("_./_Users_xt_go_pumppill_data/MenusGlobal.tmp")
 }
 
-// RemoveFile 删除写回失败文件
+// dumpQueue 退出超时, Collect协程调用, 剩余队列写入bomb文件. 写回协程可能仍阻塞在数据库操作中, 之后不再写数据库和bomb文件
+// 阻塞中的那条数据可能已经写入数据库, 恢复时插入失败且数据已存在则改为更新
+func (m *MenusGlobalManager) dumpQueue() {
+	m.bombMutex.Lock()
+	defer m.bombMutex.Unlock()
+	m.queueMutex.Lock()
+	atomic.StoreInt32(&m.dumped, 1)
+	queue := make([]*MenusGlobalSync, 0, len(m.FailQueue)+len(m.InsertQueue)+len(*m.syncQueue)+len(*m.cacheQueue))
+	queue = append(queue, m.FailQueue...)
+	queue = append(queue, m.InsertQueue...)
+	queue = append(queue, *m.syncQueue...)
+	queue = append(queue, *m.cacheQueue...)
+	m.queueMutex.Unlock()
+	for done := false; !done; {
+		select {
+		case persistSync := <-m.syncChan:
+			if persistSync.Op == EMenusGlobalOpBatch {
+				queue = append(queue, persistSync.Batch...)
+			} else {
+				queue = append(queue, persistSync)
+			}
+		default:
+			done = true
+		}
+	}
+	failQueue := make([]*MenusGlobalSync, 0, len(queue))
+	for _, persistSync := range queue {
+		switch persistSync.Op {
+		case EMenusGlobalOpInsert, EMenusGlobalOpUpdate, EMenusGlobalOpDelete:
+			failQueue = append(failQueue, persistSync)
+		default:
+		}
+	}
+	data, err := m.MarshalFailQueue(failQueue)
+	if err != nil {
+		log.Println("dumpQueue marshal error ", err)
+		return
+	}
+	m.writeBomb(data)
+	log.Println("MenusGlobalManager exit timeout, dump queue to bomb file", len(failQueue))
+}
+
+// RemoveFile 删除写回失败文件, 退出超时导出队列后不再删除
 func (m *MenusGlobalManager) RemoveFile() {
+	m.bombMutex.Lock()
+	defer m.bombMutex.Unlock()
+	if atomic.LoadInt32(&m.dumped) == 1 {
+		return
+	}
 	_ = os.Remove("_./_Users_xt_go_pumppill_data/MenusGlobal.tmp")
 	_ = os.Remove("_./_Users_xt_go_pumppill_data/MenusGlobal.bomb")
 	_ = os.Remove("_./_Users_xt_go_pumppill_data")
 }
 
+// replayDB 重放bomb数据, 退出超时导出的插入可能已经写入数据库, 插入失败且数据已存在时改为更新全部字段. 记录重放时间, 之后MaxStaleness内全导入读主库
+func (m *MenusGlobalManager) replayDB(session *xorm.Session, persistSync *MenusGlobalSync) (err error) {
+	defer atomic.StoreInt64(&m.replayTime, time.Now().UnixNano())
+	err = m.SaveDB(session, persistSync)
+	if err == nil || persistSync.Op != EMenusGlobalOpInsert {
+		return
+	}
+	dbCls := &model.MenusGlobal{AuthId: persistSync.Data.AuthId}
+	has, getErr := m.routeSession(session, MenusGlobalAuthId{AuthId: persistSync.Data.AuthId}, persistSync.Table).Get(dbCls)
+	if getErr != nil || !has {
+		return
+	}
+	return m.SaveDB(session, &MenusGlobalSync{Data: persistSync.Data, Op: EMenusGlobalOpUpdate, BitSet: m.bitSetAll, Table: persistSync.Table})
+}
+
 // RecoverBomb bomb数据写入数据库
 func (m *MenusGlobalManager) RecoverBomb(bomb []byte) (err error) {
 	var persistSync *MenusGlobalSync
@@ -2036,7 +2909,7 @@ func (m *MenusGlobalManager) RecoverBomb(bomb []byte) (err error) {
 	var i int
 	for i = range failQueue {
 		persistSync = failQueue[i]
-		err = m.SaveDB(session, persistSync)
+		err = m.replayDB(session, persistSync)
 		if err != nil {
 			break
 		}
@@ -2115,7 +2988,7 @@ LabelForSyncQueue:
 		}
 		// 第一次出现直接拷贝
 		if oldPersistSync, ok = persistSyncMap[pk]; !ok {
-			persistSyncMap[pk] = &MenusGlobalSync{Data: currentPersistSync.Data, Op: currentPersistSync.Op, BitSet: currentPersistSync.BitSet}
+			persistSyncMap[pk] = &MenusGlobalSync{Data: currentPersistSync.Data, Op: currentPersistSync.Op, BitSet: currentPersistSync.BitSet, Table: currentPersistSync.Table}
 			continue
 		}
 
@@ -2157,6 +3030,11 @@ LabelForSyncQueue:
 		case EMenusGlobalOpDelete:
 			switch currentPersistSync.Op {
 			case EMenusGlobalOpInsert:
+				// 删除和插入不在同一个分表, 不能合并为修改
+				if oldPersistSync.Table != currentPersistSync.Table {
+					fail = true
+					break LabelForSyncQueue
+				}
 				oldPersistSync.Op = EMenusGlobalOpUpdate
 				oldPersistSync.Data = currentPersistSync.Data
 				oldPersistSync.BitSet.SetAll()
@@ -2196,6 +3074,8 @@ LabelForSyncQueue:
 // Save 异步写回
 func (m *MenusGlobalManager) Save() {
 	var exit bool
+	atomic.StoreInt32(&m.saving, 1)
+	defer atomic.StoreInt32(&m.saving, 0)
 	for {
 		// 正常退出
 		exit = m.AsyncSave()
@@ -2218,6 +3098,7 @@ func (m *MenusGlobalManager) AsyncSave() (exit bool) {
 			if !queueEmpty {
 				log.Println("save failed: incrementalSave")
 			}
+			err = fmt.Errorf("%v", r)
 		} else {
 			if !queueEmpty {
 				if err == nil {
@@ -2227,8 +3108,14 @@ func (m *MenusGlobalManager) AsyncSave() (exit bool) {
 				}
 			}
 		}
+		if atomic.LoadInt32(&m.dumped) == 1 {
+			// 队列已经由Collect协程导出, Collect不再接收syncEnd
+			exit = true
+			return
+		}
 		m.DataToFailQueue()
 		m.lastWriteBackTime = time.Duration(time.Now().UnixNano() - bTime)
+		m.updateHealth(err)
 		m.syncEnd <- true
 	}()
 
@@ -2247,6 +3134,7 @@ func (m *MenusGlobalManager) AsyncSave() (exit bool) {
 
 	log.Println("begin incrementalSave", bTime)
 
+	m.queueMutex.Lock()
 	if len(m.FailQueue) > 0 {
 		tmpQueue := make([]*MenusGlobalSync, len(m.FailQueue)+len(*m.syncQueue))
 		copy(tmpQueue, m.FailQueue)
@@ -2260,6 +3148,7 @@ func (m *MenusGlobalManager) AsyncSave() (exit bool) {
 		m.syncQueue = &otherQueue
 		m.InsertQueue = insertQueue
 	}
+	m.queueMutex.Unlock()
 
 	multiInsertFn := func() bool {
 		var err error
@@ -2268,14 +3157,16 @@ func (m *MenusGlobalManager) AsyncSave() (exit bool) {
 				_ = session.Rollback()
 			} else {
 				if err == nil {
+					m.queueMutex.Lock()
 					m.InsertQueue = m.InsertQueue[0:0]
+					m.queueMutex.Unlock()
 				} else {
 					_ = session.Rollback()
 				}
 			}
 		}()
 
-		if len(m.InsertQueue) <= 0 {
+		if len(m.InsertQueue) <= 0 || atomic.LoadInt32(&m.dumped) == 1 {
 			return true
 		}
 		err = session.Begin()
@@ -2283,71 +3174,109 @@ func (m *MenusGlobalManager) AsyncSave() (exit bool) {
 			return false
 		}
 
+		// 分表或分片时按照分片(数据库和表名)分组批量插入, 每个数据库一个事务, 全部插入成功后提交
+		engineList := []*xorm.Engine{m.engine}
+		sessionMap := map[*xorm.Engine]*xorm.Session{m.engine: session}
+		defer func() {
+			// 未提交的事务关闭时回滚
+			for _, engine := range engineList[1:] {
+				_ = sessionMap[engine].Close()
+			}
+		}()
+		var shardList []Shard
+		shardQueueMap := map[Shard][]*MenusGlobalSync{}
+		for _, persistSync := range m.InsertQueue {
+			shard := m.insertShard(MenusGlobalAuthId{AuthId: persistSync.Data.AuthId}, persistSync.Table)
+			if _, ok := shardQueueMap[shard]; !ok {
+				shardList = append(shardList, shard)
+			}
+			shardQueueMap[shard] = append(shardQueueMap[shard], persistSync)
+		}
+
 		const num = 100
 		var insertArray [num]*model.MenusGlobal
-		length := len(m.InsertQueue)
-		quotient := length / num
-		remainder := length % num
-		for i := 0; i < quotient; i++ {
-			//fmt.Println("queue->(", i*num, "-", (i+1)*num, "): ", m.InsertQueue[i*num:(i+1)*num])
-			for j := 0; j < num; j++ {
-				insertArray[j] = m.InsertQueue[i*num+j].Data
+		for _, shard := range shardList {
+			shardSession, ok := sessionMap[shard.Engine]
+			if !ok {
+				shardSession = shard.Engine.NewSession()
+				engineList = append(engineList, shard.Engine)
+				sessionMap[shard.Engine] = shardSession
+				err = shardSession.Begin()
+				if err != nil {
+					return false
+				}
 			}
+			insertQueue := shardQueueMap[shard]
+			length := len(insertQueue)
+			quotient := length / num
+			remainder := length % num
+			for i := 0; i < quotient; i++ {
+				for j := 0; j < num; j++ {
+					insertArray[j] = insertQueue[i*num+j].Data
+				}
 
-			_, err = session.InsertMulti(insertArray[:])
-
-			if err != nil {
-				log.Println("InsertMulti error ", err)
-				return false
-			}
-		}
-		if remainder != 0 {
-			//fmt.Println("queue->(", quotient*num, "-", length, "): ", m.InsertQueue[quotient*num:length])
+				_, err = m.tableSession(shardSession, shard.Table).InsertMulti(insertArray[:])
 
-			insertArray = [num]*model.MenusGlobal{}
-			for j := 0; j < remainder; j++ {
-				insertArray[j] = m.InsertQueue[quotient*num+j].Data
+				if err != nil {
+					log.Println("InsertMulti error ", err)
+					return false
+				}
 			}
+			if remainder != 0 {
+				insertArray = [num]*model.MenusGlobal{}
+				for j := 0; j < remainder; j++ {
+					insertArray[j] = insertQueue[quotient*num+j].Data
+				}
 
-			_, err = session.InsertMulti(insertArray[:remainder])
+				_, err = m.tableSession(shardSession, shard.Table).InsertMulti(insertArray[:remainder])
 
+				if err != nil {
+					log.Println("InsertMulti error ", err)
+					return false
+				}
+			}
+		}
+		// 部分数据库提交后失败时, 单条插入改为更新已经提交的数据
+		for _, engine := range engineList {
+			err = sessionMap[engine].Commit()
 			if err != nil {
-				log.Println("InsertMulti error ", err)
 				return false
 			}
 		}
-		err = session.Commit()
-		if err != nil {
-			return false
+		for _, persistSync := range m.InsertQueue {
+			m.markWrite(MenusGlobalAuthId{AuthId: persistSync.Data.AuthId})
 		}
 		return true
 	}
 
 	multiInsertSuccess := multiInsertFn()
 
-	// 批量插入失败, 改为单条插入
+	// 批量插入失败, 改为单条插入, 已经写入数据库的改为更新
+	// 每条写入成功后移出队列, 退出超时导出的队列不包含已经写入的数据
 	if !multiInsertSuccess {
-		for idx, persistSync := range m.InsertQueue {
-			err = m.SaveDB(session, persistSync)
+		for len(m.InsertQueue) > 0 && atomic.LoadInt32(&m.dumped) == 0 {
+			err = m.replayDB(session, m.InsertQueue[0])
 			if err != nil {
-				m.InsertQueue = m.InsertQueue[idx:]
 				m.SaveFile()
 				return
 			}
+			m.queueMutex.Lock()
+			m.InsertQueue = m.InsertQueue[1:]
+			m.queueMutex.Unlock()
 		}
-		m.InsertQueue = m.InsertQueue[0:0]
 	}
 
-	for i := 0; i < len(*m.syncQueue); i++ {
-		persistSync = (*m.syncQueue)[i]
+	for len(*m.syncQueue) > 0 && atomic.LoadInt32(&m.dumped) == 0 {
+		persistSync = (*m.syncQueue)[0]
 		err = m.SaveDB(session, persistSync)
 		if err != nil {
-			*m.syncQueue = (*m.syncQueue)[i:]
 			m.SaveFile()
 			return
 		}
+		m.queueMutex.Lock()
+		*m.syncQueue = (*m.syncQueue)[1:]
+		m.queueMutex.Unlock()
 	}
-	*m.syncQueue = (*m.syncQueue)[0:0]
 	m.RemoveFile()
 	return
 }
@@ -2358,18 +3287,26 @@ func (m *MenusGlobalManager) Collect() {
 	var ok bool
 	// 0:normal  1:exit begin, save sync  2:save cache  3:save done
 	var state int8
+	defer atomic.StoreInt32(&m.collecting, 0)
 	go m.Save()
 	m.syncBegin <- true
 	for {
 		select {
 		case persistSync, ok = <-m.syncChan:
-			if ok {
+			m.queueMutex.Lock()
+			if ok && persistSync.Op == EMenusGlobalOpBatch {
+				*m.cacheQueue = append(*m.cacheQueue, persistSync.Batch...)
+			} else if ok {
 				*m.cacheQueue = append(*m.cacheQueue, persistSync)
 			}
+			m.queueMutex.Unlock()
 		case _, ok = <-m.syncEnd:
 			if ok {
 				m.CheckOverload()
+				m.pruneWrite()
+				m.queueMutex.Lock()
 				m.cacheQueue, m.syncQueue = m.syncQueue, m.cacheQueue
+				m.queueMutex.Unlock()
 				switch state {
 				case EMenusGlobalCollectStateNormal:
 					//go m.AsyncSave()
@@ -2384,11 +3321,28 @@ func (m *MenusGlobalManager) Collect() {
 					state = EMenusGlobalCollectStateSaveDone
 				case EMenusGlobalCollectStateSaveDone:
 					m.syncBegin <- false
-					<-m.syncEnd
-					m.exitEnd <- true
+					select {
+					case <-m.syncEnd:
+					case done := <-m.dumpChan:
+						m.dumpQueue()
+						close(done)
+						m.releaseSave()
+						return
+					}
+					select {
+					case m.exitEnd <- true:
+					case done := <-m.dumpChan:
+						// 写回已经完成, 队列为空
+						close(done)
+					}
 					return
 				}
 			}
+		case done := <-m.dumpChan:
+			m.dumpQueue()
+			close(done)
+			m.releaseSave()
+			return
 		case _, ok = <-m.exitBegin:
 			if ok {
 				state = EMenusGlobalCollectStateSaveSync
@@ -2399,6 +3353,71 @@ func (m *MenusGlobalManager) Collect() {
 	}
 }
 
+// ExitContext 管理类退出, ctx结束时由Collect协程将剩余队列写入bomb文件, 管理类标记为非法停止
+func (m *MenusGlobalManager) ExitContext(ctx context.Context) (err error) {
+	if atomic.LoadInt32(&m.managerState) != EMenusGlobalManagerStateNormal {
+		return
+	}
+
+	select {
+	case m.exitBegin <- true:
+	case <-ctx.Done():
+		m.requestDump()
+		atomic.StoreInt32(&m.managerState, EMenusGlobalManagerStatePanic)
+		return ctx.Err()
+	}
+	select {
+	case <-m.exitEnd:
+		atomic.StoreInt32(&m.managerState, EMenusGlobalManagerStateIdle)
+		return
+	case <-ctx.Done():
+		m.requestDump()
+		atomic.StoreInt32(&m.managerState, EMenusGlobalManagerStatePanic)
+		return ctx.Err()
+	}
+}
+
+// SyncContext 同步表结构, ctx结束时返回, 同步不会中断, 仍在后台继续
+func (m *MenusGlobalManager) SyncContext(ctx context.Context) (err error) {
+	return persistCore.WaitContext(ctx, func() error {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		return m.Sync(&wg)
+	})
+}
+
+// releaseSave 导出队列后等待写回协程结束, 写回协程可能阻塞在syncBegin或syncEnd上
+func (m *MenusGlobalManager) releaseSave() {
+	for atomic.LoadInt32(&m.saving) == 1 {
+		select {
+		case <-m.syncEnd:
+		case m.syncBegin <- false:
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+}
+
+// requestDump 请求Collect协程导出队列并等待完成
+func (m *MenusGlobalManager) requestDump() {
+	done := make(chan struct{})
+	m.dumpChan <- done
+	<-done
+}
+
+// RunContext 运行并导入上次失败数据, ctx结束时返回, 导入不会中断, 仍在后台继续
+func (m *MenusGlobalManager) RunContext(ctx context.Context) (err error) {
+	return persistCore.WaitContext(ctx, m.Run)
+}
+
+// SyncDataContext 全部内存数据写入数据库, ctx结束时返回, 写入不会中断, 仍在后台继续
+func (m *MenusGlobalManager) SyncDataContext(ctx context.Context, sentryDebug bool) (err error) {
+	return persistCore.WaitContext(ctx, func() error {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		return m.SyncData(&wg, sentryDebug)
+	})
+}
+
 // Exit 管理类退出
 func (m *MenusGlobalManager) Exit(wg *sync.WaitGroup) {
 	defer wg.Done()
@@ -2425,24 +3444,413 @@ func (m *MenusGlobalManager) Sync(wg *sync.WaitGroup) (err error) {
 // Segmentation 检查是否需要换表 如果需要换表 则根据时间 和切换间隔计算是否需要换表 否则为不处理
 func (m *MenusGlobalManager) Segmentation(wg *sync.WaitGroup) (err error) {
 	defer wg.Done()
+
+	m.segmentMutex.Lock()
+	defer m.segmentMutex.Unlock()
+
+	config := m.segmentation
+	if config.Mode == persistCore.ESegmentationModeNone {
+		return
+	}
+	current := m.TableName()
+	base := m.engine.TableName(gMenusGlobalNil)
+	seq := m.segmentSeq
+	var next string
+	switch config.Mode {
+	case persistCore.ESegmentationModeRows:
+		var count int64
+		count, err = m.engine.Table(current).Count()
+		if err != nil || config.MaxRows <= 0 || count < config.MaxRows {
+			return
+		}
+		seq++
+		next = config.TableName(base, time.Now(), seq)
+	default:
+		next = config.TableName(base, time.Now(), seq)
+	}
+	if next == current {
+		return
+	}
+	err = m.engine.Table(next).Sync2(gMenusGlobalNil)
+	if err != nil {
+		return
+	}
+	// 已经入队的记录带有表名, 切换后仍然写入原来的表
+	m.prevTableName.Store(current)
+	m.tableName.Store(next)
+	m.segmentSeq = seq
+	log.Println("MenusGlobalManager Segmentation", current, "->", next)
+	return
+}
+
+// SetSegmentation 设置分表配置, 创建当前分表. 必须在Run和导入数据之前调用
+func (m *MenusGlobalManager) SetSegmentation(config persistCore.SegmentationConfig) (err error) {
+	m.segmentMutex.Lock()
+	defer m.segmentMutex.Unlock()
+
+	if m.shardRouter != nil && config.Mode != persistCore.ESegmentationModeNone {
+		return errors.New("MenusGlobal: segmentation can not be used with shard router")
+	}
+	if config.Mode == persistCore.ESegmentationModeNone {
+		m.segmentation = config
+		m.segmentSeq = 0
+		m.tableName.Store("")
+		m.prevTableName.Store("")
+		return
+	}
+
+	now := time.Now()
+	base := m.engine.TableName(gMenusGlobalNil)
+	var current, prev string
+	seq := 0
+	switch config.Mode {
+	case persistCore.ESegmentationModeRows:
+		// 找到最后一张已存在的表
+		for {
+			var exist bool
+			exist, err = m.engine.IsTableExist(config.TableName(base, now, seq+1))
+			if err != nil {
+				return
+			}
+			if !exist {
+				break
+			}
+			seq++
+		}
+		current = config.TableName(base, now, seq)
+		if seq > 0 {
+			prev = config.TableName(base, now, seq-1)
+		}
+	default:
+		current = config.TableName(base, now, seq)
+		prev = config.TableName(base, config.PrevTime(now), seq)
+	}
+	err = m.engine.Table(current).Sync2(gMenusGlobalNil)
+	if err != nil {
+		return
+	}
+	m.segmentation = config
+	m.segmentSeq = seq
+	m.tableName.Store(current)
+	m.prevTableName.Store(prev)
+	return
+}
+
+// TableName 当前写入表名, 不分表为空
+func (m *MenusGlobalManager) TableName() string {
+	table, _ := m.tableName.Load().(string)
+	return table
+}
+
+// tableSession 分表时指定表名
+func (m *MenusGlobalManager) tableSession(session *xorm.Session, table string) *xorm.Session {
+	if table != "" {
+		return session.Table(table)
+	}
+	return session
+}
+
+// objectLock 对象所在分段的锁
+func (m *MenusGlobalManager) objectLock(AuthId int64) *sync.RWMutex {
+	return &m.objectMutex[uint64(AuthId)%uint64(len(m.objectMutex))]
+}
+
+// segmentTable 对象所在的分表, 没有记录时为当前表
+func (m *MenusGlobalManager) segmentTable(pk MenusGlobalAuthId) string {
+	if table, ok := m.tableMap.Load(pk); ok {
+		return table.(string)
+	}
+	return m.TableName()
+}
+
+// insertTable 新建对象写入当前表
+func (m *MenusGlobalManager) insertTable(pk MenusGlobalAuthId) string {
+	table := m.TableName()
+	if table != "" {
+		m.tableMap.Store(pk, table)
+	}
+	return table
+}
+
+// SetReplica 设置从库, 全导入读从库, 按key导入按照policy选择. engine为nil时不使用从库. 必须在Run和导入数据之前调用
+func (m *MenusGlobalManager) SetReplica(engine *xorm.Engine, policy persistCore.ReplicaPolicy) {
+	m.replica = engine
+	m.replicaPolicy = policy
+}
+
+// readEngine 导入使用的数据库, pk为nil表示全导入
+func (m *MenusGlobalManager) readEngine(pk *MenusGlobalAuthId) *xorm.Engine {
+	if m.replica == nil {
+		return m.engine
+	}
+	if pk == nil {
+		// 重放bomb后从库可能还没有同步, MaxStaleness内全导入读主库
+		if time.Since(time.Unix(0, atomic.LoadInt64(&m.replayTime))) <= m.replicaPolicy.MaxStaleness {
+			return m.engine
+		}
+		return m.replica
+	}
+	switch m.replicaPolicy.Mode {
+	case persistCore.EReplicaModeReplica:
+		return m.replica
+	case persistCore.EReplicaModeStaleness:
+		if value, ok := m.lastWriteMap.Load(*pk); ok {
+			if time.Since(value.(time.Time)) <= m.replicaPolicy.MaxStaleness {
+				return m.engine
+			}
+			m.lastWriteMap.Delete(*pk)
+		}
+		return m.replica
+	default:
+		return m.engine
+	}
+}
+
+// markWrite 记录写回时间, 用于从库延迟判断
+func (m *MenusGlobalManager) markWrite(pk MenusGlobalAuthId) {
+	if m.replica == nil || m.replicaPolicy.Mode != persistCore.EReplicaModeStaleness {
+		return
+	}
+	m.lastWriteMap.Store(pk, time.Now())
+}
+
+// pruneWrite 清理超过MaxStaleness的写回时间, Collect协程调用, 每隔MaxStaleness清理一次
+func (m *MenusGlobalManager) pruneWrite() {
+	if m.replica == nil || m.replicaPolicy.Mode != persistCore.EReplicaModeStaleness || time.Since(m.pruneTime) < m.replicaPolicy.MaxStaleness {
+		return
+	}
+	m.pruneTime = time.Now()
+	m.lastWriteMap.Range(func(pk, value any) bool {
+		if time.Since(value.(time.Time)) > m.replicaPolicy.MaxStaleness {
+			// 清理期间重新写回的不删除
+			m.lastWriteMap.CompareAndDelete(pk, value)
+		}
+		return true
+	})
+}
+
+// SetShardRouter 设置分片路由并创建所有分片表, 不能和分表同时使用. 必须在Run和导入数据之前调用
+func (m *MenusGlobalManager) SetShardRouter(router ShardRouter[MenusGlobalAuthId]) (err error) {
+	m.segmentMutex.Lock()
+	defer m.segmentMutex.Unlock()
+
+	if router != nil && m.segmentation.Mode != persistCore.ESegmentationModeNone {
+		return errors.New("MenusGlobal: shard router can not be used with segmentation")
+	}
+	if router != nil {
+		for _, shard := range router.Shards() {
+			err = shard.Engine.Table(shard.Table).Sync2(gMenusGlobalNil)
+			if err != nil {
+				return
+			}
+		}
+	}
+	m.shardRouter = router
 	return
 }
 
-// compareAndUpdate 比较数据库，不相同则更新
-func (m *MenusGlobalManager) compareAndUpdate(session *xorm.Session, cls *model.MenusGlobal, sentryDebug bool) (err error) {
-	update := func(session *xorm.Session, cls *model.MenusGlobal, memData, dbData string) {
-		log.Println("SyncData error. missing mark Mem. [sql error MenusGlobal]", memData)
-		log.Println("SyncData error. missing mark  Db. [sql error MenusGlobal]", dbData)
-		_, err = session.ID(core.NewPK(cls.AuthId)).AllCols().Update(cls)
+// insertShard 批量插入分组, 分片时为主键所在分片, 否则为主库和分表表名
+func (m *MenusGlobalManager) insertShard(pk MenusGlobalAuthId, table string) Shard {
+	if m.shardRouter != nil {
+		return m.shardRouter.Route(pk)
+	}
+	return Shard{Engine: m.engine, Table: table}
+}
+
+// routeSession 分片时按主键路由到数据库和表, 其他数据库使用自动关闭的session, 不在同一事务中
+func (m *MenusGlobalManager) routeSession(session *xorm.Session, pk MenusGlobalAuthId, table string) *xorm.Session {
+	if m.shardRouter != nil {
+		shard := m.shardRouter.Route(pk)
+		if shard.Engine != m.engine {
+			return shard.Engine.Table(shard.Table)
+		}
+		return session.Table(shard.Table)
+	}
+	return m.tableSession(session, table)
+}
+
+// find 导入数据. 分片时pk为nil查询所有分片, 否则只查询主键所在分片, 分片时不使用从库
+func (m *MenusGlobalManager) find(ctx context.Context, engine *xorm.Engine, cond *model.MenusGlobal, pk *MenusGlobalAuthId) (rows []*model.MenusGlobal, err error) {
+	if m.shardRouter == nil {
+		return m.findSegment(ctx, engine, cond)
+	}
+	shards := m.shardRouter.Shards()
+	if pk != nil {
+		shards = []Shard{m.shardRouter.Route(*pk)}
+	}
+	rows = make([]*model.MenusGlobal, 0)
+	for _, shard := range shards {
+		shardRows := make([]*model.MenusGlobal, 0)
+		err = shard.Engine.Context(ctx).Table(shard.Table).Find(&shardRows, cond)
+		if err != nil {
+			return
+		}
+		rows = append(rows, shardRows...)
+	}
+	return
+}
+
+// findSegment 分表时依次查询当前和上一个分表, 同一主键以当前分表为准
+func (m *MenusGlobalManager) findSegment(ctx context.Context, engine *xorm.Engine, cond *model.MenusGlobal) (rows []*model.MenusGlobal, err error) {
+	current := m.TableName()
+	if current == "" {
+		rows = make([]*model.MenusGlobal, 0)
+		err = engine.Context(ctx).Find(&rows, cond)
+		return
+	}
+	prev, _ := m.prevTableName.Load().(string)
+
+	pkMap := map[MenusGlobalAuthId]bool{}
+	for _, table := range []string{current, prev} {
+		if table == "" {
+			continue
+		}
+		var exist bool
+		exist, err = engine.IsTableExist(table)
 		if err != nil {
-			log.Println("SyncData update error.", err, "[sql error MenusGlobal]", m.PersistSyncToString(&MenusGlobalSync{
-				Data:   cls,
-				Op:     EMenusGlobalOpUpdate,
-				BitSet: m.bitSetAll,
-			}))
 			return
 		}
+		if !exist {
+			continue
+		}
+		tableRows := make([]*model.MenusGlobal, 0)
+		err = engine.Context(ctx).Table(table).Find(&tableRows, cond)
+		if err != nil {
+			return
+		}
+		for _, row := range tableRows {
+			pk := MenusGlobalAuthId{AuthId: row.AuthId}
+			if pkMap[pk] {
+				continue
+			}
+			pkMap[pk] = true
+			m.tableMap.Store(pk, table)
+			rows = append(rows, row)
+		}
 	}
+	return
+}
+
+// DiffMenusGlobal 按字段比较两个对象, 返回不一致的字段及其位图
+func (m *MenusGlobalManager) DiffMenusGlobal(mem, db *model.MenusGlobal) (diff []persistCore.FieldDiff, bitSet MenusGlobalBitSet) {
+	//AuthId	int64
+	if mem.AuthId != db.AuthId {
+		diff = append(diff, persistCore.FieldDiff{Field: "AuthId", Mem: mem.AuthId, DB: db.AuthId})
+		bitSet.Set(EMenusGlobalFieldIndexAuthId)
+	}
+
+	//ParentId	int64
+	if mem.ParentId != db.ParentId {
+		diff = append(diff, persistCore.FieldDiff{Field: "ParentId", Mem: mem.ParentId, DB: db.ParentId})
+		bitSet.Set(EMenusGlobalFieldIndexParentId)
+	}
+
+	//TreePath	string
+	if mem.TreePath != db.TreePath {
+		diff = append(diff, persistCore.FieldDiff{Field: "TreePath", Mem: mem.TreePath, DB: db.TreePath})
+		bitSet.Set(EMenusGlobalFieldIndexTreePath)
+	}
+
+	//Name	string
+	if mem.Name != db.Name {
+		diff = append(diff, persistCore.FieldDiff{Field: "Name", Mem: mem.Name, DB: db.Name})
+		bitSet.Set(EMenusGlobalFieldIndexName)
+	}
+
+	//Type	string
+	if mem.Type != db.Type {
+		diff = append(diff, persistCore.FieldDiff{Field: "Type", Mem: mem.Type, DB: db.Type})
+		bitSet.Set(EMenusGlobalFieldIndexType)
+	}
+
+	//RouteName	string
+	if mem.RouteName != db.RouteName {
+		diff = append(diff, persistCore.FieldDiff{Field: "RouteName", Mem: mem.RouteName, DB: db.RouteName})
+		bitSet.Set(EMenusGlobalFieldIndexRouteName)
+	}
+
+	//Path	string
+	if mem.Path != db.Path {
+		diff = append(diff, persistCore.FieldDiff{Field: "Path", Mem: mem.Path, DB: db.Path})
+		bitSet.Set(EMenusGlobalFieldIndexPath)
+	}
+
+	//Component	string
+	if mem.Component != db.Component {
+		diff = append(diff, persistCore.FieldDiff{Field: "Component", Mem: mem.Component, DB: db.Component})
+		bitSet.Set(EMenusGlobalFieldIndexComponent)
+	}
+
+	//Perm	string
+	if mem.Perm != db.Perm {
+		diff = append(diff, persistCore.FieldDiff{Field: "Perm", Mem: mem.Perm, DB: db.Perm})
+		bitSet.Set(EMenusGlobalFieldIndexPerm)
+	}
+
+	//Status	int64
+	if mem.Status != db.Status {
+		diff = append(diff, persistCore.FieldDiff{Field: "Status", Mem: mem.Status, DB: db.Status})
+		bitSet.Set(EMenusGlobalFieldIndexStatus)
+	}
+
+	//AffixTab	int64
+	if mem.AffixTab != db.AffixTab {
+		diff = append(diff, persistCore.FieldDiff{Field: "AffixTab", Mem: mem.AffixTab, DB: db.AffixTab})
+		bitSet.Set(EMenusGlobalFieldIndexAffixTab)
+	}
+
+	//HideChildrenInMenu	int64
+	if mem.HideChildrenInMenu != db.HideChildrenInMenu {
+		diff = append(diff, persistCore.FieldDiff{Field: "HideChildrenInMenu", Mem: mem.HideChildrenInMenu, DB: db.HideChildrenInMenu})
+		bitSet.Set(EMenusGlobalFieldIndexHideChildrenInMenu)
+	}
+
+	//HideInBreadcrumb	int64
+	if mem.HideInBreadcrumb != db.HideInBreadcrumb {
+		diff = append(diff, persistCore.FieldDiff{Field: "HideInBreadcrumb", Mem: mem.HideInBreadcrumb, DB: db.HideInBreadcrumb})
+		bitSet.Set(EMenusGlobalFieldIndexHideInBreadcrumb)
+	}
+
+	//HideInMenu	int64
+	if mem.HideInMenu != db.HideInMenu {
+		diff = append(diff, persistCore.FieldDiff{Field: "HideInMenu", Mem: mem.HideInMenu, DB: db.HideInMenu})
+		bitSet.Set(EMenusGlobalFieldIndexHideInMenu)
+	}
+
+	//HideInTab	int64
+	if mem.HideInTab != db.HideInTab {
+		diff = append(diff, persistCore.FieldDiff{Field: "HideInTab", Mem: mem.HideInTab, DB: db.HideInTab})
+		bitSet.Set(EMenusGlobalFieldIndexHideInTab)
+	}
+
+	//KeepAlive	int64
+	if mem.KeepAlive != db.KeepAlive {
+		diff = append(diff, persistCore.FieldDiff{Field: "KeepAlive", Mem: mem.KeepAlive, DB: db.KeepAlive})
+		bitSet.Set(EMenusGlobalFieldIndexKeepAlive)
+	}
+
+	//Sort	int64
+	if mem.Sort != db.Sort {
+		diff = append(diff, persistCore.FieldDiff{Field: "Sort", Mem: mem.Sort, DB: db.Sort})
+		bitSet.Set(EMenusGlobalFieldIndexSort)
+	}
+
+	//Icon	string
+	if mem.Icon != db.Icon {
+		diff = append(diff, persistCore.FieldDiff{Field: "Icon", Mem: mem.Icon, DB: db.Icon})
+		bitSet.Set(EMenusGlobalFieldIndexIcon)
+	}
+
+	//Redirect	string
+	if mem.Redirect != db.Redirect {
+		diff = append(diff, persistCore.FieldDiff{Field: "Redirect", Mem: mem.Redirect, DB: db.Redirect})
+		bitSet.Set(EMenusGlobalFieldIndexRedirect)
+	}
+	return
+}
+
+// compare 按字段比较内存对象和数据库, 数据库不存在时dbCls为nil
+func (m *MenusGlobalManager) compare(session *xorm.Session, memCls *model.MenusGlobal) (dbCls *model.MenusGlobal, diff []persistCore.FieldDiff, bitSet MenusGlobalBitSet, err error) {
 	resetTimeNSec := func(clsMem, clsDb *model.MenusGlobal) {
 		defer func() {
 			if r := recover(); r != nil {
@@ -2469,60 +3877,119 @@ func (m *MenusGlobalManager) compareAndUpdate(session *xorm.Session, cls *model.
 		}
 	}
 
-	dbCls := &model.MenusGlobal{
+	dbCls = &model.MenusGlobal{
 
-		AuthId: cls.AuthId,
+		AuthId: memCls.AuthId,
 	}
 	var has bool
-	has, err = session.Get(dbCls)
+	has, err = m.routeSession(session, MenusGlobalAuthId{AuthId: memCls.AuthId}, m.segmentTable(MenusGlobalAuthId{AuthId: memCls.AuthId})).Get(dbCls)
 	if err != nil || !has {
-		log.Println("SyncData query error.", err, "[sql error MenusGlobal]", m.PersistSyncToString(&MenusGlobalSync{
-			Data:   cls,
+		// 数据库中不存在时dbCls为nil, err为nil
+		log.Println("SyncData query error.", err, "has", has, "[sql error MenusGlobal]", m.PersistSyncToString(&MenusGlobalSync{
+			Data:   memCls,
 			Op:     0,
 			BitSet: m.bitSetAll,
 		}))
+		dbCls = nil
 		return
 	}
+	resetTimeNSec(memCls, dbCls)
+	diff, bitSet = m.DiffMenusGlobal(memCls, dbCls)
+	return
+}
+
+// compareAndUpdate 比较数据库，不相同则只更新不一致的列, 返回差异报告(一致时为nil)
+func (m *MenusGlobalManager) compareAndUpdate(session *xorm.Session, cls *model.MenusGlobal, sentryDebug bool) (report *persistCore.DiffReport, err error) {
 	memCls := m.GetMenusGlobalByAuthId(cls.AuthId)
-	if memCls != nil {
-		resetTimeNSec(memCls, dbCls)
-		memData := m.PersistSyncToString(&MenusGlobalSync{
+	if memCls == nil {
+		return
+	}
+	dbCls, diff, bitSet, err := m.compare(session, memCls)
+	if err != nil {
+		return
+	}
+	if dbCls == nil {
+		// 数据库中不存在, 可能还在写回队列中, 只报告不插入
+		report = &persistCore.DiffReport{Persist: "MenusGlobal", Pk: MenusGlobalAuthId{AuthId: memCls.AuthId}, Missing: true}
+		if sentryDebug {
+			memClsJson, _ := json.Marshal(&MenusGlobalSync{
+				Data:   memCls,
+				Op:     0,
+				BitSet: m.bitSetAll,
+			})
+			persistCore.Report(&persistCore.ReportEvent{
+				Kind:    persistCore.EReportKindMismatch,
+				Persist: "MenusGlobal",
+				Pk:      report.Pk,
+				MemJson: string(memClsJson),
+			})
+		}
+		return
+	}
+	if len(diff) == 0 {
+		return
+	}
+	report = &persistCore.DiffReport{Persist: "MenusGlobal", Pk: MenusGlobalAuthId{AuthId: memCls.AuthId}, Fields: diff}
+
+	// 数据库内存不一致
+	memData := m.PersistSyncToString(&MenusGlobalSync{Data: memCls, Op: 0, BitSet: bitSet})
+	dbData := m.PersistSyncToString(&MenusGlobalSync{Data: dbCls, Op: 0, BitSet: bitSet})
+	log.Println("SyncData error. missing mark Mem. [sql error MenusGlobal]", report.FieldNames(), memData)
+	log.Println("SyncData error. missing mark  Db. [sql error MenusGlobal]", report.FieldNames(), dbData)
+	if sentryDebug {
+		memClsJson, _ := json.Marshal(&MenusGlobalSync{
 			Data:   memCls,
 			Op:     0,
 			BitSet: m.bitSetAll,
 		})
-		dbData := m.PersistSyncToString(&MenusGlobalSync{
+		dbClsJson, _ := json.Marshal(&MenusGlobalSync{
 			Data:   dbCls,
 			Op:     0,
 			BitSet: m.bitSetAll,
 		})
-		// 数据库内存不一致
-		if strings.Compare(memData, dbData) != 0 {
-			if sentryDebug {
-				func() {
-					defer func() {
-						memClsJson, _ := json.Marshal(&MenusGlobalSync{
-							Data:   memCls,
-							Op:     0,
-							BitSet: m.bitSetAll,
-						})
-						dbClsJson, _ := json.Marshal(&MenusGlobalSync{
-							Data:   dbCls,
-							Op:     0,
-							BitSet: m.bitSetAll,
-						})
-						sentry.WithScope(func(scope *sentry.Scope) {
-							tag := "CompareError" + "MenusGlobal"
-							scope.SetTag(tag, "MenusGlobal")
-							scope.SetTag("transaction", "MenusGlobal")
-							scope.SetExtra("memClsJson", string(memClsJson))
-							scope.SetExtra("dbClsJson", string(dbClsJson))
-							sentry.CaptureMessage(tag)
-						})
-					}()
-				}()
-			}
-			update(session, memCls, memData, dbData)
+		persistCore.Report(&persistCore.ReportEvent{
+			Kind:    persistCore.EReportKindMismatch,
+			Persist: "MenusGlobal",
+			Pk:      report.Pk,
+			MemJson: string(memClsJson),
+			DBJson:  string(dbClsJson),
+			Fields:  diff,
+		})
+	}
+
+	var nameList []string
+	for idx, name := range MenusGlobalDBFiledMap {
+		if bitSet.Get(MenusGlobalFieldIndex(idx)) {
+			nameList = append(nameList, name)
+		}
+	}
+	_, err = m.routeSession(session, MenusGlobalAuthId{AuthId: memCls.AuthId}, m.segmentTable(MenusGlobalAuthId{AuthId: memCls.AuthId})).ID(core.NewPK(memCls.AuthId)).Cols(nameList...).Update(memCls)
+	if err != nil {
+		log.Println("SyncData update error.", err, "[sql error MenusGlobal]", m.PersistSyncToString(&MenusGlobalSync{
+			Data:   memCls,
+			Op:     EMenusGlobalOpUpdate,
+			BitSet: bitSet,
+		}))
+	}
+	return
+}
+
+// DiffData 全部内存数据和数据库按字段比较, 只返回差异不更新, 用于定位漏掉MarkUpdate的代码
+func (m *MenusGlobalManager) DiffData() (reportList []*persistCore.DiffReport, err error) {
+	session := m.engine.NewSession()
+	defer session.Close()
+
+	for _, cls := range m.GetAll() {
+		memCls := m.acquireDeepCopyObject(cls)
+		dbCls, diff, _, compareErr := m.compare(session, memCls)
+		if compareErr != nil {
+			err = compareErr
+			continue
+		}
+		if dbCls == nil {
+			reportList = append(reportList, &persistCore.DiffReport{Persist: "MenusGlobal", Pk: MenusGlobalAuthId{AuthId: memCls.AuthId}, Missing: true})
+		} else if len(diff) > 0 {
+			reportList = append(reportList, &persistCore.DiffReport{Persist: "MenusGlobal", Pk: MenusGlobalAuthId{AuthId: memCls.AuthId}, Fields: diff})
 		}
 	}
 	return
@@ -2539,25 +4006,23 @@ func (m *MenusGlobalManager) SyncData(wg *sync.WaitGroup, sentryDebug bool) (err
 		func() {
 			defer func() {
 				for _, cls := range m.GetAll() {
-					updateErr := m.compareAndUpdate(session, cls, sentryDebug)
+					_, updateErr := m.compareAndUpdate(session, cls, sentryDebug)
 					if updateErr != nil {
 						err = updateErr
 					}
 				}
 				if err != nil {
-					sentry.WithScope(func(scope *sentry.Scope) {
-						tagtag := "SyncDataError" + "MenusGlobal"
-						scope.SetTag("SyncDataError", "MenusGlobal")
-						scope.SetTag("transaction", "MenusGlobal")
-						scope.SetExtra(err.Error(), 1)
-						sentry.CaptureMessage(tagtag)
+					persistCore.Report(&persistCore.ReportEvent{
+						Kind:    persistCore.EReportKindError,
+						Persist: "MenusGlobal",
+						Err:     err,
 					})
 				}
 			}()
 		}()
 	} else {
 		for _, cls := range m.GetAll() {
-			updateErr := m.compareAndUpdate(session, cls, sentryDebug)
+			_, updateErr := m.compareAndUpdate(session, cls, sentryDebug)
 			if updateErr != nil {
 				err = updateErr
 			}
@@ -83,7 +83,7 @@ type readOnlyUserShareMapUnload struct {
 
 // expunged is an arbitrary pointer that marks entries which have been deleted
 // from the dirty map.
-var expungedUserShareMapUnload = new(*int32)
+var expungedUserShareMapUnload = new(*UserShareLoadState)
 
 // An entry is a slot in the map corresponding to a particular key.
 type entryUserShareMapUnload struct {
@@ -106,10 +106,10 @@ type entryUserShareMapUnload struct {
 	// p != expunged. If p == expunged, an entry's associated value can be updated
 	// only after first setting m.dirty[key] = e so that lookups using the dirty
 	// map find the entry.
-	p atomic.Pointer[*int32]
+	p atomic.Pointer[*UserShareLoadState]
 }
 
-func newEntryUserShareMapUnload(i *int32) *entryUserShareMapUnload {
+func newEntryUserShareMapUnload(i *UserShareLoadState) *entryUserShareMapUnload {
 	e := &entryUserShareMapUnload{}
 	e.p.Store(&i)
 	return e
@@ -125,7 +125,7 @@ func (m *UserShareMapUnload) loadReadOnly() readOnlyUserShareMapUnload {
 // Load returns the value stored in the map for a key, or nil if no
 // value is present.
 // The ok result indicates whether value was found in the map.
-func (m *UserShareMapUnload) Load(key int64) (value *int32, ok bool) {
+func (m *UserShareMapUnload) Load(key int64) (value *UserShareLoadState, ok bool) {
 	read := m.loadReadOnly()
 	e, ok := read.m[key]
 	if !ok && read.amended {
@@ -150,7 +150,7 @@ func (m *UserShareMapUnload) Load(key int64) (value *int32, ok bool) {
 	return e.load()
 }
 
-func (e *entryUserShareMapUnload) load() (value *int32, ok bool) {
+func (e *entryUserShareMapUnload) load() (value *UserShareLoadState, ok bool) {
 	p := e.p.Load()
 	if p == nil || p == expungedUserShareMapUnload {
 		return value, false
@@ -159,7 +159,7 @@ func (e *entryUserShareMapUnload) load() (value *int32, ok bool) {
 }
 
 // Store sets the value for a key.
-func (m *UserShareMapUnload) Store(key int64, value *int32) {
+func (m *UserShareMapUnload) Store(key int64, value *UserShareLoadState) {
 	_, _ = m.Swap(key, value)
 }
 
@@ -190,7 +190,7 @@ func (m *UserShareMapUnload) Clear() {
 //
 // If the entry is expunged, tryCompareAndSwap returns false and leaves
 // the entry unchanged.
-func (e *entryUserShareMapUnload) tryCompareAndSwap(old, new *int32) bool {
+func (e *entryUserShareMapUnload) tryCompareAndSwap(old, new *UserShareLoadState) bool {
 	p := e.p.Load()
 	if p == nil || p == expungedUserShareMapUnload || *p != old {
 		return false
@@ -222,14 +222,14 @@ func (e *entryUserShareMapUnload) unexpungeLocked() (wasExpunged bool) {
 // swapLocked unconditionally swaps a value into the entry.
 //
 // The entry must be known not to be expunged.
-func (e *entryUserShareMapUnload) swapLocked(i **int32) **int32 {
+func (e *entryUserShareMapUnload) swapLocked(i **UserShareLoadState) **UserShareLoadState {
 	return e.p.Swap(i)
 }
 
 // LoadOrStore returns the existing value for the key if present.
 // Otherwise, it stores and returns the given value.
 // The loaded result is true if the value was loaded, false if stored.
-func (m *UserShareMapUnload) LoadOrStore(key int64, value *int32) (actual *int32, loaded bool) {
+func (m *UserShareMapUnload) LoadOrStore(key int64, value *UserShareLoadState) (actual *UserShareLoadState, loaded bool) {
 	// Avoid locking if it's a clean hit.
 	read := m.loadReadOnly()
 	if e, ok := read.m[key]; ok {
@@ -269,7 +269,7 @@ func (m *UserShareMapUnload) LoadOrStore(key int64, value *int32) (actual *int32
 //
 // If the entry is expunged, tryLoadOrStore leaves the entry unchanged and
 // returns with ok==false.
-func (e *entryUserShareMapUnload) tryLoadOrStore(i *int32) (actual *int32, loaded, ok bool) {
+func (e *entryUserShareMapUnload) tryLoadOrStore(i *UserShareLoadState) (actual *UserShareLoadState, loaded, ok bool) {
 	p := e.p.Load()
 	if p == expungedUserShareMapUnload {
 		return actual, false, false
@@ -298,7 +298,7 @@ func (e *entryUserShareMapUnload) tryLoadOrStore(i *int32) (actual *int32, loade
 
 // LoadAndDelete deletes the value for a key, returning the previous value if any.
 // The loaded result reports whether the key was present.
-func (m *UserShareMapUnload) LoadAndDelete(key int64) (value *int32, loaded bool) {
+func (m *UserShareMapUnload) LoadAndDelete(key int64) (value *UserShareLoadState, loaded bool) {
 	read := m.loadReadOnly()
 	e, ok := read.m[key]
 	if !ok && read.amended {
@@ -326,7 +326,7 @@ func (m *UserShareMapUnload) Delete(key int64) {
 	m.LoadAndDelete(key)
 }
 
-func (e *entryUserShareMapUnload) delete() (value *int32, ok bool) {
+func (e *entryUserShareMapUnload) delete() (value *UserShareLoadState, ok bool) {
 	for {
 		p := e.p.Load()
 		if p == nil || p == expungedUserShareMapUnload {
@@ -342,7 +342,7 @@ func (e *entryUserShareMapUnload) delete() (value *int32, ok bool) {
 //
 // If the entry is expunged, trySwap returns false and leaves the entry
 // unchanged.
-func (e *entryUserShareMapUnload) trySwap(i **int32) (**int32, bool) {
+func (e *entryUserShareMapUnload) trySwap(i **UserShareLoadState) (**UserShareLoadState, bool) {
 	for {
 		p := e.p.Load()
 		if p == expungedUserShareMapUnload {
@@ -356,7 +356,7 @@ func (e *entryUserShareMapUnload) trySwap(i **int32) (**int32, bool) {
 
 // Swap swaps the value for a key and returns the previous value if any.
 // The loaded result reports whether the key was present.
-func (m *UserShareMapUnload) Swap(key int64, value *int32) (previous *int32, loaded bool) {
+func (m *UserShareMapUnload) Swap(key int64, value *UserShareLoadState) (previous *UserShareLoadState, loaded bool) {
 	read := m.loadReadOnly()
 	if e, ok := read.m[key]; ok {
 		if v, ok := e.trySwap(&value); ok {
@@ -400,7 +400,7 @@ func (m *UserShareMapUnload) Swap(key int64, value *int32) (previous *int32, loa
 // CompareAndSwap swaps the old and new values for key
 // if the value stored in the map is equal to old.
 // The old value must be of a comparable type.
-func (m *UserShareMapUnload) CompareAndSwap(key int64, old, new *int32) (swapped bool) {
+func (m *UserShareMapUnload) CompareAndSwap(key int64, old, new *UserShareLoadState) (swapped bool) {
 	read := m.loadReadOnly()
 	if e, ok := read.m[key]; ok {
 		return e.tryCompareAndSwap(old, new)
@@ -432,7 +432,7 @@ func (m *UserShareMapUnload) CompareAndSwap(key int64, old, new *int32) (swapped
 //
 // If there is no current value for key in the map, CompareAndDelete
 // returns false (even if the old value is the nil interface value).
-func (m *UserShareMapUnload) CompareAndDelete(key int64, old *int32) (deleted bool) {
+func (m *UserShareMapUnload) CompareAndDelete(key int64, old *UserShareLoadState) (deleted bool) {
 	read := m.loadReadOnly()
 	e, ok := read.m[key]
 	if !ok && read.amended {
@@ -475,7 +475,7 @@ func (m *UserShareMapUnload) CompareAndDelete(key int64, old *int32) (deleted bo
 //
 // Range may be O(N) with the number of elements in the map even if f returns
 // false after a constant number of calls.
-func (m *UserShareMapUnload) Range(f func(key int64, value *int32) bool) {
+func (m *UserShareMapUnload) Range(f func(key int64, value *UserShareLoadState) bool) {
 	// We need to be able to iterate over all of the keys that were already
 	// present at the start of the call to Range.
 	// If read.amended is false, then read.m satisfies that property without
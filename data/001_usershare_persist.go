@@ -4,15 +4,17 @@
 package data
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"log"
 	"math"
 	"runtime/debug"
 
-	"github.com/getsentry/sentry-go"
 	jsoniter "github.com/json-iterator/go"
+	"xorm.io/builder"
 	"xorm.io/xorm"
 
 	"reflect"
@@ -207,6 +209,14 @@ var UserShareStructFiledMap = [EUserShareFiledIndexLength]string{
 
 var UserShareDBFiledMap [EUserShareFiledIndexLength]string
 
+// UserShareHashFiledMap 生成的hash索引和前缀索引包含的字段, 只能通过SetIndexKey*修改
+var UserShareHashFiledMap = [EUserShareFiledIndexLength]bool{
+	EUserShareFieldIndexUid:      true,
+	EUserShareFieldIndexUserName: true,
+	EUserShareFieldIndexMobile:   true,
+	EUserShareFieldIndexStatus:   true,
+}
+
 // EUserShareWordSize the EUserShareWordSize of a bit set
 const EUserShareWordSize = UserShareFieldIndex(64)
 
@@ -290,6 +300,31 @@ type UserShareSync struct {
 	Data   *model.UserShare
 	Op     int8
 	BitSet UserShareBitSet
+	Table  string // 分表时写入的表名, 不分表为空
+}
+
+// UserShareLoadState 按key导入状态
+type UserShareLoadState struct {
+	State      int32 // 0:导出  1:导入开始  2:导入完成  3:准备导出  4:正在导出
+	LastAccess int64 // 最近访问时间(UnixNano), 用于淘汰空闲数据
+	Size       int64 // 估算内存字节数, 导入和写回时更新, 用于淘汰内存预算
+
+	loadMutex sync.Mutex                        // 导出切换到导入开始互斥
+	wait      atomic.Pointer[UserShareLoadWait] // 最近一次导入, 在状态切换到导入开始之前设置
+}
+
+// UserShareHooks 按key导入导出钩子
+type UserShareHooks struct {
+	BeforeLoad   func(Uid int64) error                          // 查询数据库之前, 返回错误时取消导入
+	AfterLoad    func(Uid int64, list []*model.UserShare) error // 数据加入内存之后, 返回错误时撤销导入
+	BeforeUnload func(Uid int64, list []*model.UserShare)       // 写回goroutine中, 数据移出内存之前
+	AfterUnload  func(Uid int64)                                // 写回goroutine中, 数据移出内存之后
+}
+
+// UserShareLoadWait 一次导入, 完成后关闭done, 并发导入的调用方等待done并获得同样的结果
+type UserShareLoadWait struct {
+	done chan struct{}
+	err  error
 }
 
 // UserShareManager 结构定义
@@ -302,7 +337,7 @@ type UserShareManager struct {
 	loadAll int32
 
 	// 不存在 or 0:导出  1:导入开始  2:导入完成  3:准备导出  4:正在导出
-	loadUidMap UserShareMapUnload // map[Uid]state(atom)
+	loadUidMap UserShareMapUnload // map[Uid]*UserShareLoadState
 
 	pool              *sync.Pool
 	syncChan          chan *UserShareSync
@@ -311,6 +346,47 @@ type UserShareManager struct {
 	FailQueue         []*UserShareSync
 	lastWriteBackTime time.Duration
 
+	// 写回队列锁, 写回协程修改syncQueue, cacheQueue, FailQueue, InsertQueue以及其他协程读取时加锁, 写回协程自己读取不加锁
+	queueMutex sync.Mutex
+	// FailQueue长度, 写回协程修改FailQueue后更新, 其他协程读取
+	failQueueLength int32
+
+	// 健康状态
+	healthMutex    sync.Mutex
+	lastSaveTime   time.Time
+	lastError      error
+	lastErrorTime  time.Time
+	failQueueSince time.Time
+
+	// 分表
+	segmentMutex  sync.Mutex
+	segmentation  persistCore.SegmentationConfig
+	segmentSeq    int
+	tableName     atomic.Value // string 当前写入表名, 不分表为空
+	prevTableName atomic.Value // string 上一个分表, 导入时一起查询
+	tableMap      sync.Map     // map[UserShareUid]string 分表时对象所在的表
+
+	// 分片, 不能和分表同时使用
+	shardRouter ShardRouter[UserShareUid]
+
+	// 对象锁, 按主键分段. SetField和SetIndexKey*修改对象, 管理接口和检测协程拷贝对象时加锁
+	objectMutex [64]sync.RWMutex
+
+	// 从库
+	replica       *xorm.Engine
+	replicaPolicy persistCore.ReplicaPolicy
+	lastWriteMap  sync.Map  // map[UserShareUid]time.Time 最近写回时间, 仅EReplicaModeStaleness
+	replayTime    int64     // 最近重放bomb时间(UnixNano), 之后MaxStaleness内全导入读主库
+	pruneTime     time.Time // 最近清理lastWriteMap时间, 仅Collect协程使用
+
+	loadAllPageSize int // 全导入每页行数, 0使用默认值
+
+	hooks atomic.Pointer[[]UserShareHooks] // 按key导入导出钩子, 写时复制
+
+	// 部分全导入条件, LoadAllWhere设置, UnloadAll清除
+	loadAllCond  builder.Cond
+	loadAllMatch func(cls *model.UserShare) bool
+
 	InsertQueue []*UserShareSync
 
 	syncBegin chan bool
@@ -318,17 +394,40 @@ type UserShareManager struct {
 	exitBegin chan bool
 	exitEnd   chan bool
 
+	// 退出超时导出队列, Collect协程导出后关闭请求中的chan并停止收集
+	dumpChan chan chan struct{}
+	// 已经导出队列, 写回协程不再写数据库和bomb文件
+	dumped int32
+	// 写回协程是否在运行
+	saving int32
+	// Collect协程是否在运行, 导出队列后等待写回协程结束才退出
+	collecting int32
+	// bomb文件写入删除互斥
+	bombMutex sync.Mutex
+
 	engine *xorm.Engine
 
 	hashUid UserShareHashUid
 
+	runtimeIndexes RuntimeIndexes[model.UserShare] // 运行时添加的索引
+
+	aggregates Aggregates[model.UserShare] // 注册的分组聚合
+
 	hashUserNameStatus UserShareHashUserNameStatus
 
 	hashMobile UserShareHashMobile
 
+	prefixUserName PrefixIndex[model.UserShare] // UserName前缀索引
+
 	// hashUidMark UserShareHashUidMark
 
 	bitSetAll UserShareBitSet
+
+	// 未标记修改检测(调试用), 保存每个对象最后一次标记时的影子拷贝
+	markDetector      int32
+	markDetectorMutex sync.Mutex // 开启关闭互斥, markDetectorStop在markDetector置1之前创建
+	markDetectorStop  chan struct{}
+	shadowMap         sync.Map // map[UserShareKeyTypeHashUid]*model.UserShare
 }
 
 var gUserShareNil = &model.UserShare{}
@@ -343,6 +442,7 @@ func NewUserShareManager(engine *xorm.Engine) (m *UserShareManager) {
 	m.syncBegin = make(chan bool)
 	m.exitBegin = make(chan bool)
 	m.exitEnd = make(chan bool)
+	m.dumpChan = make(chan chan struct{})
 	tmpCacheQueue := make([]*UserShareSync, 0)
 	m.cacheQueue = &tmpCacheQueue
 	m.lastWriteBackTime = 1 * time.Millisecond
@@ -391,17 +491,28 @@ func (m *UserShareManager) PersistUserNilObjInterfaceList() interface{} {
 	return &plist
 }
 
+// PersistUser 适配为IPersistUser, 用户uid作为Uid参与core按用户导入导出
+func (m *UserShareManager) PersistUser() persistCore.IPersistUser {
+	return persistCore.NewPersistUser[int64](m, func(uid int32) int64 { return int64(uid) })
+}
+
 // Run 运行并导入上次失败数据
 func (m *UserShareManager) Run() error {
 	if atomic.CompareAndSwapInt32(&m.managerState, EUserShareManagerStateIdle, EUserShareManagerStateNormal) {
 		if err := m.LoadFile(); err != nil {
 			return err
 		}
+		atomic.StoreInt32(&m.collecting, 1)
 		go m.Collect()
+	} else if atomic.LoadInt32(&m.collecting) == 1 {
+		// 退出超时导出队列后, Collect协程等待写回协程结束, 结束前不能重新运行
+		return persistCore.EPersistErrorSaving
 	} else if atomic.CompareAndSwapInt32(&m.managerState, EUserShareManagerStatePanic, EUserShareManagerStateNormal) {
+		atomic.StoreInt32(&m.dumped, 0)
 		if err := m.LoadFile(); err != nil {
 			return err
 		}
+		atomic.StoreInt32(&m.collecting, 1)
 		go m.Collect()
 	} else {
 	}
@@ -1173,10 +1284,17 @@ func (m *UserShareManager) BytesToPersistSync(data []byte) (persistSync *UserSha
 	persistSync = &UserShareSync{}
 	lenPersistData := len(data) - bitSetSize - 1
 
-	persistSync.Data = m.BytesToPersist(data[:lenPersistData])
+	op := data[lenPersistData]
+	if op&persistCore.ESyncFlagTable != 0 {
+		lenTable := int(binary.LittleEndian.Uint16(data[lenPersistData-2:]))
+		persistSync.Table = string(data[lenPersistData-2-lenTable : lenPersistData-2])
+		persistSync.Data = m.BytesToPersist(data[:lenPersistData-2-lenTable])
+	} else {
+		persistSync.Data = m.BytesToPersist(data[:lenPersistData])
+	}
 
 	i += lenPersistData
-	persistSync.Op = int8(data[i])
+	persistSync.Op = int8(op &^ persistCore.ESyncFlagTable)
 	i += 1
 	for j := 0; j < bitSetSize/8; j++ {
 		persistSync.BitSet.set[j] = binary.LittleEndian.Uint64(data[i:])
@@ -1207,6 +1325,11 @@ func (m *UserShareManager) PersistSyncToBytes(persistSync *UserShareSync) (data
 
 	pData := m.PersistToBytes(persistSync.Data, persistSync.BitSet)
 	size += len(pData) + 1 + bitSetSize
+	op := uint8(persistSync.Op)
+	if persistSync.Table != "" {
+		size += len(persistSync.Table) + 2
+		op |= persistCore.ESyncFlagTable
+	}
 
 	data = make([]byte, size)
 
@@ -1214,7 +1337,13 @@ func (m *UserShareManager) PersistSyncToBytes(persistSync *UserShareSync) (data
 
 	copy(data[i:], pData)
 	i += len(pData)
-	data[i] = uint8(persistSync.Op)
+	if persistSync.Table != "" {
+		copy(data[i:], persistSync.Table)
+		i += len(persistSync.Table)
+		binary.LittleEndian.PutUint16(data[i:], uint16(len(persistSync.Table)))
+		i += 2
+	}
+	data[i] = op
 	i += 1
 	for _, setItem := range persistSync.BitSet.set {
 		binary.LittleEndian.PutUint64(data[i:], setItem)
@@ -1337,8 +1466,8 @@ func (m *UserShareManager) releaseDeepCopyObject(cls *model.UserShare) {
 
 // CheckOverload 检查负载
 func (m *UserShareManager) CheckOverload() {
-	// queueLength 不是精确值,  cacheQueue, FailQueue 一写多读
-	queueLength := len(*m.cacheQueue) + len(m.FailQueue)
+	// 写回协程调用
+	queueLength := len(*m.cacheQueue) + int(atomic.LoadInt32(&m.failQueueLength))
 	if queueLength > 10000 {
 		if v, ok := ((interface{})(gUserShareNil)).(UserShareOverload); ok {
 			go utils.SafeGoRecoverWarpFunc(func() { v.Overload(queueLength, m.lastWriteBackTime) })
@@ -1348,17 +1477,216 @@ func (m *UserShareManager) CheckOverload() {
 	}
 }
 
+// QueueStat 写回队列长度
+func (m *UserShareManager) QueueStat() persistCore.QueueStat {
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+	return persistCore.QueueStat{
+		Chan:   len(m.syncChan),
+		Cache:  len(*m.cacheQueue),
+		Sync:   len(*m.syncQueue),
+		Insert: len(m.InsertQueue),
+		Fail:   len(m.FailQueue),
+	}
+}
+
+// FailQueueBomb 失败队列按照bomb文件格式序列化
+func (m *UserShareManager) FailQueueBomb() ([]byte, error) {
+	m.queueMutex.Lock()
+	failQueue := append([]*UserShareSync(nil), m.FailQueue...)
+	m.queueMutex.Unlock()
+	data, err := m.MarshalFailQueue(failQueue)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("UserShare "), data...), nil
+}
+
+// PersistInterfaceByPkJson 通过主键json查找对象, 返回深拷贝
+func (m *UserShareManager) PersistInterfaceByPkJson(data []byte) (interface{}, error) {
+	pk := UserShareUid{}
+	if err := json.Unmarshal(data, &pk); err != nil {
+		return nil, err
+	}
+	cls := m.getUserShareByUid(pk.Uid)
+	if cls == nil {
+		return nil, persistCore.EPersistErrorNotInMemory
+	}
+	m.objectLock(pk.Uid).RLock()
+	defer m.objectLock(pk.Uid).RUnlock()
+	return m.acquireDeepCopyObject(cls), nil
+}
+
+// EnableMarkDetector 开启未标记修改检测(调试用), 每隔interval比较对象和最后一次标记时的影子拷贝, 不一致的字段上报, autoMark为true时自动标记写回
+func (m *UserShareManager) EnableMarkDetector(interval time.Duration, autoMark bool) {
+	m.markDetectorMutex.Lock()
+	defer m.markDetectorMutex.Unlock()
+	if atomic.LoadInt32(&m.markDetector) != 0 {
+		return
+	}
+	stop := make(chan struct{})
+	m.markDetectorStop = stop
+	atomic.StoreInt32(&m.markDetector, 1)
+	for _, cls := range m.GetAll() {
+		m.shadowStore(cls)
+	}
+	go utils.SafeGoRecoverWarpFunc(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				m.DetectUnmarked(autoMark)
+			}
+		}
+	})()
+}
+
+// DisableMarkDetector 关闭未标记修改检测
+func (m *UserShareManager) DisableMarkDetector() {
+	m.markDetectorMutex.Lock()
+	defer m.markDetectorMutex.Unlock()
+	if !atomic.CompareAndSwapInt32(&m.markDetector, 1, 0) {
+		return
+	}
+	close(m.markDetectorStop)
+	m.shadowMap.Range(func(k, v interface{}) bool {
+		m.shadowMap.Delete(k)
+		return true
+	})
+}
+
+// DetectUnmarked 比较所有对象和影子拷贝, 上报没有标记的修改. 读取对象时不加锁, 只用于调试
+func (m *UserShareManager) DetectUnmarked(autoMark bool) (reportList []*persistCore.DiffReport) {
+	if atomic.LoadInt32(&m.markDetector) == 0 {
+		return
+	}
+	for _, cls := range m.GetAll() {
+		key := UserShareKeyTypeHashUid{cls.Uid}
+		// 和SetField等修改对象并发, 加锁拷贝
+		m.objectLock(cls.Uid).RLock()
+		memCls := m.acquireDeepCopyObject(cls)
+		m.objectLock(cls.Uid).RUnlock()
+		value, ok := m.shadowMap.Load(key)
+		if !ok {
+			m.shadowMap.Store(key, memCls)
+			continue
+		}
+		// DB为最后一次标记时的值
+		diff, bitSet := m.DiffUserShare(memCls, value.(*model.UserShare))
+		if len(diff) == 0 {
+			continue
+		}
+		report := &persistCore.DiffReport{Persist: "UserShare", Pk: UserShareUid{Uid: memCls.Uid}, Fields: diff}
+		reportList = append(reportList, report)
+		log.Println("MarkDetector error. missing mark. [sql error UserShare]", report.FieldNames(), m.PersistSyncToString(&UserShareSync{
+			Data:   memCls,
+			Op:     0,
+			BitSet: bitSet,
+		}))
+		persistCore.Report(&persistCore.ReportEvent{
+			Kind:    persistCore.EReportKindUnmarked,
+			Persist: "UserShare",
+			Pk:      report.Pk,
+			Fields:  diff,
+		})
+		if autoMark {
+			if err := m.MarkUpdateByBitSet(cls, bitSet); err == nil {
+				continue
+			}
+		}
+		// 同一处修改只上报一次
+		m.shadowMap.Store(key, memCls)
+	}
+	return
+}
+
+// shadowStore 保存对象的影子拷贝
+func (m *UserShareManager) shadowStore(cls *model.UserShare) {
+	if atomic.LoadInt32(&m.markDetector) == 0 {
+		return
+	}
+	m.shadowMap.Store(UserShareKeyTypeHashUid{cls.Uid}, m.acquireDeepCopyObject(cls))
+}
+
+// shadowMark 标记时按照bitSet更新影子拷贝
+func (m *UserShareManager) shadowMark(cls *model.UserShare, bitSet UserShareBitSet) {
+	if atomic.LoadInt32(&m.markDetector) == 0 {
+		return
+	}
+	key := UserShareKeyTypeHashUid{cls.Uid}
+	value, ok := m.shadowMap.Load(key)
+	if !ok || bitSet.IsSetAll() {
+		m.shadowMap.Store(key, m.acquireDeepCopyObject(cls))
+		return
+	}
+	shadow := m.acquireDeepCopyObject(value.(*model.UserShare))
+	m.PersistToPersistByBitSet(shadow, cls, bitSet)
+	m.shadowMap.Store(key, shadow)
+}
+
+// shadowDelete 删除影子拷贝
+func (m *UserShareManager) shadowDelete(cls *model.UserShare) {
+	m.shadowMap.Delete(UserShareKeyTypeHashUid{cls.Uid})
+}
+
+// updateHealth 每次写回后更新健康状态
+func (m *UserShareManager) updateHealth(err error) {
+	now := time.Now()
+	m.healthMutex.Lock()
+	defer m.healthMutex.Unlock()
+	if err != nil {
+		m.lastError = err
+		m.lastErrorTime = now
+	} else {
+		m.lastSaveTime = now
+	}
+	if atomic.LoadInt32(&m.failQueueLength) == 0 {
+		m.failQueueSince = time.Time{}
+	} else if m.failQueueSince.IsZero() {
+		m.failQueueSince = now
+	}
+}
+
+// Health 健康状态
+func (m *UserShareManager) Health() persistCore.Health {
+	m.healthMutex.Lock()
+	defer m.healthMutex.Unlock()
+	health := persistCore.Health{
+		Name:           "UserShare",
+		State:          atomic.LoadInt32(&m.managerState),
+		Dead:           m.Dead(),
+		LastWriteBack:  m.lastSaveTime,
+		LastErrorTime:  m.lastErrorTime,
+		FailQueue:      int(atomic.LoadInt32(&m.failQueueLength)),
+		FailQueueSince: m.failQueueSince,
+		BombExist:      utils.Exists("_./_Users_xt_go_pumppill_data/UserShare.bomb"),
+	}
+	if m.lastError != nil {
+		health.LastError = m.lastError.Error()
+	}
+	return health
+}
+
 // addUserShare添加一个对象
 func (m *UserShareManager) addUserShare(cls *model.UserShare) (*model.UserShare, bool) {
 
 	actual, loaded := m.hashUid.LoadOrStore(UserShareKeyTypeHashUid{cls.Uid}, cls)
 	if !loaded {
 		actual = cls
+		m.shadowStore(cls)
 
 		m.hashUserNameStatus.Store(UserShareKeyTypeHashUserNameStatus{cls.UserName, cls.Status}, cls)
 
 		m.hashMobile.Store(UserShareKeyTypeHashMobile{cls.Mobile}, cls)
 
+		m.prefixUserName.Insert(cls.UserName, cls)
+
+		m.runtimeIndexes.Insert(cls)
+
+		m.aggregates.Insert(cls)
 	}
 	return actual, !loaded
 }
@@ -1373,6 +1701,16 @@ func (m *UserShareManager) removeUserShare(cls *model.UserShare) {
 
 	m.hashUid.Delete(UserShareKeyTypeHashUid{cls.Uid})
 
+	m.prefixUserName.Remove(cls)
+
+	m.runtimeIndexes.Remove(cls)
+
+	m.aggregates.Remove(cls)
+
+	m.shadowDelete(cls)
+
+	m.tableMap.Delete(UserShareUid{Uid: cls.Uid})
+
 	return
 }
 
@@ -1418,6 +1756,7 @@ func (m *UserShareManager) InitDS(cls *model.UserShare) {
 }
 
 // NewUserShare 添加对象并异步写回数据库, (1 数据没有导入或已经导出, 2 数据已存在, 3 对象为空) 会返回失败
+// 不满足LoadAllWhere条件的对象只写回数据库, 返回EPersistErrorOutOfLoadSet
 func (m *UserShareManager) NewUserShare(cls *model.UserShare) (*model.UserShare, error) {
 
 	if cls == nil {
@@ -1428,6 +1767,25 @@ func (m *UserShareManager) NewUserShare(cls *model.UserShare) (*model.UserShare,
 		return nil, persistCore.EPersistErrorNotInMemory
 	}
 
+	if !m.inLoadSet(cls) {
+		if actual := m.getUserShareByUid(cls.Uid); actual != nil {
+			return actual, persistCore.EPersistErrorAlreadyExist
+		}
+		// 不满足全导入条件, 只写回数据库
+		m.InitDS(cls)
+		bitSet := UserShareBitSet{}
+		bitSet.SetAll()
+		newCls := m.acquireDeepCopyObject(cls)
+
+		persistSync := &UserShareSync{Data: newCls, Op: EUserShareOpInsert, BitSet: bitSet, Table: m.TableName()}
+
+		log.Println("[sql trace UserShare]", m.PersistSyncToString(persistSync))
+
+		m.syncChan <- persistSync
+
+		return nil, persistCore.EPersistErrorOutOfLoadSet
+	}
+
 	actual, success := m.addUserShare(cls)
 
 	if success {
@@ -1436,7 +1794,7 @@ func (m *UserShareManager) NewUserShare(cls *model.UserShare) (*model.UserShare,
 		bitSet.SetAll()
 		newCls := m.acquireDeepCopyObject(cls)
 
-		persistSync := &UserShareSync{Data: newCls, Op: EUserShareOpInsert, BitSet: bitSet}
+		persistSync := &UserShareSync{Data: newCls, Op: EUserShareOpInsert, BitSet: bitSet, Table: m.insertTable(UserShareUid{Uid: cls.Uid})}
 
 		log.Println("[sql trace UserShare]", m.PersistSyncToString(persistSync))
 
@@ -1459,11 +1817,12 @@ func (m *UserShareManager) DeleteUserShare(cls *model.UserShare) error {
 		return persistCore.EPersistErrorNotInMemory
 	}
 
-	p := m.GetUserShareByUid(cls.Uid)
+	p := m.getUserShareByUid(cls.Uid)
 	if p == nil || p != cls {
 		return persistCore.EPersistErrorOutOfDate
 	}
 
+	table := m.segmentTable(UserShareUid{Uid: cls.Uid})
 	m.removeUserShare(cls)
 
 	// 主键不能修改
@@ -1471,7 +1830,7 @@ func (m *UserShareManager) DeleteUserShare(cls *model.UserShare) error {
 	bitSet.SetAll()
 	newCls := m.acquireDeepCopyObject(cls)
 
-	persistSync := &UserShareSync{Data: newCls, Op: EUserShareOpDelete, BitSet: bitSet}
+	persistSync := &UserShareSync{Data: newCls, Op: EUserShareOpDelete, BitSet: bitSet, Table: table}
 
 	log.Println("[sql trace UserShare]", m.PersistSyncToString(persistSync))
 
@@ -1492,6 +1851,7 @@ func (m *UserShareManager) DeleteAll() {
 		if cls == nil {
 			continue
 		}
+		table := m.segmentTable(UserShareUid{Uid: cls.Uid})
 		m.removeUserShare(cls)
 
 		// 主键不能修改
@@ -1499,7 +1859,7 @@ func (m *UserShareManager) DeleteAll() {
 		bitSet.SetAll()
 		newCls := m.acquireDeepCopyObject(cls)
 
-		persistSync := &UserShareSync{Data: newCls, Op: EUserShareOpDelete, BitSet: bitSet}
+		persistSync := &UserShareSync{Data: newCls, Op: EUserShareOpDelete, BitSet: bitSet, Table: table}
 
 		log.Println("[sql trace UserShare]", m.PersistSyncToString(persistSync))
 
@@ -1524,14 +1884,24 @@ func (m *UserShareManager) SetIndexKeyUserNameStatus(cls *model.UserShare, UserN
 		return persistCore.EPersistErrorNotInMemory
 	}
 
+	m.runtimeIndexes.Remove(cls)
+
 	m.hashUserNameStatus.Delete(UserShareKeyTypeHashUserNameStatus{cls.UserName, cls.Status})
 
+	m.objectLock(cls.Uid).Lock()
 	cls.UserName = UserName
 
 	cls.Status = Status
+	m.objectLock(cls.Uid).Unlock()
 
 	m.hashUserNameStatus.Store(UserShareKeyTypeHashUserNameStatus{cls.UserName, cls.Status}, cls)
 
+	m.prefixUserName.Insert(cls.UserName, cls)
+
+	m.runtimeIndexes.Insert(cls)
+
+	m.aggregates.Insert(cls)
+
 	// return m.MarkUpdate(cls)
 	bitSet := UserShareBitSet{}
 
@@ -1554,12 +1924,20 @@ func (m *UserShareManager) SetIndexKeyMobile(cls *model.UserShare, Mobile string
 		return persistCore.EPersistErrorNotInMemory
 	}
 
+	m.runtimeIndexes.Remove(cls)
+
 	m.hashMobile.Delete(UserShareKeyTypeHashMobile{cls.Mobile})
 
+	m.objectLock(cls.Uid).Lock()
 	cls.Mobile = Mobile
+	m.objectLock(cls.Uid).Unlock()
 
 	m.hashMobile.Store(UserShareKeyTypeHashMobile{cls.Mobile}, cls)
 
+	m.runtimeIndexes.Insert(cls)
+
+	m.aggregates.Insert(cls)
+
 	// return m.MarkUpdate(cls)
 	bitSet := UserShareBitSet{}
 
@@ -1580,12 +1958,22 @@ func (m *UserShareManager) SetIndexKeyUserName(cls *model.UserShare, UserName st
 		return persistCore.EPersistErrorNotInMemory
 	}
 
+	m.runtimeIndexes.Remove(cls)
+
 	m.hashUserNameStatus.Delete(UserShareKeyTypeHashUserNameStatus{cls.UserName, cls.Status})
 
+	m.objectLock(cls.Uid).Lock()
 	cls.UserName = UserName
+	m.objectLock(cls.Uid).Unlock()
 
 	m.hashUserNameStatus.Store(UserShareKeyTypeHashUserNameStatus{cls.UserName, cls.Status}, cls)
 
+	m.prefixUserName.Insert(cls.UserName, cls)
+
+	m.runtimeIndexes.Insert(cls)
+
+	m.aggregates.Insert(cls)
+
 	// return m.MarkUpdate(cls)
 	bitSet := UserShareBitSet{}
 	bitSet.Set(EUserShareFieldIndexUserName)
@@ -1604,19 +1992,158 @@ func (m *UserShareManager) SetIndexKeyStatus(cls *model.UserShare, Status int64)
 		return persistCore.EPersistErrorNotInMemory
 	}
 
+	m.runtimeIndexes.Remove(cls)
+
 	m.hashUserNameStatus.Delete(UserShareKeyTypeHashUserNameStatus{cls.UserName, cls.Status})
 
+	m.objectLock(cls.Uid).Lock()
 	cls.Status = Status
+	m.objectLock(cls.Uid).Unlock()
 
 	m.hashUserNameStatus.Store(UserShareKeyTypeHashUserNameStatus{cls.UserName, cls.Status}, cls)
 
+	m.runtimeIndexes.Insert(cls)
+
+	m.aggregates.Insert(cls)
+
 	// return m.MarkUpdate(cls)
 	bitSet := UserShareBitSet{}
 	bitSet.Set(EUserShareFieldIndexStatus)
 	return m.MarkUpdateByBitSet(cls, bitSet)
 }
 
+// AddIndex 运行时添加字段索引, 用现有对象建立索引, 之后由增删, SetField和SetIndexKey*维护. 索引名为字段名
+func (m *UserShareManager) AddIndex(field string, unique bool) error {
+	return m.runtimeIndexes.AddField(field, unique, m.rangeAll)
+}
+
+// AddIndexFunc 运行时添加计算key的索引, key函数只能使用通过SetField和SetIndexKey*修改的字段, 可以使用IndexFunc转换
+func (m *UserShareManager) AddIndexFunc(name string, unique bool, key func(cls *model.UserShare) any) error {
+	return m.runtimeIndexes.Add(name, unique, key, m.rangeAll)
+}
+
+// GetUserShareByIndex 通过运行时索引查找对象, 非唯一索引返回任意一个
+func (m *UserShareManager) GetUserShareByIndex(name string, key any) *model.UserShare {
+	if data, ok := m.runtimeIndexes.Get(name, key); ok {
+		return data
+	}
+	return nil
+}
+
+// GetUserSharesByIndex 通过运行时索引查找所有对象
+func (m *UserShareManager) GetUserSharesByIndex(name string, key any) []*model.UserShare {
+	return m.runtimeIndexes.GetAll(name, key)
+}
+
+// AddAggregate 添加按字段分组的聚合并用现有对象初始化, 之后由增删, SetField和SetIndexKey*维护. 多个字段为组合分组, valueField为数值字段, 为空只计数
+func (m *UserShareManager) AddAggregate(name string, groupFields []string, valueField string) error {
+	return m.aggregates.AddField(name, groupFields, valueField, m.rangeAll)
+}
+
+// AddAggregateFunc 添加计算分组的聚合, 分组和值函数只能使用通过SetField和SetIndexKey*修改的字段
+func (m *UserShareManager) AddAggregateFunc(name string, group func(cls *model.UserShare) any, value func(cls *model.UserShare) float64) error {
+	return m.aggregates.Add(name, group, value, m.rangeAll)
+}
+
+// Aggregate 分组的聚合结果, 字段分组时key依次为各个分组字段的值
+func (m *UserShareManager) Aggregate(name string, key ...any) (AggregateValue, bool) {
+	return m.aggregates.Get(name, key...)
+}
+
+// RangeAggregate 遍历聚合的所有分组
+func (m *UserShareManager) RangeAggregate(name string, f func(key any, value AggregateValue) bool) {
+	m.aggregates.Range(name, f)
+}
+
+// SetField 修改字段并维护运行时索引和聚合, 标记脏对象并异步写回数据库. 主键和hash索引字段使用SetIndexKey*修改
+// (1 数据没有导入或已经导出, 2 数据已经重新导入, 3 对象为空, 4 字段不存在或类型不匹配) 会返回失败
+func (m *UserShareManager) SetField(cls *model.UserShare, field string, value any) error {
+	if cls == nil {
+		return persistCore.EPersistErrorNil
+	}
+	fieldIndex := EUserShareFiledIndexLength
+	for idx, name := range UserShareStructFiledMap {
+		if name == field {
+			fieldIndex = UserShareFieldIndex(idx)
+			break
+		}
+	}
+	if fieldIndex == EUserShareFiledIndexLength {
+		return errors.New("UserShare: unknown field " + field)
+	}
+	if UserShareHashFiledMap[fieldIndex] {
+		return errors.New("UserShare: hash index field " + field + " must be set by SetIndexKey")
+	}
+
+	p := m.getUserShareByUid(cls.Uid)
+	if p == nil || p != cls {
+		return persistCore.EPersistErrorOutOfDate
+	}
+
+	err := m.aggregates.Update(cls, func() error {
+		return m.runtimeIndexes.Update(cls, func() error {
+			m.objectLock(cls.Uid).Lock()
+			defer m.objectLock(cls.Uid).Unlock()
+			return setStructField(cls, field, value)
+		})
+	})
+	if err != nil {
+		return errors.New("UserShare: " + err.Error())
+	}
+	return m.MarkUpdateByFieldIndex(cls, fieldIndex)
+}
+
+// Query 内存查询, 存在索引时使用索引, 默认返回深拷贝
+func (m *UserShareManager) Query() *Query[model.UserShare] {
+	return NewQuery[model.UserShare](m)
+}
+
+// Page 按字段稳定排序分页, 返回总数和下一页游标
+func (m *UserShareManager) Page(page PageQuery) (*PageResult[model.UserShare], error) {
+	return m.Query().Page(page)
+}
+
+// QueryPrimaryField 主键字段名
+func (m *UserShareManager) QueryPrimaryField() string {
+	return "Uid"
+}
+
+// QueryRange 遍历所有对象
+func (m *UserShareManager) QueryRange(f func(cls *model.UserShare) bool) {
+	m.rangeAll(f)
+}
+
+// QueryLookup 字段等值索引查找, 使用单字段的hash索引和运行时字段索引
+func (m *UserShareManager) QueryLookup(field string, value any) ([]*model.UserShare, bool) {
+	switch field {
+	case "Uid":
+		if cls := m.GetUserShareByUid(value.(int64)); cls != nil {
+			return []*model.UserShare{cls}, true
+		}
+		return nil, true
+	case "Mobile":
+		if cls := m.GetUserShareByMobile(value.(string)); cls != nil {
+			return []*model.UserShare{cls}, true
+		}
+		return nil, true
+	}
+	return m.runtimeIndexes.LookupField(field, value)
+}
+
+// QueryCopy 深拷贝对象
+func (m *UserShareManager) QueryCopy(cls *model.UserShare) *model.UserShare {
+	return m.acquireDeepCopyObject(cls)
+}
+
+// rangeAll 遍历所有对象
+func (m *UserShareManager) rangeAll(f func(cls *model.UserShare) bool) {
+	m.hashUid.Range(func(k UserShareKeyTypeHashUid, v *model.UserShare) bool {
+		return f(v)
+	})
+}
+
 // MarkUpdate 标记脏对象并异步写回数据库, (1 数据没有导入或已经导出, 2 数据已经重新导入, 3 对象为空) 会返回失败
+// 修改后不满足LoadAllWhere条件时, 写回后从内存删除并返回EPersistErrorOutOfLoadSet
 func (m *UserShareManager) MarkUpdate(cls *model.UserShare) error {
 	if cls == nil {
 		return persistCore.EPersistErrorNil
@@ -1626,7 +2153,7 @@ func (m *UserShareManager) MarkUpdate(cls *model.UserShare) error {
 		return persistCore.EPersistErrorNotInMemory
 	}
 
-	p := m.GetUserShareByUid(cls.Uid)
+	p := m.getUserShareByUid(cls.Uid)
 	if p == nil || p != cls {
 		return persistCore.EPersistErrorOutOfDate
 	}
@@ -1635,17 +2162,19 @@ func (m *UserShareManager) MarkUpdate(cls *model.UserShare) error {
 	bitSet := UserShareBitSet{}
 	bitSet.SetAll()
 	newCls := m.acquireDeepCopyObject(cls)
+	m.shadowMark(cls, bitSet)
 
-	persistSync := &UserShareSync{Data: newCls, Op: EUserShareOpUpdate, BitSet: bitSet}
+	persistSync := &UserShareSync{Data: newCls, Op: EUserShareOpUpdate, BitSet: bitSet, Table: m.segmentTable(UserShareUid{Uid: cls.Uid})}
 
 	log.Println("[sql trace UserShare]", m.PersistSyncToString(persistSync))
 
 	m.syncChan <- persistSync
 
-	return nil
+	return m.leaveLoadSet(cls)
 }
 
 // MarkUpdateByBitSet 标记脏对象并异步写回数据库, (1 数据没有导入或已经导出, 2 数据已经重新导入, 3 对象为空) 会返回失败
+// 修改后不满足LoadAllWhere条件时, 写回后从内存删除并返回EPersistErrorOutOfLoadSet
 func (m *UserShareManager) MarkUpdateByBitSet(cls *model.UserShare, bitSet UserShareBitSet) error {
 	if cls == nil {
 		return persistCore.EPersistErrorNil
@@ -1655,7 +2184,7 @@ func (m *UserShareManager) MarkUpdateByBitSet(cls *model.UserShare, bitSet UserS
 		return persistCore.EPersistErrorNotInMemory
 	}
 
-	p := m.GetUserShareByUid(cls.Uid)
+	p := m.getUserShareByUid(cls.Uid)
 	if p == nil || p != cls {
 		return persistCore.EPersistErrorOutOfDate
 	}
@@ -1663,14 +2192,15 @@ func (m *UserShareManager) MarkUpdateByBitSet(cls *model.UserShare, bitSet UserS
 	m.InitDS(cls)
 
 	newCls := m.acquireDeepCopyObject(cls)
+	m.shadowMark(cls, bitSet)
 
-	persistSync := &UserShareSync{Data: newCls, Op: EUserShareOpUpdate, BitSet: bitSet}
+	persistSync := &UserShareSync{Data: newCls, Op: EUserShareOpUpdate, BitSet: bitSet, Table: m.segmentTable(UserShareUid{Uid: cls.Uid})}
 
 	log.Println("[sql trace UserShare]", m.PersistSyncToString(persistSync))
 
 	m.syncChan <- persistSync
 
-	return nil
+	return m.leaveLoadSet(cls)
 }
 
 // MarkUpdateByFieldIndex 标记脏对象并异步写回数据库, (1 数据没有导入或已经导出, 2 数据已经重新导入, 3 对象为空) 会返回失败
@@ -1681,6 +2211,15 @@ func (m *UserShareManager) MarkUpdateByFieldIndex(cls *model.UserShare, fieldInd
 // GetUserShareByUid 通过索引查找对象
 func (m *UserShareManager) GetUserShareByUid(Uid int64) *model.UserShare {
 
+	if data := m.getUserShareByUid(Uid); data != nil {
+		m.touch(data.Uid)
+		return data
+	}
+	return nil
+}
+
+// getUserShareByUid 通过主键查找对象, 内部使用不记录访问时间
+func (m *UserShareManager) getUserShareByUid(Uid int64) *model.UserShare {
 	if data, ok := m.hashUid.Load(UserShareKeyTypeHashUid{Uid}); ok {
 		return data
 	}
@@ -1691,6 +2230,7 @@ func (m *UserShareManager) GetUserShareByUid(Uid int64) *model.UserShare {
 func (m *UserShareManager) GetUserShareByUserNameStatus(UserName string, Status int64) *model.UserShare {
 
 	if data, ok := m.hashUserNameStatus.Load(UserShareKeyTypeHashUserNameStatus{UserName, Status}); ok {
+		m.touch(data.Uid)
 		return data
 	}
 	return nil
@@ -1700,11 +2240,21 @@ func (m *UserShareManager) GetUserShareByUserNameStatus(UserName string, Status
 func (m *UserShareManager) GetUserShareByMobile(Mobile string) *model.UserShare {
 
 	if data, ok := m.hashMobile.Load(UserShareKeyTypeHashMobile{Mobile}); ok {
+		m.touch(data.Uid)
 		return data
 	}
 	return nil
 }
 
+// PrefixSearchUserName 通过前缀索引查找UserName以prefix开头的对象, 按UserName字典序, limit<=0不限制
+func (m *UserShareManager) PrefixSearchUserName(prefix string, limit int) []*model.UserShare {
+	list := m.prefixUserName.Search(prefix, limit)
+	for _, data := range list {
+		m.touch(data.Uid)
+	}
+	return list
+}
+
 // GetAll 通过主键查找所有对象
 func (m *UserShareManager) GetAll() (ret []*model.UserShare) {
 
@@ -1722,33 +2272,155 @@ func (m *UserShareManager) LoadAllState() int32 {
 
 // LoadAll (非线程安全) 导入所有数据, 全导入后只能全导出, 不能再按照key导入导出
 func (m *UserShareManager) LoadAll() (err error) {
+	return m.LoadAllContext(context.Background(), nil)
+}
+
+// SetLoadAllPageSize 全导入每页行数, 必须在导入数据之前调用
+func (m *UserShareManager) SetLoadAllPageSize(size int) {
+	m.loadAllPageSize = size
+}
+
+// LoadAllContext (非线程安全) 按主键分页导入所有数据, 每页导入后回调progress. ctx结束或出错时撤销已导入的数据
+func (m *UserShareManager) LoadAllContext(ctx context.Context, progress func(persistCore.LoadProgress)) (err error) {
+	return m.LoadAllWhereContext(ctx, nil, nil, progress)
+}
+
+// LoadAllWhere (非线程安全) 只导入满足条件的数据. cond为数据库查询条件, match为相同条件的内存判断
+// 之后新增或修改的对象不满足match时只写回数据库, 不保留在内存中
+func (m *UserShareManager) LoadAllWhere(cond builder.Cond, match func(cls *model.UserShare) bool) (err error) {
+	return m.LoadAllWhereContext(context.Background(), cond, match, nil)
+}
+
+// LoadAllWhereContext (非线程安全) 按条件分页导入数据, 同LoadAllWhere和LoadAllContext
+func (m *UserShareManager) LoadAllWhereContext(ctx context.Context, cond builder.Cond, match func(cls *model.UserShare) bool, progress func(persistCore.LoadProgress)) (err error) {
+	if (cond == nil) != (match == nil) {
+		return errors.New("UserShare: LoadAllWhere cond and match must be set together")
+	}
 	log.Println("UserShareManager LoadAll begin")
 	// 未全导入状态切换到全导入
-	if atomic.CompareAndSwapInt32(&m.loadAll, EUserShareTableStateDisk, EUserShareTableStateLoading) {
-		rows := make([]*model.UserShare, 0)
-		err = m.engine.Find(&rows, gUserShareNil)
+	if !atomic.CompareAndSwapInt32(&m.loadAll, EUserShareTableStateDisk, EUserShareTableStateLoading) {
+		return persistCore.EPersistErrorIncorrectState
+	}
+	m.loadAllCond, m.loadAllMatch = cond, match
+
+	var added []*model.UserShare
+	defer func() {
 		if err != nil {
+			for _, cls := range added {
+				m.removeUserShare(cls)
+			}
+			m.loadAllCond, m.loadAllMatch = nil, nil
 			atomic.StoreInt32(&m.loadAll, EUserShareTableStateDisk)
-			return err
-		} else {
+		}
+	}()
 
+	shards, segment, err := m.loadAllShards(m.readEngine(nil))
+	if err != nil {
+		return
+	}
+	pageSize := m.loadAllPageSize
+	if pageSize <= 0 {
+		pageSize = persistCore.DefaultLoadAllPageSize
+	}
+	var loaded int64
+	for _, shard := range shards {
+		column := shard.Engine.Quote(shard.Engine.GetColumnMapper().Obj2Table("Uid"))
+		var last int64
+		for first := true; ; first = false {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			session := shard.Engine.Context(ctx)
+			if shard.Table != "" {
+				session = session.Table(shard.Table)
+			}
+			if cond != nil {
+				session = session.And(cond)
+			}
+			if !first {
+				session = session.And(column+" > ?", last)
+			}
+			rows := make([]*model.UserShare, 0, pageSize)
+			err = session.OrderBy(column).Limit(pageSize).Find(&rows, gUserShareNil)
+			if err != nil {
+				return
+			}
 			for _, row := range rows {
-				m.addUserShare(row)
+				last = row.Uid
+				if segment {
+					// 同一主键以当前分表为准
+					if _, ok := m.tableMap.LoadOrStore(UserShareUid{Uid: row.Uid}, shard.Table); ok {
+						continue
+					}
+				}
+				if cls, ok := m.addUserShare(row); ok {
+					added = append(added, cls)
+				}
+			}
+			loaded += int64(len(rows))
+			if progress != nil {
+				progress(persistCore.LoadProgress{Persist: m.PersistName(), Table: shard.Table, Loaded: loaded})
+			}
+			if len(rows) < pageSize {
+				break
 			}
-			atomic.StoreInt32(&m.loadAll, EUserShareTableStateMemory)
 		}
-	} else {
-		return persistCore.EPersistErrorIncorrectState
 	}
-	log.Println("UserShareManager LoadAll end")
+	atomic.StoreInt32(&m.loadAll, EUserShareTableStateMemory)
+	log.Println("UserShareManager LoadAll end", loaded)
 	return
 }
 
+// LoadAllCond LoadAllWhere的数据库查询条件, 导入所有数据时为nil
+func (m *UserShareManager) LoadAllCond() builder.Cond {
+	return m.loadAllCond
+}
+
+// inLoadSet 对象是否满足LoadAllWhere条件
+func (m *UserShareManager) inLoadSet(cls *model.UserShare) bool {
+	return m.loadAllMatch == nil || m.loadAllMatch(cls)
+}
+
+// leaveLoadSet 修改后不满足LoadAllWhere条件的对象从内存删除, 修改已经进入写回队列
+func (m *UserShareManager) leaveLoadSet(cls *model.UserShare) error {
+	if m.inLoadSet(cls) {
+		return nil
+	}
+	m.removeUserShare(cls)
+	return persistCore.EPersistErrorOutOfLoadSet
+}
+
+// loadAllShards 全导入需要查询的数据库和表. 分片时为所有分片, 分表时依次为当前和上一个分表, segment表示记录对象所在分表
+func (m *UserShareManager) loadAllShards(engine *xorm.Engine) (shards []Shard, segment bool, err error) {
+	if m.shardRouter != nil {
+		return m.shardRouter.Shards(), false, nil
+	}
+	current := m.TableName()
+	if current == "" {
+		return []Shard{{Engine: engine}}, false, nil
+	}
+	prev, _ := m.prevTableName.Load().(string)
+	for _, table := range []string{current, prev} {
+		if table == "" {
+			continue
+		}
+		var exist bool
+		exist, err = engine.IsTableExist(table)
+		if err != nil {
+			return
+		}
+		if exist {
+			shards = append(shards, Shard{Engine: engine, Table: table})
+		}
+	}
+	return shards, true, nil
+}
+
 // LoadState 查询包含该key的数据导入状态
 func (m *UserShareManager) LoadState(Uid int64) int32 {
 	if atomic.LoadInt32(&m.loadAll) == EUserShareTableStateDisk {
 		if value, ok := m.loadUidMap.Load(Uid); ok {
-			state := value
+			state := &value.State
 			return atomic.LoadInt32(state)
 		} else {
 			return EUserShareLoadStateDisk
@@ -1761,58 +2433,44 @@ func (m *UserShareManager) LoadState(Uid int64) int32 {
 // SetLoadState2Memory 没有数据时, 标记数据在内存中. 仅用于第一次数据库导入空数据, 错误使用会导致未定义的行为
 func (m *UserShareManager) SetLoadState2Memory(Uid int64) {
 	if atomic.LoadInt32(&m.loadAll) == EUserShareTableStateDisk {
-		p := int32(EUserShareLoadStateMemory)
-		m.loadUidMap.Store(Uid, &p)
+		m.loadUidMap.Store(Uid, &UserShareLoadState{State: EUserShareLoadStateMemory, LastAccess: time.Now().UnixNano()})
 	} else {
 	}
 }
 
 // Load 按照key导入数据, 必须存在unload key的索引
 func (m *UserShareManager) Load(Uid int64) (err error) {
+	return m.LoadContext(context.Background(), Uid)
+}
+
+// LoadContext 按照key导入数据, 必须存在unload key的索引. 正在导入时等待导入完成并返回导入结果, ctx结束时返回ctx.Err(), 导入在后台继续
+func (m *UserShareManager) LoadContext(ctx context.Context, Uid int64) (err error) {
 	// LoadAll后不能再次Load
 	if atomic.LoadInt32(&m.loadAll) == EUserShareTableStateDisk {
-		p := int32(0)
-		value, _ := m.loadUidMap.LoadOrStore(Uid, &p)
-		state := value
+		value, _ := m.loadUidMap.LoadOrStore(Uid, &UserShareLoadState{})
+		state := &value.State
+		atomic.StoreInt64(&value.LastAccess, time.Now().UnixNano())
 		// 检查导入状态
 		switch atomic.LoadInt32(state) {
 		// 未导入状态切换到导入
 		case EUserShareLoadStateDisk:
-			if atomic.CompareAndSwapInt32(state, EUserShareLoadStateDisk, EUserShareLoadStateLoading) {
-				rows := make([]*model.UserShare, 0)
-				err = m.engine.Find(&rows, &model.UserShare{Uid: Uid})
+			// 互斥切换状态, 先设置wait再切换状态, 看到导入开始的调用方一定能拿到本次的wait
+			value.loadMutex.Lock()
+			if atomic.LoadInt32(state) != EUserShareLoadStateDisk { // 其他调用方已经开始导入或状态变化, 重新检查
+				value.loadMutex.Unlock()
+				return m.LoadContext(ctx, Uid)
+			}
+			wait := &UserShareLoadWait{done: make(chan struct{})}
+			value.wait.Store(wait)
+			atomic.StoreInt32(state, EUserShareLoadStateLoading)
+			value.loadMutex.Unlock()
 
-				if err != nil { // 导入失败, 状态回到导出
-					atomic.StoreInt32(state, EUserShareLoadStateDisk)
-				} else {
-
-					for _, row := range rows {
-						m.addUserShare(row)
-					}
-					atomic.StoreInt32(state, EUserShareLoadStateMemory)
-				}
-				return
-			} else { // 期间状态变化,不确定操作是否成功
-				return persistCore.EPersistErrorUnknownError
-			}
+			// 导入由所有调用方共享, 不受第一个调用方的ctx取消影响
+			go m.loadKey(context.WithoutCancel(ctx), Uid, value, wait)
+			return m.waitLoad(ctx, value)
 
 		case EUserShareLoadStateLoading: // 正在导入
-			// 并发导入暂时轮询等待
-			bTime := time.Now().Unix()
-			for {
-				if atomic.LoadInt32(state) != EUserShareLoadStateLoading {
-					break
-				}
-				if time.Now().Unix() > bTime+persistCore.ELoadPollingTimeOut {
-					break
-				}
-				time.Sleep(time.Millisecond * 100)
-			}
-			if atomic.LoadInt32(state) == EUserShareLoadStateMemory {
-				return
-			} else {
-				return persistCore.EPersistErrorUnknownError
-			}
+			return m.waitLoad(ctx, value)
 
 		case EUserShareLoadStateMemory: // 导入完成
 			return
@@ -1847,6 +2505,7 @@ func (m *UserShareManager) UnloadAll() (err error) {
 		for _, cls := range clsList {
 			m.removeUserShare(cls)
 		}
+		m.loadAllCond, m.loadAllMatch = nil, nil
 		atomic.StoreInt32(&m.loadAll, EUserShareTableStateDisk)
 	} else {
 		return persistCore.EPersistErrorIncorrectState
@@ -1860,7 +2519,7 @@ func (m *UserShareManager) Unload(Uid int64) (err error) {
 	// LoadAll后不能unload
 	if atomic.LoadInt32(&m.loadAll) == EUserShareTableStateDisk {
 		if value, ok := m.loadUidMap.Load(Uid); ok {
-			state := value
+			state := &value.State
 
 			switch atomic.LoadInt32(state) {
 			case EUserShareLoadStateDisk: // 未导入
@@ -1890,40 +2549,223 @@ func (m *UserShareManager) Unload(Uid int64) (err error) {
 
 }
 
-// unload (非线程安全) 按照key导出数据, 必须存在unload key的索引. 调用Unload后,不允许再修改相关的数据(必须先导入才能修改数据).
-func (m *UserShareManager) unload(Uid int64) {
+// AddHooks 添加按key导入导出钩子, 按添加顺序调用
+func (m *UserShareManager) AddHooks(hooks UserShareHooks) {
+	for {
+		old := m.hooks.Load()
+		var list []UserShareHooks
+		if old != nil {
+			list = append(list, *old...)
+		}
+		list = append(list, hooks)
+		if m.hooks.CompareAndSwap(old, &list) {
+			return
+		}
+	}
+}
 
-	cls := m.GetUserShareByUid(Uid)
-	if cls != nil {
-		m.removeUserShare(cls)
+// getHooks 已添加的钩子
+func (m *UserShareManager) getHooks() []UserShareHooks {
+	if list := m.hooks.Load(); list != nil {
+		return *list
+	}
+	return nil
+}
+
+// beforeLoad 调用BeforeLoad钩子, 任一钩子返回错误时取消导入
+func (m *UserShareManager) beforeLoad(Uid int64) error {
+	for _, hooks := range m.getHooks() {
+		if hooks.BeforeLoad != nil {
+			if err := hooks.BeforeLoad(Uid); err != nil {
+				return fmt.Errorf("UserShare: before load hook: %w", err)
+			}
+		}
 	}
+	return nil
+}
 
+// afterLoad 调用AfterLoad钩子, 任一钩子返回错误时撤销导入
+func (m *UserShareManager) afterLoad(Uid int64, list []*model.UserShare) error {
+	for _, hooks := range m.getHooks() {
+		if hooks.AfterLoad != nil {
+			if err := hooks.AfterLoad(Uid, list); err != nil {
+				return fmt.Errorf("UserShare: after load hook: %w", err)
+			}
+		}
+	}
+	return nil
 }
 
-var GUserShareManager *UserShareManager
+// beforeUnload 调用BeforeUnload钩子, 钩子panic不影响写回
+func (m *UserShareManager) beforeUnload(Uid int64) {
+	hookList := m.getHooks()
+	if len(hookList) == 0 {
+		return
+	}
+	var list []*model.UserShare
+	if cls := m.getUserShareByUid(Uid); cls != nil {
+		list = append(list, cls)
+	}
+	for _, hooks := range hookList {
+		if hooks.BeforeUnload != nil {
+			m.safeHook(func() { hooks.BeforeUnload(Uid, list) })
+		}
+	}
+}
 
-// init 注册管理类
-func init() {
+// afterUnload 调用AfterUnload钩子, 钩子panic不影响写回
+func (m *UserShareManager) afterUnload(Uid int64) {
+	for _, hooks := range m.getHooks() {
+		if hooks.AfterUnload != nil {
+			m.safeHook(func() { hooks.AfterUnload(Uid) })
+		}
+	}
+}
 
-	engine := GetDB()
-	if engine == nil {
-		// log.Println(persistCore.EPersistErrorEngineNil)
-		persistCore.RegisterPersistLazy("UserShare", GUserShareManager)
+// safeHook 写回goroutine中调用钩子
+func (m *UserShareManager) safeHook(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("UserShare hook recovered in ", r)
+			log.Println("stack: ", string(debug.Stack()))
+		}
+	}()
+	fn()
+}
+
+// loadKey 导入key的数据, 结果写入wait后通知所有等待的调用方
+func (m *UserShareManager) loadKey(ctx context.Context, Uid int64, value *UserShareLoadState, wait *UserShareLoadWait) {
+	var err error
+	state := &value.State
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("recovered in ", r)
+			log.Println("stack: ", string(debug.Stack()))
+			err = fmt.Errorf("UserShare: load panic: %v", r)
+			atomic.StoreInt32(state, EUserShareLoadStateDisk)
+		}
+		wait.err = err
+		close(wait.done)
+	}()
+
+	if err = m.beforeLoad(Uid); err != nil { // 钩子取消导入, 状态回到导出
+		atomic.StoreInt32(state, EUserShareLoadStateDisk)
 		return
 	}
 
-	GUserShareManager = NewUserShareManager(engine)
-	Register("UserShare", GUserShareManager)
-	// go GUserShareManager.Collect()
+	var rows []*model.UserShare
+	pk := UserShareUid{Uid: Uid}
+	rows, err = m.find(ctx, m.readEngine(&pk), &model.UserShare{Uid: Uid}, &pk)
 
-	//for idx, name := range UserShareStructFiledMap {
-	//	UserShareDBFiledMap[idx] = engine.GetColumnMapper().Obj2Table(name)
-	//}
+	if err != nil { // 导入失败, 状态回到导出
+		atomic.StoreInt32(state, EUserShareLoadStateDisk)
+		return
+	}
+
+	var list []*model.UserShare
+	var size int64
+	for _, row := range rows {
+		if cls, ok := m.addUserShare(row); ok {
+			list = append(list, cls)
+			size += EstimateSize(cls)
+		}
+	}
+	atomic.StoreInt64(&value.Size, size)
+	if err = m.afterLoad(Uid, list); err != nil { // 钩子撤销导入, 状态回到导出
+		for _, cls := range list {
+			m.removeUserShare(cls)
+		}
+		atomic.StoreInt32(state, EUserShareLoadStateDisk)
+		return
+	}
+	atomic.StoreInt32(state, EUserShareLoadStateMemory)
+}
+
+// waitLoad 等待正在进行的导入完成, 返回导入结果
+func (m *UserShareManager) waitLoad(ctx context.Context, value *UserShareLoadState) error {
+	wait := value.wait.Load()
+	if wait == nil {
+		return persistCore.EPersistErrorUnknownError
+	}
+	select {
+	case <-wait.done:
+		return wait.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// touch 记录key最近访问时间
+func (m *UserShareManager) touch(Uid int64) {
+	if atomic.LoadInt32(&m.loadAll) != EUserShareTableStateDisk {
+		return
+	}
+	if value, ok := m.loadUidMap.Load(Uid); ok {
+		atomic.StoreInt64(&value.LastAccess, time.Now().UnixNano())
+	}
+}
+
+// updateSize 写回时按照写回的拷贝更新key估算内存, 拷贝写回后不再修改, 不和调用方竞争
+func (m *UserShareManager) updateSize(persistSync *UserShareSync) {
+	value, ok := m.loadUidMap.Load(persistSync.Data.Uid)
+	if !ok {
+		return
+	}
+	var size int64
+	if persistSync.Op != EUserShareOpDelete {
+		size = EstimateSize(persistSync.Data)
+	}
+	atomic.StoreInt64(&value.Size, size)
+}
+
+// AccessList 导入完成的key及最近访问时间和估算内存, 全导入时为空
+func (m *UserShareManager) AccessList() (list []persistCore.AccessInfo) {
+	if atomic.LoadInt32(&m.loadAll) != EUserShareTableStateDisk {
+		return
+	}
+	m.loadUidMap.Range(func(Uid int64, value *UserShareLoadState) bool {
+		if atomic.LoadInt32(&value.State) == EUserShareLoadStateMemory {
+			list = append(list, persistCore.AccessInfo{Key: Uid, LastAccess: time.Unix(0, atomic.LoadInt64(&value.LastAccess)), Size: atomic.LoadInt64(&value.Size)})
+		}
+		return true
+	})
+	return
+}
+
+// Evict 淘汰key的数据, 通过Unload在写回队列中排在该key的修改之后导出, 不会丢失修改
+func (m *UserShareManager) Evict(Uid int64) error {
+	return m.Unload(Uid)
+}
+
+// unload (非线程安全) 按照key导出数据, 必须存在unload key的索引. 调用Unload后,不允许再修改相关的数据(必须先导入才能修改数据).
+func (m *UserShareManager) unload(Uid int64) {
+
+	cls := m.getUserShareByUid(Uid)
+	if cls != nil {
+		m.removeUserShare(cls)
+	}
+
+}
+
+var GUserShareManager *UserShareManager
 
+// UserShareModule 显式创建UserShareManager, 用于 data.New(cfg).With(UserShareModule).Build()
+var UserShareModule = Module{
+	Name: "UserShare",
+	Build: func(c *Container, engine, replica *xorm.Engine) persistCore.IPersist {
+		c.UserShare = NewUserShareManager(engine)
+		c.UserShare.SetReplica(replica, persistCore.ReplicaPolicy{})
+		return c.UserShare
+	},
 }
 
 // LazyInit 惰性创建注册初始化
 func (m *UserShareManager) LazyInit() (err error) {
+	return m.LazyInitRegistry(persistCore.DefaultRegistry())
+}
+
+// LazyInitRegistry 惰性创建注册到registry, 注册到默认Registry时替换GUserShareManager
+func (m *UserShareManager) LazyInitRegistry(registry *persistCore.Registry) (err error) {
 
 	if GetDB == nil {
 		err = errors.New("GetDB is nil")
@@ -1934,8 +2776,12 @@ func (m *UserShareManager) LazyInit() (err error) {
 		err = errors.New("engine is nil")
 		return
 	}
-	GUserShareManager = NewUserShareManager(engine)
-	Register("UserShare", GUserShareManager)
+	manager := NewUserShareManager(engine)
+	manager.SetReplica(GetReplicaDB(), persistCore.ReplicaPolicy{})
+	if registry == persistCore.DefaultRegistry() {
+		GUserShareManager = manager
+	}
+	registry.RegisterPersist("UserShare", manager)
 
 	return
 }
@@ -1948,13 +2794,18 @@ func (m *UserShareManager) noneFunc() {
 	_ = sync.Mutex{}
 	_ = reflect.Value{}
 	_ = time.Now()
-	_ = sentry.Client{}
 	_ = strings.Builder{}
 	log.Println("none")
 }
 
 // SaveDB xorm写数据库
 func (m *UserShareManager) SaveDB(session *xorm.Session, persistSync *UserShareSync) (err error) {
+	defer func() {
+		if err == nil && persistSync.Op != EUserShareOpUnload {
+			m.markWrite(UserShareUid{Uid: persistSync.Data.Uid})
+			m.updateSize(persistSync)
+		}
+	}()
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println("recovered in ", r)
@@ -1967,7 +2818,7 @@ func (m *UserShareManager) SaveDB(session *xorm.Session, persistSync *UserShareS
 	switch persistSync.Op {
 	case EUserShareOpInsert:
 
-		_, err = session.Insert(persistSync.Data)
+		_, err = m.routeSession(session, UserShareUid{Uid: persistSync.Data.Uid}, persistSync.Table).Insert(persistSync.Data)
 
 		if err != nil {
 			log.Println("insert error ", err, "[sql error UserShare]", m.PersistSyncToString(persistSync))
@@ -1978,7 +2829,7 @@ func (m *UserShareManager) SaveDB(session *xorm.Session, persistSync *UserShareS
 		cls := persistSync.Data
 		bitSet := persistSync.BitSet
 		if bitSet.IsSetAll() {
-			_, err = session.ID(core.NewPK(cls.Uid)).AllCols().Update(cls)
+			_, err = m.routeSession(session, UserShareUid{Uid: persistSync.Data.Uid}, persistSync.Table).ID(core.NewPK(cls.Uid)).AllCols().Update(cls)
 			if err != nil {
 				log.Println("update error ", err, "[sql error UserShare]", m.PersistSyncToString(persistSync))
 				return
@@ -1991,13 +2842,13 @@ func (m *UserShareManager) SaveDB(session *xorm.Session, persistSync *UserShareS
 				}
 			}
 			if nameList != nil {
-				_, err = session.ID(core.NewPK(cls.Uid)).Cols(nameList...).Update(cls)
+				_, err = m.routeSession(session, UserShareUid{Uid: persistSync.Data.Uid}, persistSync.Table).ID(core.NewPK(cls.Uid)).Cols(nameList...).Update(cls)
 				if err != nil {
 					log.Println("update error ", err, "[sql error UserShare]", m.PersistSyncToString(persistSync))
 					return
 				}
 			} else {
-				_, err = session.ID(core.NewPK(cls.Uid)).AllCols().Update(cls)
+				_, err = m.routeSession(session, UserShareUid{Uid: persistSync.Data.Uid}, persistSync.Table).ID(core.NewPK(cls.Uid)).AllCols().Update(cls)
 				if err != nil {
 					log.Println("update error ", err, "[sql error UserShare]", m.PersistSyncToString(persistSync))
 					return
@@ -2007,7 +2858,7 @@ func (m *UserShareManager) SaveDB(session *xorm.Session, persistSync *UserShareS
 
 	case EUserShareOpDelete:
 		cls := persistSync.Data
-		_, err = session.ID(core.NewPK(cls.Uid)).Delete(gUserShareNil)
+		_, err = m.routeSession(session, UserShareUid{Uid: persistSync.Data.Uid}, persistSync.Table).ID(core.NewPK(cls.Uid)).Delete(gUserShareNil)
 		if err != nil {
 			log.Println("delete error ", err, "[sql error UserShare]", m.PersistSyncToString(persistSync))
 			return
@@ -2017,13 +2868,15 @@ func (m *UserShareManager) SaveDB(session *xorm.Session, persistSync *UserShareS
 		cls := persistSync.Data
 		Uid := cls.Uid
 		if value, ok := m.loadUidMap.Load(Uid); ok {
-			state := value
+			state := &value.State
 			// 准备导出,  不中断的清理玩家数据
 			// warning 导出后又修改, 不保证数据一致性
 			if atomic.CompareAndSwapInt32(state, EUserShareLoadStatePrepareUnloading, EUserShareLoadStateUnloading) {
+				m.beforeUnload(Uid)
 				m.unload(Uid)
 				m.loadUidMap.Delete(Uid)
 				atomic.StoreInt32(state, EUserShareLoadStateDisk)
+				m.afterUnload(Uid)
 			} else {
 				// 0:导出  1:导入开始  2:导入完成  4:正在导出  不确定状态
 				// 以上状态跳过吧
@@ -2042,6 +2895,9 @@ func (m *UserShareManager) DataToFailQueue() {
 
 	// 一旦失败标记所有的数据都是失败, 不允许导出
 
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+
 	m.FailQueue = append(m.FailQueue, m.InsertQueue...)
 	m.InsertQueue = m.InsertQueue[0:0]
 
@@ -2056,7 +2912,7 @@ func (m *UserShareManager) DataToFailQueue() {
 			cls := persistSync.Data
 			Uid := cls.Uid
 			if value, ok := m.loadUidMap.Load(Uid); ok {
-				state := value
+				state := &value.State
 				// 导出失败状态回退
 				if atomic.CompareAndSwapInt32(state, EUserShareLoadStatePrepareUnloading, EUserShareLoadStateMemory) {
 				} else {
@@ -2067,6 +2923,7 @@ func (m *UserShareManager) DataToFailQueue() {
 		}
 	}
 	*m.syncQueue = (*m.syncQueue)[0:0]
+	atomic.StoreInt32(&m.failQueueLength, int32(len(m.FailQueue)))
 }
 
 // LoadFile 文件读取写回失败数据
@@ -2088,10 +2945,15 @@ func (m *UserShareManager) LoadFile() error {
 			return persistCore.EPersistErrorInvalidBombFile
 		}
 		persistData := data[pos+1:]
-		err = m.UnmarshalFailQueue(persistData, &m.FailQueue)
+		var failQueue []*UserShareSync
+		err = m.UnmarshalFailQueue(persistData, &failQueue)
 		if err != nil {
 			return err
 		}
+		m.queueMutex.Lock()
+		m.FailQueue = failQueue
+		m.queueMutex.Unlock()
+		atomic.StoreInt32(&m.failQueueLength, int32(len(failQueue)))
 
 		session := m.engine.NewSession()
 		defer session.Close()
@@ -2100,14 +2962,20 @@ func (m *UserShareManager) LoadFile() error {
 
 		for i := range m.FailQueue {
 			persistSync = m.FailQueue[i]
-			err = m.SaveDB(session, persistSync)
+			err = m.replayDB(session, persistSync)
 			if err != nil {
+				m.queueMutex.Lock()
 				m.FailQueue = m.FailQueue[i:]
+				m.queueMutex.Unlock()
+				atomic.StoreInt32(&m.failQueueLength, int32(len(m.FailQueue)))
 				m.SaveFile()
 				return err
 			}
 		}
+		m.queueMutex.Lock()
 		m.FailQueue = m.FailQueue[0:0]
+		m.queueMutex.Unlock()
+		atomic.StoreInt32(&m.failQueueLength, 0)
 		m.RemoveFile()
 
 	}
@@ -2123,8 +2991,22 @@ func (m *UserShareManager) SaveFile() {
 	if err != nil {
 		log.Println("SaveFile marshal error ", err)
 	}
+	m.writeBombFile(data)
+}
+
+// writeBombFile 写入bomb文件, 退出超时导出队列后不再写入
+func (m *UserShareManager) writeBombFile(data []byte) {
+	m.bombMutex.Lock()
+	defer m.bombMutex.Unlock()
+	if atomic.LoadInt32(&m.dumped) == 1 {
+		return
+	}
+	m.writeBomb(data)
+}
+
+func (m *UserShareManager) writeBomb(data []byte) {
 	_ = os.Mkdir("_./_Users_xt_go_pumppill_data", 0770)
-	err = ioutil.WriteFile("_./_Users_xt_go_pumppill_data/UserShare.tmp", append([]byte("UserShare "), data...), 0660)
+	err := ioutil.WriteFile("_./_Users_xt_go_pumppill_data/UserShare.tmp", append([]byte("UserShare "), data...), 0660)
 	if err != nil {
 		log.Println("SaveFile write temp file error ", err)
 	}
@@ -2135,13 +3017,71 @@ func (m *UserShareManager) SaveFile() {
 	_ = os.Remove("_./_Users_xt_go_pumppill_data/UserShare.tmp")
 }
 
-// RemoveFile 删除写回失败文件
+// dumpQueue 退出超时, Collect协程调用, 剩余队列写入bomb文件. 写回协程可能仍阻塞在数据库操作中, 之后不再写数据库和bomb文件
+// 阻塞中的那条数据可能已经写入数据库, 恢复时插入失败且数据已存在则改为更新
+func (m *UserShareManager) dumpQueue() {
+	m.bombMutex.Lock()
+	defer m.bombMutex.Unlock()
+	m.queueMutex.Lock()
+	atomic.StoreInt32(&m.dumped, 1)
+	queue := make([]*UserShareSync, 0, len(m.FailQueue)+len(m.InsertQueue)+len(*m.syncQueue)+len(*m.cacheQueue))
+	queue = append(queue, m.FailQueue...)
+	queue = append(queue, m.InsertQueue...)
+	queue = append(queue, *m.syncQueue...)
+	queue = append(queue, *m.cacheQueue...)
+	m.queueMutex.Unlock()
+	for done := false; !done; {
+		select {
+		case persistSync := <-m.syncChan:
+			queue = append(queue, persistSync)
+		default:
+			done = true
+		}
+	}
+	failQueue := make([]*UserShareSync, 0, len(queue))
+	for _, persistSync := range queue {
+		switch persistSync.Op {
+		case EUserShareOpInsert, EUserShareOpUpdate, EUserShareOpDelete:
+			failQueue = append(failQueue, persistSync)
+		default:
+		}
+	}
+	data, err := m.MarshalFailQueue(failQueue)
+	if err != nil {
+		log.Println("dumpQueue marshal error ", err)
+		return
+	}
+	m.writeBomb(data)
+	log.Println("UserShareManager exit timeout, dump queue to bomb file", len(failQueue))
+}
+
+// RemoveFile 删除写回失败文件, 退出超时导出队列后不再删除
 func (m *UserShareManager) RemoveFile() {
+	m.bombMutex.Lock()
+	defer m.bombMutex.Unlock()
+	if atomic.LoadInt32(&m.dumped) == 1 {
+		return
+	}
 	_ = os.Remove("_./_Users_xt_go_pumppill_data/UserShare.tmp")
 	_ = os.Remove("_./_Users_xt_go_pumppill_data/UserShare.bomb")
 	_ = os.Remove("_./_Users_xt_go_pumppill_data")
 }
 
+// replayDB 重放bomb数据, 退出超时导出的插入可能已经写入数据库, 插入失败且数据已存在时改为更新全部字段. 记录重放时间, 之后MaxStaleness内全导入读主库
+func (m *UserShareManager) replayDB(session *xorm.Session, persistSync *UserShareSync) (err error) {
+	defer atomic.StoreInt64(&m.replayTime, time.Now().UnixNano())
+	err = m.SaveDB(session, persistSync)
+	if err == nil || persistSync.Op != EUserShareOpInsert {
+		return
+	}
+	dbCls := &model.UserShare{Uid: persistSync.Data.Uid}
+	has, getErr := m.routeSession(session, UserShareUid{Uid: persistSync.Data.Uid}, persistSync.Table).Get(dbCls)
+	if getErr != nil || !has {
+		return
+	}
+	return m.SaveDB(session, &UserShareSync{Data: persistSync.Data, Op: EUserShareOpUpdate, BitSet: m.bitSetAll, Table: persistSync.Table})
+}
+
 // RecoverBomb bomb数据写入数据库
 func (m *UserShareManager) RecoverBomb(bomb []byte) (err error) {
 	var persistSync *UserShareSync
@@ -2155,7 +3095,7 @@ func (m *UserShareManager) RecoverBomb(bomb []byte) (err error) {
 	var i int
 	for i = range failQueue {
 		persistSync = failQueue[i]
-		err = m.SaveDB(session, persistSync)
+		err = m.replayDB(session, persistSync)
 		if err != nil {
 			break
 		}
@@ -2234,7 +3174,7 @@ LabelForSyncQueue:
 		}
 		// 第一次出现直接拷贝
 		if oldPersistSync, ok = persistSyncMap[pk]; !ok {
-			persistSyncMap[pk] = &UserShareSync{Data: currentPersistSync.Data, Op: currentPersistSync.Op, BitSet: currentPersistSync.BitSet}
+			persistSyncMap[pk] = &UserShareSync{Data: currentPersistSync.Data, Op: currentPersistSync.Op, BitSet: currentPersistSync.BitSet, Table: currentPersistSync.Table}
 			continue
 		}
 
@@ -2276,6 +3216,11 @@ LabelForSyncQueue:
 		case EUserShareOpDelete:
 			switch currentPersistSync.Op {
 			case EUserShareOpInsert:
+				// 删除和插入不在同一个分表, 不能合并为修改
+				if oldPersistSync.Table != currentPersistSync.Table {
+					fail = true
+					break LabelForSyncQueue
+				}
 				oldPersistSync.Op = EUserShareOpUpdate
 				oldPersistSync.Data = currentPersistSync.Data
 				oldPersistSync.BitSet.SetAll()
@@ -2315,6 +3260,8 @@ LabelForSyncQueue:
 // Save 异步写回
 func (m *UserShareManager) Save() {
 	var exit bool
+	atomic.StoreInt32(&m.saving, 1)
+	defer atomic.StoreInt32(&m.saving, 0)
 	for {
 		// 正常退出
 		exit = m.AsyncSave()
@@ -2337,6 +3284,7 @@ func (m *UserShareManager) AsyncSave() (exit bool) {
 			if !queueEmpty {
 				log.Println("save failed: incrementalSave")
 			}
+			err = fmt.Errorf("%v", r)
 		} else {
 			if !queueEmpty {
 				if err == nil {
@@ -2346,8 +3294,14 @@ func (m *UserShareManager) AsyncSave() (exit bool) {
 				}
 			}
 		}
+		if atomic.LoadInt32(&m.dumped) == 1 {
+			// 队列已经由Collect协程导出, Collect不再接收syncEnd
+			exit = true
+			return
+		}
 		m.DataToFailQueue()
 		m.lastWriteBackTime = time.Duration(time.Now().UnixNano() - bTime)
+		m.updateHealth(err)
 		m.syncEnd <- true
 	}()
 
@@ -2366,6 +3320,7 @@ func (m *UserShareManager) AsyncSave() (exit bool) {
 
 	log.Println("begin incrementalSave", bTime)
 
+	m.queueMutex.Lock()
 	if len(m.FailQueue) > 0 {
 		tmpQueue := make([]*UserShareSync, len(m.FailQueue)+len(*m.syncQueue))
 		copy(tmpQueue, m.FailQueue)
@@ -2379,6 +3334,7 @@ func (m *UserShareManager) AsyncSave() (exit bool) {
 		m.syncQueue = &otherQueue
 		m.InsertQueue = insertQueue
 	}
+	m.queueMutex.Unlock()
 
 	multiInsertFn := func() bool {
 		var err error
@@ -2387,14 +3343,16 @@ func (m *UserShareManager) AsyncSave() (exit bool) {
 				_ = session.Rollback()
 			} else {
 				if err == nil {
+					m.queueMutex.Lock()
 					m.InsertQueue = m.InsertQueue[0:0]
+					m.queueMutex.Unlock()
 				} else {
 					_ = session.Rollback()
 				}
 			}
 		}()
 
-		if len(m.InsertQueue) <= 0 {
+		if len(m.InsertQueue) <= 0 || atomic.LoadInt32(&m.dumped) == 1 {
 			return true
 		}
 		err = session.Begin()
@@ -2402,71 +3360,109 @@ func (m *UserShareManager) AsyncSave() (exit bool) {
 			return false
 		}
 
+		// 分表或分片时按照分片(数据库和表名)分组批量插入, 每个数据库一个事务, 全部插入成功后提交
+		engineList := []*xorm.Engine{m.engine}
+		sessionMap := map[*xorm.Engine]*xorm.Session{m.engine: session}
+		defer func() {
+			// 未提交的事务关闭时回滚
+			for _, engine := range engineList[1:] {
+				_ = sessionMap[engine].Close()
+			}
+		}()
+		var shardList []Shard
+		shardQueueMap := map[Shard][]*UserShareSync{}
+		for _, persistSync := range m.InsertQueue {
+			shard := m.insertShard(UserShareUid{Uid: persistSync.Data.Uid}, persistSync.Table)
+			if _, ok := shardQueueMap[shard]; !ok {
+				shardList = append(shardList, shard)
+			}
+			shardQueueMap[shard] = append(shardQueueMap[shard], persistSync)
+		}
+
 		const num = 100
 		var insertArray [num]*model.UserShare
-		length := len(m.InsertQueue)
-		quotient := length / num
-		remainder := length % num
-		for i := 0; i < quotient; i++ {
-			//fmt.Println("queue->(", i*num, "-", (i+1)*num, "): ", m.InsertQueue[i*num:(i+1)*num])
-			for j := 0; j < num; j++ {
-				insertArray[j] = m.InsertQueue[i*num+j].Data
+		for _, shard := range shardList {
+			shardSession, ok := sessionMap[shard.Engine]
+			if !ok {
+				shardSession = shard.Engine.NewSession()
+				engineList = append(engineList, shard.Engine)
+				sessionMap[shard.Engine] = shardSession
+				err = shardSession.Begin()
+				if err != nil {
+					return false
+				}
 			}
+			insertQueue := shardQueueMap[shard]
+			length := len(insertQueue)
+			quotient := length / num
+			remainder := length % num
+			for i := 0; i < quotient; i++ {
+				for j := 0; j < num; j++ {
+					insertArray[j] = insertQueue[i*num+j].Data
+				}
 
-			_, err = session.InsertMulti(insertArray[:])
+				_, err = m.tableSession(shardSession, shard.Table).InsertMulti(insertArray[:])
 
-			if err != nil {
-				log.Println("InsertMulti error ", err)
-				return false
-			}
-		}
-		if remainder != 0 {
-			//fmt.Println("queue->(", quotient*num, "-", length, "): ", m.InsertQueue[quotient*num:length])
-
-			insertArray = [num]*model.UserShare{}
-			for j := 0; j < remainder; j++ {
-				insertArray[j] = m.InsertQueue[quotient*num+j].Data
+				if err != nil {
+					log.Println("InsertMulti error ", err)
+					return false
+				}
 			}
+			if remainder != 0 {
+				insertArray = [num]*model.UserShare{}
+				for j := 0; j < remainder; j++ {
+					insertArray[j] = insertQueue[quotient*num+j].Data
+				}
 
-			_, err = session.InsertMulti(insertArray[:remainder])
+				_, err = m.tableSession(shardSession, shard.Table).InsertMulti(insertArray[:remainder])
 
+				if err != nil {
+					log.Println("InsertMulti error ", err)
+					return false
+				}
+			}
+		}
+		// 部分数据库提交后失败时, 单条插入改为更新已经提交的数据
+		for _, engine := range engineList {
+			err = sessionMap[engine].Commit()
 			if err != nil {
-				log.Println("InsertMulti error ", err)
 				return false
 			}
 		}
-		err = session.Commit()
-		if err != nil {
-			return false
+		for _, persistSync := range m.InsertQueue {
+			m.markWrite(UserShareUid{Uid: persistSync.Data.Uid})
 		}
 		return true
 	}
 
 	multiInsertSuccess := multiInsertFn()
 
-	// 批量插入失败, 改为单条插入
+	// 批量插入失败, 改为单条插入, 已经写入数据库的改为更新
+	// 每条写入成功后移出队列, 退出超时导出的队列不包含已经写入的数据
 	if !multiInsertSuccess {
-		for idx, persistSync := range m.InsertQueue {
-			err = m.SaveDB(session, persistSync)
+		for len(m.InsertQueue) > 0 && atomic.LoadInt32(&m.dumped) == 0 {
+			err = m.replayDB(session, m.InsertQueue[0])
 			if err != nil {
-				m.InsertQueue = m.InsertQueue[idx:]
 				m.SaveFile()
 				return
 			}
+			m.queueMutex.Lock()
+			m.InsertQueue = m.InsertQueue[1:]
+			m.queueMutex.Unlock()
 		}
-		m.InsertQueue = m.InsertQueue[0:0]
 	}
 
-	for i := 0; i < len(*m.syncQueue); i++ {
-		persistSync = (*m.syncQueue)[i]
+	for len(*m.syncQueue) > 0 && atomic.LoadInt32(&m.dumped) == 0 {
+		persistSync = (*m.syncQueue)[0]
 		err = m.SaveDB(session, persistSync)
 		if err != nil {
-			*m.syncQueue = (*m.syncQueue)[i:]
 			m.SaveFile()
 			return
 		}
+		m.queueMutex.Lock()
+		*m.syncQueue = (*m.syncQueue)[1:]
+		m.queueMutex.Unlock()
 	}
-	*m.syncQueue = (*m.syncQueue)[0:0]
 	m.RemoveFile()
 	return
 }
@@ -2477,18 +3473,24 @@ func (m *UserShareManager) Collect() {
 	var ok bool
 	// 0:normal  1:exit begin, save sync  2:save cache  3:save done
 	var state int8
+	defer atomic.StoreInt32(&m.collecting, 0)
 	go m.Save()
 	m.syncBegin <- true
 	for {
 		select {
 		case persistSync, ok = <-m.syncChan:
+			m.queueMutex.Lock()
 			if ok {
 				*m.cacheQueue = append(*m.cacheQueue, persistSync)
 			}
+			m.queueMutex.Unlock()
 		case _, ok = <-m.syncEnd:
 			if ok {
 				m.CheckOverload()
+				m.pruneWrite()
+				m.queueMutex.Lock()
 				m.cacheQueue, m.syncQueue = m.syncQueue, m.cacheQueue
+				m.queueMutex.Unlock()
 				switch state {
 				case EUserShareCollectStateNormal:
 					//go m.AsyncSave()
@@ -2503,11 +3505,28 @@ func (m *UserShareManager) Collect() {
 					state = EUserShareCollectStateSaveDone
 				case EUserShareCollectStateSaveDone:
 					m.syncBegin <- false
-					<-m.syncEnd
-					m.exitEnd <- true
+					select {
+					case <-m.syncEnd:
+					case done := <-m.dumpChan:
+						m.dumpQueue()
+						close(done)
+						m.releaseSave()
+						return
+					}
+					select {
+					case m.exitEnd <- true:
+					case done := <-m.dumpChan:
+						// 写回已经完成, 队列为空
+						close(done)
+					}
 					return
 				}
 			}
+		case done := <-m.dumpChan:
+			m.dumpQueue()
+			close(done)
+			m.releaseSave()
+			return
 		case _, ok = <-m.exitBegin:
 			if ok {
 				state = EUserShareCollectStateSaveSync
@@ -2518,6 +3537,71 @@ func (m *UserShareManager) Collect() {
 	}
 }
 
+// ExitContext 管理类退出, ctx结束时由Collect协程将剩余队列写入bomb文件, 管理类标记为非法停止
+func (m *UserShareManager) ExitContext(ctx context.Context) (err error) {
+	if atomic.LoadInt32(&m.managerState) != EUserShareManagerStateNormal {
+		return
+	}
+
+	select {
+	case m.exitBegin <- true:
+	case <-ctx.Done():
+		m.requestDump()
+		atomic.StoreInt32(&m.managerState, EUserShareManagerStatePanic)
+		return ctx.Err()
+	}
+	select {
+	case <-m.exitEnd:
+		atomic.StoreInt32(&m.managerState, EUserShareManagerStateIdle)
+		return
+	case <-ctx.Done():
+		m.requestDump()
+		atomic.StoreInt32(&m.managerState, EUserShareManagerStatePanic)
+		return ctx.Err()
+	}
+}
+
+// SyncContext 同步表结构, ctx结束时返回, 同步不会中断, 仍在后台继续
+func (m *UserShareManager) SyncContext(ctx context.Context) (err error) {
+	return persistCore.WaitContext(ctx, func() error {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		return m.Sync(&wg)
+	})
+}
+
+// releaseSave 导出队列后等待写回协程结束, 写回协程可能阻塞在syncBegin或syncEnd上
+func (m *UserShareManager) releaseSave() {
+	for atomic.LoadInt32(&m.saving) == 1 {
+		select {
+		case <-m.syncEnd:
+		case m.syncBegin <- false:
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+}
+
+// requestDump 请求Collect协程导出队列并等待完成
+func (m *UserShareManager) requestDump() {
+	done := make(chan struct{})
+	m.dumpChan <- done
+	<-done
+}
+
+// RunContext 运行并导入上次失败数据, ctx结束时返回, 导入不会中断, 仍在后台继续
+func (m *UserShareManager) RunContext(ctx context.Context) (err error) {
+	return persistCore.WaitContext(ctx, m.Run)
+}
+
+// SyncDataContext 全部内存数据写入数据库, ctx结束时返回, 写入不会中断, 仍在后台继续
+func (m *UserShareManager) SyncDataContext(ctx context.Context, sentryDebug bool) (err error) {
+	return persistCore.WaitContext(ctx, func() error {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		return m.SyncData(&wg, sentryDebug)
+	})
+}
+
 // Exit 管理类退出
 func (m *UserShareManager) Exit(wg *sync.WaitGroup) {
 	defer wg.Done()
@@ -2544,24 +3628,401 @@ func (m *UserShareManager) Sync(wg *sync.WaitGroup) (err error) {
 // Segmentation 检查是否需要换表 如果需要换表 则根据时间 和切换间隔计算是否需要换表 否则为不处理
 func (m *UserShareManager) Segmentation(wg *sync.WaitGroup) (err error) {
 	defer wg.Done()
+
+	m.segmentMutex.Lock()
+	defer m.segmentMutex.Unlock()
+
+	config := m.segmentation
+	if config.Mode == persistCore.ESegmentationModeNone {
+		return
+	}
+	current := m.TableName()
+	base := m.engine.TableName(gUserShareNil)
+	seq := m.segmentSeq
+	var next string
+	switch config.Mode {
+	case persistCore.ESegmentationModeRows:
+		var count int64
+		count, err = m.engine.Table(current).Count()
+		if err != nil || config.MaxRows <= 0 || count < config.MaxRows {
+			return
+		}
+		seq++
+		next = config.TableName(base, time.Now(), seq)
+	default:
+		next = config.TableName(base, time.Now(), seq)
+	}
+	if next == current {
+		return
+	}
+	err = m.engine.Table(next).Sync2(gUserShareNil)
+	if err != nil {
+		return
+	}
+	// 已经入队的记录带有表名, 切换后仍然写入原来的表
+	m.prevTableName.Store(current)
+	m.tableName.Store(next)
+	m.segmentSeq = seq
+	log.Println("UserShareManager Segmentation", current, "->", next)
+	return
+}
+
+// SetSegmentation 设置分表配置, 创建当前分表. 必须在Run和导入数据之前调用
+func (m *UserShareManager) SetSegmentation(config persistCore.SegmentationConfig) (err error) {
+	m.segmentMutex.Lock()
+	defer m.segmentMutex.Unlock()
+
+	if m.shardRouter != nil && config.Mode != persistCore.ESegmentationModeNone {
+		return errors.New("UserShare: segmentation can not be used with shard router")
+	}
+	if config.Mode == persistCore.ESegmentationModeNone {
+		m.segmentation = config
+		m.segmentSeq = 0
+		m.tableName.Store("")
+		m.prevTableName.Store("")
+		return
+	}
+
+	now := time.Now()
+	base := m.engine.TableName(gUserShareNil)
+	var current, prev string
+	seq := 0
+	switch config.Mode {
+	case persistCore.ESegmentationModeRows:
+		// 找到最后一张已存在的表
+		for {
+			var exist bool
+			exist, err = m.engine.IsTableExist(config.TableName(base, now, seq+1))
+			if err != nil {
+				return
+			}
+			if !exist {
+				break
+			}
+			seq++
+		}
+		current = config.TableName(base, now, seq)
+		if seq > 0 {
+			prev = config.TableName(base, now, seq-1)
+		}
+	default:
+		current = config.TableName(base, now, seq)
+		prev = config.TableName(base, config.PrevTime(now), seq)
+	}
+	err = m.engine.Table(current).Sync2(gUserShareNil)
+	if err != nil {
+		return
+	}
+	m.segmentation = config
+	m.segmentSeq = seq
+	m.tableName.Store(current)
+	m.prevTableName.Store(prev)
+	return
+}
+
+// TableName 当前写入表名, 不分表为空
+func (m *UserShareManager) TableName() string {
+	table, _ := m.tableName.Load().(string)
+	return table
+}
+
+// tableSession 分表时指定表名
+func (m *UserShareManager) tableSession(session *xorm.Session, table string) *xorm.Session {
+	if table != "" {
+		return session.Table(table)
+	}
+	return session
+}
+
+// objectLock 对象所在分段的锁
+func (m *UserShareManager) objectLock(Uid int64) *sync.RWMutex {
+	return &m.objectMutex[uint64(Uid)%uint64(len(m.objectMutex))]
+}
+
+// segmentTable 对象所在的分表, 没有记录时为当前表
+func (m *UserShareManager) segmentTable(pk UserShareUid) string {
+	if table, ok := m.tableMap.Load(pk); ok {
+		return table.(string)
+	}
+	return m.TableName()
+}
+
+// insertTable 新建对象写入当前表
+func (m *UserShareManager) insertTable(pk UserShareUid) string {
+	table := m.TableName()
+	if table != "" {
+		m.tableMap.Store(pk, table)
+	}
+	return table
+}
+
+// SetReplica 设置从库, 全导入读从库, 按key导入按照policy选择. engine为nil时不使用从库. 必须在Run和导入数据之前调用
+func (m *UserShareManager) SetReplica(engine *xorm.Engine, policy persistCore.ReplicaPolicy) {
+	m.replica = engine
+	m.replicaPolicy = policy
+}
+
+// readEngine 导入使用的数据库, pk为nil表示全导入
+func (m *UserShareManager) readEngine(pk *UserShareUid) *xorm.Engine {
+	if m.replica == nil {
+		return m.engine
+	}
+	if pk == nil {
+		// 重放bomb后从库可能还没有同步, MaxStaleness内全导入读主库
+		if time.Since(time.Unix(0, atomic.LoadInt64(&m.replayTime))) <= m.replicaPolicy.MaxStaleness {
+			return m.engine
+		}
+		return m.replica
+	}
+	switch m.replicaPolicy.Mode {
+	case persistCore.EReplicaModeReplica:
+		return m.replica
+	case persistCore.EReplicaModeStaleness:
+		if value, ok := m.lastWriteMap.Load(*pk); ok {
+			if time.Since(value.(time.Time)) <= m.replicaPolicy.MaxStaleness {
+				return m.engine
+			}
+			m.lastWriteMap.Delete(*pk)
+		}
+		return m.replica
+	default:
+		return m.engine
+	}
+}
+
+// markWrite 记录写回时间, 用于从库延迟判断
+func (m *UserShareManager) markWrite(pk UserShareUid) {
+	if m.replica == nil || m.replicaPolicy.Mode != persistCore.EReplicaModeStaleness {
+		return
+	}
+	m.lastWriteMap.Store(pk, time.Now())
+}
+
+// pruneWrite 清理超过MaxStaleness的写回时间, Collect协程调用, 每隔MaxStaleness清理一次
+func (m *UserShareManager) pruneWrite() {
+	if m.replica == nil || m.replicaPolicy.Mode != persistCore.EReplicaModeStaleness || time.Since(m.pruneTime) < m.replicaPolicy.MaxStaleness {
+		return
+	}
+	m.pruneTime = time.Now()
+	m.lastWriteMap.Range(func(pk, value any) bool {
+		if time.Since(value.(time.Time)) > m.replicaPolicy.MaxStaleness {
+			// 清理期间重新写回的不删除
+			m.lastWriteMap.CompareAndDelete(pk, value)
+		}
+		return true
+	})
+}
+
+// SetShardRouter 设置分片路由并创建所有分片表, 不能和分表同时使用. 必须在Run和导入数据之前调用
+func (m *UserShareManager) SetShardRouter(router ShardRouter[UserShareUid]) (err error) {
+	m.segmentMutex.Lock()
+	defer m.segmentMutex.Unlock()
+
+	if router != nil && m.segmentation.Mode != persistCore.ESegmentationModeNone {
+		return errors.New("UserShare: shard router can not be used with segmentation")
+	}
+	if router != nil {
+		for _, shard := range router.Shards() {
+			err = shard.Engine.Table(shard.Table).Sync2(gUserShareNil)
+			if err != nil {
+				return
+			}
+		}
+	}
+	m.shardRouter = router
 	return
 }
 
-// compareAndUpdate 比较数据库，不相同则更新
-func (m *UserShareManager) compareAndUpdate(session *xorm.Session, cls *model.UserShare, sentryDebug bool) (err error) {
-	update := func(session *xorm.Session, cls *model.UserShare, memData, dbData string) {
-		log.Println("SyncData error. missing mark Mem. [sql error UserShare]", memData)
-		log.Println("SyncData error. missing mark  Db. [sql error UserShare]", dbData)
-		_, err = session.ID(core.NewPK(cls.Uid)).AllCols().Update(cls)
+// insertShard 批量插入分组, 分片时为主键所在分片, 否则为主库和分表表名
+func (m *UserShareManager) insertShard(pk UserShareUid, table string) Shard {
+	if m.shardRouter != nil {
+		return m.shardRouter.Route(pk)
+	}
+	return Shard{Engine: m.engine, Table: table}
+}
+
+// routeSession 分片时按主键路由到数据库和表, 其他数据库使用自动关闭的session, 不在同一事务中
+func (m *UserShareManager) routeSession(session *xorm.Session, pk UserShareUid, table string) *xorm.Session {
+	if m.shardRouter != nil {
+		shard := m.shardRouter.Route(pk)
+		if shard.Engine != m.engine {
+			return shard.Engine.Table(shard.Table)
+		}
+		return session.Table(shard.Table)
+	}
+	return m.tableSession(session, table)
+}
+
+// find 导入数据. 分片时pk为nil查询所有分片, 否则只查询主键所在分片, 分片时不使用从库
+func (m *UserShareManager) find(ctx context.Context, engine *xorm.Engine, cond *model.UserShare, pk *UserShareUid) (rows []*model.UserShare, err error) {
+	if m.shardRouter == nil {
+		return m.findSegment(ctx, engine, cond)
+	}
+	shards := m.shardRouter.Shards()
+	if pk != nil {
+		shards = []Shard{m.shardRouter.Route(*pk)}
+	}
+	rows = make([]*model.UserShare, 0)
+	for _, shard := range shards {
+		shardRows := make([]*model.UserShare, 0)
+		err = shard.Engine.Context(ctx).Table(shard.Table).Find(&shardRows, cond)
 		if err != nil {
-			log.Println("SyncData update error.", err, "[sql error UserShare]", m.PersistSyncToString(&UserShareSync{
-				Data:   cls,
-				Op:     EUserShareOpUpdate,
-				BitSet: m.bitSetAll,
-			}))
 			return
 		}
+		rows = append(rows, shardRows...)
+	}
+	return
+}
+
+// findSegment 分表时依次查询当前和上一个分表, 同一主键以当前分表为准
+func (m *UserShareManager) findSegment(ctx context.Context, engine *xorm.Engine, cond *model.UserShare) (rows []*model.UserShare, err error) {
+	current := m.TableName()
+	if current == "" {
+		rows = make([]*model.UserShare, 0)
+		err = engine.Context(ctx).Find(&rows, cond)
+		return
 	}
+	prev, _ := m.prevTableName.Load().(string)
+
+	pkMap := map[UserShareUid]bool{}
+	for _, table := range []string{current, prev} {
+		if table == "" {
+			continue
+		}
+		var exist bool
+		exist, err = engine.IsTableExist(table)
+		if err != nil {
+			return
+		}
+		if !exist {
+			continue
+		}
+		tableRows := make([]*model.UserShare, 0)
+		err = engine.Context(ctx).Table(table).Find(&tableRows, cond)
+		if err != nil {
+			return
+		}
+		for _, row := range tableRows {
+			pk := UserShareUid{Uid: row.Uid}
+			if pkMap[pk] {
+				continue
+			}
+			pkMap[pk] = true
+			m.tableMap.Store(pk, table)
+			rows = append(rows, row)
+		}
+	}
+	return
+}
+
+// DiffUserShare 按字段比较两个对象, 返回不一致的字段及其位图
+func (m *UserShareManager) DiffUserShare(mem, db *model.UserShare) (diff []persistCore.FieldDiff, bitSet UserShareBitSet) {
+	//Uid	int64
+	if mem.Uid != db.Uid {
+		diff = append(diff, persistCore.FieldDiff{Field: "Uid", Mem: mem.Uid, DB: db.Uid})
+		bitSet.Set(EUserShareFieldIndexUid)
+	}
+
+	//UserName	string
+	if mem.UserName != db.UserName {
+		diff = append(diff, persistCore.FieldDiff{Field: "UserName", Mem: mem.UserName, DB: db.UserName})
+		bitSet.Set(EUserShareFieldIndexUserName)
+	}
+
+	//NickName	string
+	if mem.NickName != db.NickName {
+		diff = append(diff, persistCore.FieldDiff{Field: "NickName", Mem: mem.NickName, DB: db.NickName})
+		bitSet.Set(EUserShareFieldIndexNickName)
+	}
+
+	//Password	string
+	if mem.Password != db.Password {
+		diff = append(diff, persistCore.FieldDiff{Field: "Password", Mem: mem.Password, DB: db.Password})
+		bitSet.Set(EUserShareFieldIndexPassword)
+	}
+
+	//Mobile	string
+	if mem.Mobile != db.Mobile {
+		diff = append(diff, persistCore.FieldDiff{Field: "Mobile", Mem: mem.Mobile, DB: db.Mobile})
+		bitSet.Set(EUserShareFieldIndexMobile)
+	}
+
+	//Gender	int32
+	if mem.Gender != db.Gender {
+		diff = append(diff, persistCore.FieldDiff{Field: "Gender", Mem: mem.Gender, DB: db.Gender})
+		bitSet.Set(EUserShareFieldIndexGender)
+	}
+
+	//Email	string
+	if mem.Email != db.Email {
+		diff = append(diff, persistCore.FieldDiff{Field: "Email", Mem: mem.Email, DB: db.Email})
+		bitSet.Set(EUserShareFieldIndexEmail)
+	}
+
+	//Avatar	string
+	if mem.Avatar != db.Avatar {
+		diff = append(diff, persistCore.FieldDiff{Field: "Avatar", Mem: mem.Avatar, DB: db.Avatar})
+		bitSet.Set(EUserShareFieldIndexAvatar)
+	}
+
+	//Status	int64
+	if mem.Status != db.Status {
+		diff = append(diff, persistCore.FieldDiff{Field: "Status", Mem: mem.Status, DB: db.Status})
+		bitSet.Set(EUserShareFieldIndexStatus)
+	}
+
+	//DeptId	int64
+	if mem.DeptId != db.DeptId {
+		diff = append(diff, persistCore.FieldDiff{Field: "DeptId", Mem: mem.DeptId, DB: db.DeptId})
+		bitSet.Set(EUserShareFieldIndexDeptId)
+	}
+
+	//RoleId	int64
+	if mem.RoleId != db.RoleId {
+		diff = append(diff, persistCore.FieldDiff{Field: "RoleId", Mem: mem.RoleId, DB: db.RoleId})
+		bitSet.Set(EUserShareFieldIndexRoleId)
+	}
+
+	//Token	string
+	if mem.Token != db.Token {
+		diff = append(diff, persistCore.FieldDiff{Field: "Token", Mem: mem.Token, DB: db.Token})
+		bitSet.Set(EUserShareFieldIndexToken)
+	}
+
+	//Remark	string
+	if mem.Remark != db.Remark {
+		diff = append(diff, persistCore.FieldDiff{Field: "Remark", Mem: mem.Remark, DB: db.Remark})
+		bitSet.Set(EUserShareFieldIndexRemark)
+	}
+
+	//CreateBy	int64
+	if mem.CreateBy != db.CreateBy {
+		diff = append(diff, persistCore.FieldDiff{Field: "CreateBy", Mem: mem.CreateBy, DB: db.CreateBy})
+		bitSet.Set(EUserShareFieldIndexCreateBy)
+	}
+
+	//UpdateBy	int64
+	if mem.UpdateBy != db.UpdateBy {
+		diff = append(diff, persistCore.FieldDiff{Field: "UpdateBy", Mem: mem.UpdateBy, DB: db.UpdateBy})
+		bitSet.Set(EUserShareFieldIndexUpdateBy)
+	}
+
+	//LastLoginTime	int64
+	if mem.LastLoginTime != db.LastLoginTime {
+		diff = append(diff, persistCore.FieldDiff{Field: "LastLoginTime", Mem: mem.LastLoginTime, DB: db.LastLoginTime})
+		bitSet.Set(EUserShareFieldIndexLastLoginTime)
+	}
+
+	//LastLoginIp	string
+	if mem.LastLoginIp != db.LastLoginIp {
+		diff = append(diff, persistCore.FieldDiff{Field: "LastLoginIp", Mem: mem.LastLoginIp, DB: db.LastLoginIp})
+		bitSet.Set(EUserShareFieldIndexLastLoginIp)
+	}
+	return
+}
+
+// compare 按字段比较内存对象和数据库, 数据库不存在时dbCls为nil
+func (m *UserShareManager) compare(session *xorm.Session, memCls *model.UserShare) (dbCls *model.UserShare, diff []persistCore.FieldDiff, bitSet UserShareBitSet, err error) {
 	resetTimeNSec := func(clsMem, clsDb *model.UserShare) {
 		defer func() {
 			if r := recover(); r != nil {
@@ -2588,60 +4049,119 @@ func (m *UserShareManager) compareAndUpdate(session *xorm.Session, cls *model.Us
 		}
 	}
 
-	dbCls := &model.UserShare{
+	dbCls = &model.UserShare{
 
-		Uid: cls.Uid,
+		Uid: memCls.Uid,
 	}
 	var has bool
-	has, err = session.Get(dbCls)
+	has, err = m.routeSession(session, UserShareUid{Uid: memCls.Uid}, m.segmentTable(UserShareUid{Uid: memCls.Uid})).Get(dbCls)
 	if err != nil || !has {
-		log.Println("SyncData query error.", err, "[sql error UserShare]", m.PersistSyncToString(&UserShareSync{
-			Data:   cls,
+		// 数据库中不存在时dbCls为nil, err为nil
+		log.Println("SyncData query error.", err, "has", has, "[sql error UserShare]", m.PersistSyncToString(&UserShareSync{
+			Data:   memCls,
 			Op:     0,
 			BitSet: m.bitSetAll,
 		}))
+		dbCls = nil
+		return
+	}
+	resetTimeNSec(memCls, dbCls)
+	diff, bitSet = m.DiffUserShare(memCls, dbCls)
+	return
+}
+
+// compareAndUpdate 比较数据库，不相同则只更新不一致的列, 返回差异报告(一致时为nil)
+func (m *UserShareManager) compareAndUpdate(session *xorm.Session, cls *model.UserShare, sentryDebug bool) (report *persistCore.DiffReport, err error) {
+	memCls := m.getUserShareByUid(cls.Uid)
+	if memCls == nil {
+		return
+	}
+	dbCls, diff, bitSet, err := m.compare(session, memCls)
+	if err != nil {
+		return
+	}
+	if dbCls == nil {
+		// 数据库中不存在, 可能还在写回队列中, 只报告不插入
+		report = &persistCore.DiffReport{Persist: "UserShare", Pk: UserShareUid{Uid: memCls.Uid}, Missing: true}
+		if sentryDebug {
+			memClsJson, _ := json.Marshal(&UserShareSync{
+				Data:   memCls,
+				Op:     0,
+				BitSet: m.bitSetAll,
+			})
+			persistCore.Report(&persistCore.ReportEvent{
+				Kind:    persistCore.EReportKindMismatch,
+				Persist: "UserShare",
+				Pk:      report.Pk,
+				MemJson: string(memClsJson),
+			})
+		}
+		return
+	}
+	if len(diff) == 0 {
 		return
 	}
-	memCls := m.GetUserShareByUid(cls.Uid)
-	if memCls != nil {
-		resetTimeNSec(memCls, dbCls)
-		memData := m.PersistSyncToString(&UserShareSync{
+	report = &persistCore.DiffReport{Persist: "UserShare", Pk: UserShareUid{Uid: memCls.Uid}, Fields: diff}
+
+	// 数据库内存不一致
+	memData := m.PersistSyncToString(&UserShareSync{Data: memCls, Op: 0, BitSet: bitSet})
+	dbData := m.PersistSyncToString(&UserShareSync{Data: dbCls, Op: 0, BitSet: bitSet})
+	log.Println("SyncData error. missing mark Mem. [sql error UserShare]", report.FieldNames(), memData)
+	log.Println("SyncData error. missing mark  Db. [sql error UserShare]", report.FieldNames(), dbData)
+	if sentryDebug {
+		memClsJson, _ := json.Marshal(&UserShareSync{
 			Data:   memCls,
 			Op:     0,
 			BitSet: m.bitSetAll,
 		})
-		dbData := m.PersistSyncToString(&UserShareSync{
+		dbClsJson, _ := json.Marshal(&UserShareSync{
 			Data:   dbCls,
 			Op:     0,
 			BitSet: m.bitSetAll,
 		})
-		// 数据库内存不一致
-		if strings.Compare(memData, dbData) != 0 {
-			if sentryDebug {
-				func() {
-					defer func() {
-						memClsJson, _ := json.Marshal(&UserShareSync{
-							Data:   memCls,
-							Op:     0,
-							BitSet: m.bitSetAll,
-						})
-						dbClsJson, _ := json.Marshal(&UserShareSync{
-							Data:   dbCls,
-							Op:     0,
-							BitSet: m.bitSetAll,
-						})
-						sentry.WithScope(func(scope *sentry.Scope) {
-							tag := "CompareError" + "UserShare"
-							scope.SetTag(tag, "UserShare")
-							scope.SetTag("transaction", "UserShare")
-							scope.SetExtra("memClsJson", string(memClsJson))
-							scope.SetExtra("dbClsJson", string(dbClsJson))
-							sentry.CaptureMessage(tag)
-						})
-					}()
-				}()
-			}
-			update(session, memCls, memData, dbData)
+		persistCore.Report(&persistCore.ReportEvent{
+			Kind:    persistCore.EReportKindMismatch,
+			Persist: "UserShare",
+			Pk:      report.Pk,
+			MemJson: string(memClsJson),
+			DBJson:  string(dbClsJson),
+			Fields:  diff,
+		})
+	}
+
+	var nameList []string
+	for idx, name := range UserShareDBFiledMap {
+		if bitSet.Get(UserShareFieldIndex(idx)) {
+			nameList = append(nameList, name)
+		}
+	}
+	_, err = m.routeSession(session, UserShareUid{Uid: memCls.Uid}, m.segmentTable(UserShareUid{Uid: memCls.Uid})).ID(core.NewPK(memCls.Uid)).Cols(nameList...).Update(memCls)
+	if err != nil {
+		log.Println("SyncData update error.", err, "[sql error UserShare]", m.PersistSyncToString(&UserShareSync{
+			Data:   memCls,
+			Op:     EUserShareOpUpdate,
+			BitSet: bitSet,
+		}))
+	}
+	return
+}
+
+// DiffData 全部内存数据和数据库按字段比较, 只返回差异不更新, 用于定位漏掉MarkUpdate的代码
+func (m *UserShareManager) DiffData() (reportList []*persistCore.DiffReport, err error) {
+	session := m.engine.NewSession()
+	defer session.Close()
+
+	for _, cls := range m.GetAll() {
+		memCls := m.acquireDeepCopyObject(cls)
+		dbCls, diff, _, compareErr := m.compare(session, memCls)
+		if compareErr != nil {
+			err = compareErr
+			continue
+		}
+		if dbCls == nil {
+			reportList = append(reportList, &persistCore.DiffReport{Persist: "UserShare", Pk: UserShareUid{Uid: memCls.Uid}, Missing: true})
+		} else if len(diff) > 0 {
+			reportList = append(reportList, &persistCore.DiffReport{Persist: "UserShare", Pk: UserShareUid{Uid: memCls.Uid}, Fields: diff})
 		}
 	}
 	return
@@ -2658,25 +4178,23 @@ func (m *UserShareManager) SyncData(wg *sync.WaitGroup, sentryDebug bool) (err e
 		func() {
 			defer func() {
 				for _, cls := range m.GetAll() {
-					updateErr := m.compareAndUpdate(session, cls, sentryDebug)
+					_, updateErr := m.compareAndUpdate(session, cls, sentryDebug)
 					if updateErr != nil {
 						err = updateErr
 					}
 				}
 				if err != nil {
-					sentry.WithScope(func(scope *sentry.Scope) {
-						tagtag := "SyncDataError" + "UserShare"
-						scope.SetTag("SyncDataError", "UserShare")
-						scope.SetTag("transaction", "UserShare")
-						scope.SetExtra(err.Error(), 1)
-						sentry.CaptureMessage(tagtag)
+					persistCore.Report(&persistCore.ReportEvent{
+						Kind:    persistCore.EReportKindError,
+						Persist: "UserShare",
+						Err:     err,
 					})
 				}
 			}()
 		}()
 	} else {
 		for _, cls := range m.GetAll() {
-			updateErr := m.compareAndUpdate(session, cls, sentryDebug)
+			_, updateErr := m.compareAndUpdate(session, cls, sentryDebug)
 			if updateErr != nil {
 				err = updateErr
 			}
@@ -2687,7 +4205,7 @@ func (m *UserShareManager) SyncData(wg *sync.WaitGroup, sentryDebug bool) (err e
 }
 
 // SyncUserData 用户内存和数据库数据比较并更新, 不允许并发， 用于数据导出时，补救没有标记写回数据(只处理未标记数据,New Delete不存在漏写)
-// sentryDebug debug模式下启用sentry
+// sentryDebug debug模式下启用persistCore.Reporter上报
 func (m *UserShareManager) SyncUserData(Uid int64, sentryDebug bool) (err error) {
 	session := m.engine.NewSession()
 	defer session.Close()
@@ -2703,7 +4221,7 @@ func (m *UserShareManager) SyncUserData(Uid int64, sentryDebug bool) (err error)
 			}
 		}()
 
-		cls := m.GetUserShareByUid(Uid)
+		cls := m.getUserShareByUid(Uid)
 		if cls != nil {
 			clsList = append(clsList, m.acquireDeepCopyObject(cls))
 		}
@@ -2715,17 +4233,16 @@ func (m *UserShareManager) SyncUserData(Uid int64, sentryDebug bool) (err error)
 	}
 
 	for _, cls := range clsList {
-		err = m.compareAndUpdate(session, cls, sentryDebug)
+		_, err = m.compareAndUpdate(session, cls, sentryDebug)
 		if sentryDebug {
 			func() {
 				defer func() {
 					if err != nil {
-						sentry.WithScope(func(scope *sentry.Scope) {
-							tagtag := "SyncDataError" + "UserShare"
-							scope.SetTag("SyncDataError", "UserShare")
-							scope.SetTag("transaction", "UserShare")
-							scope.SetExtra(err.Error(), 1)
-							sentry.CaptureMessage(tagtag)
+						persistCore.Report(&persistCore.ReportEvent{
+							Kind:    persistCore.EReportKindError,
+							Persist: "UserShare",
+							Pk:      UserShareUid{Uid: Uid},
+							Err:     err,
 						})
 					}
 				}()
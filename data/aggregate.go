@@ -0,0 +1,304 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// AggregateValue 分组聚合结果, 没有值字段时只有Count
+type AggregateValue struct {
+	Count int64
+	Sum   float64
+	Min   float64
+	Max   float64
+}
+
+// aggregateGroup 一个分组的聚合, Count包含值为NaN的对象
+type aggregateGroup struct {
+	value  AggregateValue
+	counts map[float64]int64 // 值 -> 数量, 删除最小或最大值后重新计算
+}
+
+// aggregateEntry 对象加入时的分组和值, 对象修改后按旧分组删除
+type aggregateEntry struct {
+	key     any
+	value   float64
+	noValue bool // 值为NaN, 只计数
+}
+
+// aggregate 注册的分组聚合
+type aggregate[T any] struct {
+	fields []reflect.StructField // 分组字段, 计算分组的聚合为空
+	group  func(obj *T) any
+	value  func(obj *T) float64 // 为空只计数
+
+	mutex   sync.RWMutex
+	groups  map[any]*aggregateGroup
+	entries map[*T]aggregateEntry
+}
+
+func (a *aggregate[T]) insert(obj *T) {
+	entry := aggregateEntry{key: a.group(obj)}
+	if a.value != nil {
+		entry.value = a.value(obj)
+		if math.IsNaN(entry.value) {
+			entry.value, entry.noValue = 0, true
+		}
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if old, ok := a.entries[obj]; ok {
+		if old == entry {
+			return
+		}
+		a.remove(obj, old)
+	}
+	a.entries[obj] = entry
+	group, ok := a.groups[entry.key]
+	if !ok {
+		group = &aggregateGroup{}
+		if a.value != nil {
+			group.counts = make(map[float64]int64)
+		}
+		a.groups[entry.key] = group
+	}
+	group.value.Count++
+	if a.value == nil || entry.noValue {
+		return
+	}
+	group.value.Sum += entry.value
+	if len(group.counts) == 0 || entry.value < group.value.Min {
+		group.value.Min = entry.value
+	}
+	if len(group.counts) == 0 || entry.value > group.value.Max {
+		group.value.Max = entry.value
+	}
+	group.counts[entry.value]++
+}
+
+func (a *aggregate[T]) remove(obj *T, entry aggregateEntry) {
+	delete(a.entries, obj)
+	group, ok := a.groups[entry.key]
+	if !ok {
+		return
+	}
+	group.value.Count--
+	if group.value.Count <= 0 {
+		delete(a.groups, entry.key)
+		return
+	}
+	if a.value == nil || entry.noValue {
+		return
+	}
+	group.value.Sum -= entry.value
+	if group.counts[entry.value]--; group.counts[entry.value] > 0 {
+		return
+	}
+	delete(group.counts, entry.value)
+	if len(group.counts) == 0 {
+		group.value.Min, group.value.Max = 0, 0
+	} else if entry.value == group.value.Min || entry.value == group.value.Max {
+		first := true
+		for v := range group.counts {
+			if first || v < group.value.Min {
+				group.value.Min = v
+			}
+			if first || v > group.value.Max {
+				group.value.Max = v
+			}
+			first = false
+		}
+	}
+}
+
+// Aggregates 注册的分组聚合集合, 零值可用. 对象加入和移出管理类时调用Insert和Remove, 修改分组或值字段时使用Update
+// 查询为O(1), 删除分组中的最小或最大值时按该分组不同值的数量重新计算
+type Aggregates[T any] struct {
+	mutex      sync.Mutex                               // 添加聚合互斥
+	aggregates atomic.Pointer[map[string]*aggregate[T]] // 写时复制
+}
+
+// Add 添加计算分组的聚合, value为空只计数, value返回NaN的对象只计数, 用rangeAll遍历的现有对象初始化
+func (r *Aggregates[T]) Add(name string, group func(obj *T) any, value func(obj *T) float64, rangeAll func(f func(obj *T) bool)) error {
+	if group == nil {
+		return errors.New("aggregate: group func is nil")
+	}
+	return r.add(name, &aggregate[T]{group: group, value: value}, rangeAll)
+}
+
+// AddField 添加按字段分组的聚合, 多个字段为组合分组, valueField为数值字段, 为空只计数, 值为NaN的对象只计数
+func (r *Aggregates[T]) AddField(name string, groupFields []string, valueField string, rangeAll func(f func(obj *T) bool)) error {
+	if len(groupFields) == 0 {
+		return errors.New("aggregate: group fields is empty")
+	}
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	a := &aggregate[T]{}
+	for _, name := range groupFields {
+		field, ok := typ.FieldByName(name)
+		if !ok {
+			return fmt.Errorf("aggregate: unknown field %s", name)
+		}
+		if !field.Type.Comparable() {
+			return fmt.Errorf("aggregate: field %s is not comparable", name)
+		}
+		a.fields = append(a.fields, field)
+	}
+	keyType := reflect.ArrayOf(len(a.fields), reflect.TypeOf((*any)(nil)).Elem())
+	a.group = func(obj *T) any {
+		v := reflect.ValueOf(obj).Elem()
+		key := reflect.New(keyType).Elem()
+		for i, field := range a.fields {
+			key.Index(i).Set(v.FieldByIndex(field.Index))
+		}
+		return key.Interface()
+	}
+	if valueField != "" {
+		field, ok := typ.FieldByName(valueField)
+		if !ok {
+			return fmt.Errorf("aggregate: unknown field %s", valueField)
+		}
+		if !isNumberKind(field.Type.Kind()) {
+			return fmt.Errorf("aggregate: field %s is not number", valueField)
+		}
+		index := field.Index
+		a.value = func(obj *T) float64 {
+			return reflect.ValueOf(obj).Elem().FieldByIndex(index).Convert(reflect.TypeOf(float64(0))).Float()
+		}
+	}
+	return r.add(name, a, rangeAll)
+}
+
+func (r *Aggregates[T]) add(name string, a *aggregate[T], rangeAll func(f func(obj *T) bool)) error {
+	a.groups = make(map[any]*aggregateGroup)
+	a.entries = make(map[*T]aggregateEntry)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	old := r.load()
+	if _, ok := old[name]; ok {
+		return errors.New("aggregate: repeated aggregate " + name)
+	}
+	aggregates := make(map[string]*aggregate[T], len(old)+1)
+	for k, v := range old {
+		aggregates[k] = v
+	}
+	aggregates[name] = a
+	// 先发布再初始化, 期间新加入的对象由Insert写入
+	r.aggregates.Store(&aggregates)
+	if rangeAll != nil {
+		rangeAll(func(obj *T) bool {
+			a.insert(obj)
+			return true
+		})
+	}
+	return nil
+}
+
+func (r *Aggregates[T]) load() map[string]*aggregate[T] {
+	if aggregates := r.aggregates.Load(); aggregates != nil {
+		return *aggregates
+	}
+	return nil
+}
+
+// Has 是否存在聚合
+func (r *Aggregates[T]) Has(name string) bool {
+	_, ok := r.load()[name]
+	return ok
+}
+
+// Insert 对象加入所有聚合, 已经加入的对象按新的分组和值重新聚合
+func (r *Aggregates[T]) Insert(obj *T) {
+	for _, a := range r.load() {
+		a.insert(obj)
+	}
+}
+
+// Remove 对象移出所有聚合
+func (r *Aggregates[T]) Remove(obj *T) {
+	for _, a := range r.load() {
+		a.mutex.Lock()
+		if entry, ok := a.entries[obj]; ok {
+			a.remove(obj, entry)
+		}
+		a.mutex.Unlock()
+	}
+}
+
+// Update 执行修改并按新的分组和值重新聚合
+func (r *Aggregates[T]) Update(obj *T, apply func() error) (err error) {
+	defer r.Insert(obj)
+	return apply()
+}
+
+// Clear 清空所有聚合数据, 保留聚合定义
+func (r *Aggregates[T]) Clear() {
+	for _, a := range r.load() {
+		a.mutex.Lock()
+		a.groups = make(map[any]*aggregateGroup)
+		a.entries = make(map[*T]aggregateEntry)
+		a.mutex.Unlock()
+	}
+}
+
+// Get 分组的聚合结果, 字段分组时key依次为各个分组字段的值, 计算分组时key为分组函数的返回值
+func (r *Aggregates[T]) Get(name string, key ...any) (value AggregateValue, ok bool) {
+	a, exist := r.load()[name]
+	if !exist {
+		return
+	}
+	k, err := a.key(key)
+	if err != nil {
+		return
+	}
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if group, exist := a.groups[k]; exist {
+		return group.value, true
+	}
+	return
+}
+
+// Range 遍历所有分组, 字段分组时key为[n]any
+func (r *Aggregates[T]) Range(name string, f func(key any, value AggregateValue) bool) {
+	a, exist := r.load()[name]
+	if !exist {
+		return
+	}
+	a.mutex.RLock()
+	groups := make(map[any]AggregateValue, len(a.groups))
+	for k, group := range a.groups {
+		groups[k] = group.value
+	}
+	a.mutex.RUnlock()
+	for k, v := range groups {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// key 查询参数转换为分组key, 字段分组时转换为字段类型
+func (a *aggregate[T]) key(key []any) (any, error) {
+	if a.fields == nil {
+		if len(key) != 1 {
+			return nil, errors.New("aggregate: need one key")
+		}
+		return key[0], nil
+	}
+	if len(key) != len(a.fields) {
+		return nil, fmt.Errorf("aggregate: need %d keys", len(a.fields))
+	}
+	k := reflect.New(reflect.ArrayOf(len(a.fields), reflect.TypeOf((*any)(nil)).Elem())).Elem()
+	for i, field := range a.fields {
+		v, err := convertValue(field.Type, key[i])
+		if err != nil {
+			return nil, err
+		}
+		k.Index(i).Set(v)
+	}
+	return k.Interface(), nil
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"math"
+	"testing"
+)
+
+func newAggregates(t *testing.T, list []*queryItem) *Aggregates[queryItem] {
+	aggregates := &Aggregates[queryItem]{}
+	rangeAll := func(f func(obj *queryItem) bool) {
+		for _, obj := range list {
+			if !f(obj) {
+				return
+			}
+		}
+	}
+	if err := aggregates.AddField("Type", []string{"Type"}, "Score", rangeAll); err != nil {
+		t.Fatal(err)
+	}
+	if err := aggregates.Add("Odd", func(obj *queryItem) any { return obj.Id%2 == 1 }, nil, rangeAll); err != nil {
+		t.Fatal(err)
+	}
+	return aggregates
+}
+
+// TestAggregates 测试字段分组和计算分组的聚合
+func TestAggregates(t *testing.T) {
+	source := newQuerySource()
+	aggregates := newAggregates(t, source.list)
+
+	value, ok := aggregates.Get("Type", "menu")
+	if !ok || value != (AggregateValue{Count: 3, Sum: 7.5, Min: 1.5, Max: 3}) {
+		t.Errorf("unexpected menu %+v %v", value, ok)
+	}
+	value, ok = aggregates.Get("Odd", true)
+	if !ok || value != (AggregateValue{Count: 3}) {
+		t.Errorf("unexpected odd %+v %v", value, ok)
+	}
+	if _, ok = aggregates.Get("Type", "none"); ok {
+		t.Error("unexpected group")
+	}
+	if _, ok = aggregates.Get("Type", 1); ok {
+		t.Error("unexpected group for wrong key type")
+	}
+
+	groups := 0
+	aggregates.Range("Type", func(key any, value AggregateValue) bool {
+		groups++
+		return true
+	})
+	if groups != 2 {
+		t.Errorf("unexpected groups %d", groups)
+	}
+
+	if err := aggregates.AddField("Type", []string{"Id"}, "", nil); err == nil {
+		t.Error("expected repeated aggregate error")
+	}
+	if err := aggregates.AddField("Tags", []string{"Tags"}, "", nil); err == nil {
+		t.Error("expected not comparable error")
+	}
+	if err := aggregates.AddField("TypeName", []string{"Type"}, "Type", nil); err == nil {
+		t.Error("expected not number error")
+	}
+}
+
+// TestAggregatesUpdate 测试修改分组和值, 删除最小最大值后重新计算
+func TestAggregatesUpdate(t *testing.T) {
+	source := newQuerySource()
+	aggregates := newAggregates(t, source.list)
+
+	// Id 1, Score 3 移到button
+	obj := source.list[1]
+	_ = aggregates.Update(obj, func() error {
+		obj.Type = "button"
+		return nil
+	})
+	if value, _ := aggregates.Get("Type", "menu"); value != (AggregateValue{Count: 2, Sum: 4.5, Min: 1.5, Max: 3}) {
+		t.Errorf("unexpected menu %+v", value)
+	}
+	if value, _ := aggregates.Get("Type", "button"); value != (AggregateValue{Count: 3, Sum: 5.5, Min: 0.5, Max: 3}) {
+		t.Errorf("unexpected button %+v", value)
+	}
+
+	// 删除button的最小值
+	aggregates.Remove(source.list[4])
+	if value, _ := aggregates.Get("Type", "button"); value != (AggregateValue{Count: 2, Sum: 5, Min: 2, Max: 3}) {
+		t.Errorf("unexpected button after remove %+v", value)
+	}
+
+	// 分组为空时删除
+	aggregates.Remove(source.list[1])
+	aggregates.Remove(source.list[2])
+	if _, ok := aggregates.Get("Type", "button"); ok {
+		t.Error("empty group not removed")
+	}
+
+	aggregates.Clear()
+	if _, ok := aggregates.Get("Type", "menu"); ok || !aggregates.Has("Type") {
+		t.Error("unexpected clear")
+	}
+}
+
+// TestAggregatesNaN 测试值为NaN的对象只计数
+func TestAggregatesNaN(t *testing.T) {
+	a := &queryItem{Id: 1, Type: "menu", Score: math.NaN()}
+	b := &queryItem{Id: 2, Type: "menu", Score: 2}
+	aggregates := newAggregates(t, []*queryItem{a, b})
+
+	if value, _ := aggregates.Get("Type", "menu"); value != (AggregateValue{Count: 2, Sum: 2, Min: 2, Max: 2}) {
+		t.Errorf("unexpected menu %+v", value)
+	}
+
+	// NaN对象可以重新聚合和删除
+	aggregates.Insert(a)
+	aggregates.Remove(b)
+	if value, _ := aggregates.Get("Type", "menu"); value != (AggregateValue{Count: 1}) {
+		t.Errorf("unexpected menu after remove %+v", value)
+	}
+	_ = aggregates.Update(a, func() error {
+		a.Score = 1
+		return nil
+	})
+	if value, _ := aggregates.Get("Type", "menu"); value != (AggregateValue{Count: 1, Sum: 1, Min: 1, Max: 1}) {
+		t.Errorf("unexpected menu after update %+v", value)
+	}
+	aggregates.Remove(a)
+	if _, ok := aggregates.Get("Type", "menu"); ok {
+		t.Error("group not removed")
+	}
+}
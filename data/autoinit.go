@@ -0,0 +1,8 @@
+package data
+
+// 生成代码的init()是否自动创建管理类并注册到全局注册表. 不是生成代码, 重新生成不会覆盖
+// 为false时init()不注册, 使用 data.New(cfg).With(UserShareModule).Build() 显式创建
+const (
+	userShareAutoInit   = true
+	menusGlobalAutoInit = true
+)
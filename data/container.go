@@ -0,0 +1,145 @@
+package data
+
+import (
+	"errors"
+	"time"
+
+	"github.com/spelens-gud/persist/core"
+	"xorm.io/xorm"
+)
+
+// Module 可显式创建的管理类, 由生成代码定义, 例如 UserShareModule
+type Module struct {
+	Name  string
+	Build func(c *Container, engine, replica *xorm.Engine) core.IPersist // 创建管理类并写入Container对应字段
+}
+
+// Container 显式创建的管理类, 注册在独立的Registry中, 不修改全局G*Manager
+type Container struct {
+	Registry *core.Registry
+	Engine   *xorm.Engine
+	Replica  *xorm.Engine // 没有配置从库时为nil
+
+	UserShare   *UserShareManager
+	MenusGlobal *MenusGlobalManager
+}
+
+// Builder 管理类构造器
+type Builder struct {
+	config   *DBConfig
+	engine   *xorm.Engine
+	replica  *xorm.Engine
+	registry *core.Registry
+	modules  []Module
+}
+
+// New 按照数据库配置创建构造器
+func New(cfg *DBConfig) *Builder {
+	return &Builder{config: cfg}
+}
+
+// WithEngine 使用已经创建的数据库连接, 不再按照配置创建
+func (b *Builder) WithEngine(engine, replica *xorm.Engine) *Builder {
+	b.engine = engine
+	b.replica = replica
+	return b
+}
+
+// WithRegistry 注册到指定Registry, 默认创建新的Registry
+func (b *Builder) WithRegistry(registry *core.Registry) *Builder {
+	b.registry = registry
+	return b
+}
+
+// With 添加管理类
+func (b *Builder) With(modules ...Module) *Builder {
+	b.modules = append(b.modules, modules...)
+	return b
+}
+
+// Build 创建数据库连接和管理类, 并注册到Registry. 失败时关闭Build创建的数据库连接
+func (b *Builder) Build() (c *Container, err error) {
+	engine, replica := b.engine, b.replica
+	if engine == nil {
+		if b.config == nil {
+			return nil, errors.New("data: db config is nil")
+		}
+		defer func() {
+			if err != nil {
+				closeEngine(engine)
+				closeEngine(replica)
+			}
+		}()
+		engine, err = newEngine(b.config, false)
+		if err != nil {
+			return nil, err
+		}
+		if b.config.ReplicaDns != "" {
+			replica, err = newEngine(b.config, true)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	registry := b.registry
+	if registry == nil {
+		registry = core.NewRegistry()
+	}
+	c = &Container{Registry: registry, Engine: engine, Replica: replica}
+	// 先检查全部模块, 失败时不注册任何管理类
+	names := make(map[string]bool, len(b.modules))
+	for _, module := range b.modules {
+		if module.Build == nil {
+			return nil, errors.New("data: invalid module " + module.Name)
+		}
+		if names[module.Name] || registry.GetIPersistByName(module.Name) != nil {
+			return nil, errors.New("data: repeated module " + module.Name)
+		}
+		names[module.Name] = true
+	}
+	for _, module := range b.modules {
+		registry.RegisterPersist(module.Name, module.Build(c, engine, replica))
+	}
+	return c, nil
+}
+
+// newEngine 按照配置创建主库或从库连接, GetDB, GetReplicaDB和Build共用
+func newEngine(cfg *DBConfig, replica bool) (*xorm.Engine, error) {
+	dns := cfg.Dns
+	maxIdleConns, maxOpenConns, connMaxLifetime := 2, 4, time.Hour
+	if replica {
+		dns = cfg.ReplicaDns
+		maxIdleConns, maxOpenConns, connMaxLifetime = replicaPoolConfig(cfg)
+	}
+	engine, err := xorm.NewEngine(cfg.Driver, dns)
+	if err != nil {
+		return nil, err
+	}
+	//engine.ShowSQL(true)
+	engine.SetMaxIdleConns(maxIdleConns)       //设置连接池中的保持连接的最大连接数
+	engine.SetMaxOpenConns(maxOpenConns)       //设置连接池的打开的最大连接数
+	engine.SetConnMaxLifetime(connMaxLifetime) //设置连接超时时间
+	return engine, nil
+}
+
+func closeEngine(engine *xorm.Engine) {
+	if engine != nil {
+		_ = engine.Close()
+	}
+}
+
+// replicaPoolConfig 从库连接池配置, 未配置时和主库相同
+func replicaPoolConfig(cfg *DBConfig) (maxIdleConns, maxOpenConns int, connMaxLifetime time.Duration) {
+	maxIdleConns, maxOpenConns, connMaxLifetime = 2, 4, time.Hour
+	if cfg.ReplicaMaxIdleConns > 0 {
+		maxIdleConns = cfg.ReplicaMaxIdleConns
+	}
+	if cfg.ReplicaMaxOpenConns > 0 {
+		maxOpenConns = cfg.ReplicaMaxOpenConns
+	}
+	if cfg.ReplicaConnMaxLifetime > 0 {
+		connMaxLifetime = cfg.ReplicaConnMaxLifetime
+	}
+	return
+}
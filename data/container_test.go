@@ -0,0 +1,58 @@
+package data
+
+import (
+	"strings"
+	"testing"
+
+	persistCore "github.com/spelens-gud/persist/core"
+)
+
+// TestAutoInit 测试生成代码的init()按照autoinit.go的常量注册到全局注册表
+func TestAutoInit(t *testing.T) {
+	for name, autoInit := range map[string]bool{"UserShare": userShareAutoInit, "MenusGlobal": menusGlobalAutoInit} {
+		registered := func() (registered bool) {
+			// 没有数据库配置时惰性注册, 重复惰性注册panic
+			defer func() { registered = recover() != nil }()
+			persistCore.DefaultRegistry().RegisterPersistLazy(name, &UserShareManager{})
+			return
+		}()
+		if registered != autoInit {
+			t.Errorf("%s: registered %v, auto init %v", name, registered, autoInit)
+		}
+	}
+}
+
+// TestBuild 测试显式创建管理类注册到独立的注册表, 不修改全局管理类
+func TestBuild(t *testing.T) {
+	engine, _ := newUserShareTestEngine(t)
+	global := GUserShareManager
+
+	c, err := New(nil).WithEngine(engine, nil).With(UserShareModule, MenusGlobalModule).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.UserShare == nil || c.MenusGlobal == nil || c.Engine != engine || c.Replica != nil {
+		t.Fatalf("unexpected container %+v", c)
+	}
+	if c.Registry.GetIPersistByName("UserShare") != c.UserShare || c.Registry.GetIPersistByName("MenusGlobal") != c.MenusGlobal {
+		t.Error("managers not registered")
+	}
+	if c.Registry == persistCore.DefaultRegistry() || GUserShareManager != global || c.UserShare == global {
+		t.Error("global manager changed")
+	}
+
+	// 模块重复或者已经注册时不注册任何管理类
+	registry := persistCore.NewRegistry()
+	if _, err = New(nil).WithEngine(engine, nil).WithRegistry(registry).With(MenusGlobalModule, UserShareModule, UserShareModule).Build(); err == nil || !strings.Contains(err.Error(), "repeated module UserShare") {
+		t.Errorf("unexpected repeated error %v", err)
+	}
+	if len(registry.GetPersistList()) != 0 {
+		t.Error("partially registered")
+	}
+	if _, err = New(nil).WithEngine(engine, nil).WithRegistry(c.Registry).With(UserShareModule).Build(); err == nil {
+		t.Error("expected registered error")
+	}
+	if _, err = New(nil).With(UserShareModule).Build(); err == nil {
+		t.Error("expected nil config error")
+	}
+}
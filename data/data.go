@@ -1,9 +1,17 @@
 package data
 
+import "time"
+
 type DBConfig struct {
 	Mode   string
 	Dns    string
 	Driver string
+
+	// 从库, 用于全导入和批量查询, 为空时不使用从库
+	ReplicaDns             string
+	ReplicaMaxIdleConns    int           // 从库连接池保持连接的最大连接数, 0使用默认值
+	ReplicaMaxOpenConns    int           // 从库连接池打开的最大连接数, 0使用默认值
+	ReplicaConnMaxLifetime time.Duration // 从库连接超时时间, 0使用默认值
 }
 type Option func(*DBConfig)
 
@@ -25,6 +33,20 @@ func WithModeOption(mode string) Option {
 	}
 }
 
+func WithReplicaDnsOption(dns string) Option {
+	return func(c *DBConfig) {
+		c.ReplicaDns = dns
+	}
+}
+
+func WithReplicaPoolOption(maxIdleConns, maxOpenConns int, connMaxLifetime time.Duration) Option {
+	return func(c *DBConfig) {
+		c.ReplicaMaxIdleConns = maxIdleConns
+		c.ReplicaMaxOpenConns = maxOpenConns
+		c.ReplicaConnMaxLifetime = connMaxLifetime
+	}
+}
+
 func NewDBOption(options ...Option) {
 	defaultDBConfig = &DBConfig{}
 	for _, option := range options {
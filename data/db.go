@@ -1,12 +1,12 @@
 package data
 
 import (
+	"context"
 	"sync"
-	"time"
 
 	"github.com/Anniext/Arkitektur/system/log"
-	"github.com/Anniext/Verktyg/persist/core"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/spelens-gud/persist/core"
 	"xorm.io/xorm"
 )
 
@@ -28,21 +28,36 @@ func GetDB() *xorm.Engine {
 
 	engineOnce.Do(func() {
 		var err error
-		gEngine, err = xorm.NewEngine(dbCnf.Driver, dbCnf.Dns)
+		gEngine, err = newEngine(dbCnf, false)
 		if err != nil {
 			gEngine = nil
 			log.Info("GetDB error", err)
-		} else {
-			//gEngine.ShowSQL(true)
-			gEngine.SetMaxIdleConns(2)            //设置连接池中的保持连接的最大连接数
-			gEngine.SetMaxOpenConns(4)            //设置连接池的打开的最大连接数
-			gEngine.SetConnMaxLifetime(time.Hour) //设置连接超时时间
-			//gEngine.AddHook()
 		}
 	})
 	return gEngine
 }
 
+var gReplicaEngine *xorm.Engine
+var replicaEngineOnce sync.Once
+
+// GetReplicaDB 从库, 没有配置从库返回nil
+func GetReplicaDB() *xorm.Engine {
+	dbCnf := GetDefaultDBConfig()
+	if dbCnf == nil || dbCnf.ReplicaDns == "" {
+		return nil
+	}
+
+	replicaEngineOnce.Do(func() {
+		var err error
+		gReplicaEngine, err = newEngine(dbCnf, true)
+		if err != nil {
+			gReplicaEngine = nil
+			log.Info("GetReplicaDB error", err)
+		}
+	})
+	return gReplicaEngine
+}
+
 func Register(name string, persist core.IPersist) {
 	core.RegisterPersist(name, persist)
 }
@@ -62,7 +77,9 @@ func Exit() (err error) {
 
 func Run() (err error) {
 	log.Infoln("Run Begin")
-	err = core.RunPersist()
+	report := core.RunPersistReport(context.Background())
+	log.Infoln(report.String())
+	err = report.Err()
 	if err != nil {
 		return
 	}
@@ -79,7 +96,9 @@ func Init() (err error) {
 	if engine == nil {
 		panic("GetDB Error")
 	}
-	err = core.SyncPersist()
+	report := core.SyncPersistReport(context.Background())
+	log.Infoln(report.String())
+	err = report.Err()
 	if err != nil {
 		return
 	}
@@ -0,0 +1,82 @@
+package data
+
+import (
+	"sync"
+	"testing"
+
+	persistCore "github.com/spelens-gud/persist/core"
+	"github.com/spelens-gud/persist/model"
+)
+
+// TestDiffUserShare 测试按字段比较, 返回差异字段和对应的位
+func TestDiffUserShare(t *testing.T) {
+	m := NewUserShareManager(nil)
+	mem := &model.UserShare{Uid: 1, UserName: "a", NickName: "n", Status: 1}
+	db := &model.UserShare{Uid: 1, UserName: "a", NickName: "x", Status: 2}
+
+	diff, bitSet := m.DiffUserShare(mem, db)
+	expected := []persistCore.FieldDiff{{Field: "NickName", Mem: "n", DB: "x"}, {Field: "Status", Mem: int64(1), DB: int64(2)}}
+	if len(diff) != len(expected) || diff[0] != expected[0] || diff[1] != expected[1] {
+		t.Errorf("unexpected diff %+v", diff)
+	}
+	if !bitSet.Get(EUserShareFieldIndexNickName) || !bitSet.Get(EUserShareFieldIndexStatus) || bitSet.Get(EUserShareFieldIndexUserName) {
+		t.Error("unexpected bit set")
+	}
+	if diff, _ = m.DiffUserShare(mem, mem); len(diff) != 0 {
+		t.Errorf("unexpected diff of same object %+v", diff)
+	}
+}
+
+// TestUserShareSyncDataColumns 测试比较后只更新不一致的列, debug模式上报差异
+func TestUserShareSyncDataColumns(t *testing.T) {
+	engine, _ := newUserShareTestEngine(t)
+	if _, err := engine.Insert(&model.UserShare{Uid: 1, UserName: "a", NickName: "n", Remark: "r"}); err != nil {
+		t.Fatal(err)
+	}
+	// 记录update语句设置的列
+	for _, sql := range []string{
+		"CREATE TABLE col_log (col TEXT)",
+		"CREATE TRIGGER log_nick AFTER UPDATE OF nick_name ON user_share BEGIN INSERT INTO col_log VALUES ('nick_name'); END",
+		"CREATE TRIGGER log_remark AFTER UPDATE OF remark ON user_share BEGIN INSERT INTO col_log VALUES ('remark'); END",
+	} {
+		if _, err := engine.Exec(sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewUserShareManager(engine)
+	if err := m.Load(1); err != nil {
+		t.Fatal(err)
+	}
+	cls := m.GetUserShareByUid(1)
+	if cls == nil {
+		t.Fatal("object not loaded")
+	}
+	// 修改对象但没有标记
+	cls.NickName = "m"
+
+	reporter := &persistCore.MemoryReporter{}
+	persistCore.SetReporter(reporter)
+	defer persistCore.SetReporter(nil)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	if err := m.SyncData(&wg, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var cols []string
+	if err := engine.SQL("SELECT col FROM col_log").Find(&cols); err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 1 || cols[0] != "nick_name" {
+		t.Errorf("unexpected updated columns %v", cols)
+	}
+	dbCls := &model.UserShare{Uid: 1}
+	if has, err := engine.Get(dbCls); err != nil || !has || dbCls.NickName != "m" || dbCls.Remark != "r" {
+		t.Errorf("unexpected row %v %v %+v", has, err, dbCls)
+	}
+	events := reporter.Events()
+	if len(events) != 1 || events[0].Kind != persistCore.EReportKindMismatch || len(events[0].Fields) != 1 || events[0].Fields[0].Field != "NickName" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
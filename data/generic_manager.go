@@ -3,11 +3,14 @@
 package data
 
 import (
+	"errors"
+	"log"
 	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	persistCore "github.com/spelens-gud/persist/core"
 	"xorm.io/xorm"
 )
 
@@ -28,6 +31,19 @@ type GenericManager[T any] struct {
 	// 索引映射：字段名 -> 索引
 	singleIndexes map[string]*SyncMap[any, *T]                 // 单值索引
 	multiIndexes  map[string]*SyncMap[any, *SyncMap[*T, bool]] // 多值索引
+	prefixIndexes map[string]*PrefixIndex[T]                   // 字符串前缀索引
+
+	// 树索引: tree:"parent"标签的父节点字段, 可选tree:"path"标签的物化路径字段
+	treeParentField string
+	treePathField   string
+	treeIndex       TreeIndex[T, any]
+	treeMutex       sync.Mutex // MoveSubtree互斥
+
+	// 运行时添加的计算key索引
+	runtimeIndexes RuntimeIndexes[T]
+
+	// 分组聚合: 字段索引自动注册同名计数聚合, 用于CountByField
+	aggregates Aggregates[T]
 
 	// 对象池
 	pool *sync.Pool
@@ -52,6 +68,7 @@ func NewGenericManager[T any](engine *xorm.Engine) *GenericManager[T] {
 		storage:       &SyncMap[any, *T]{},
 		singleIndexes: make(map[string]*SyncMap[any, *T]),
 		multiIndexes:  make(map[string]*SyncMap[any, *SyncMap[*T, bool]]),
+		prefixIndexes: make(map[string]*PrefixIndex[T]),
 		syncChan:      make(chan *syncOp[T], 1000),
 		exitChan:      make(chan bool),
 		pool:          &sync.Pool{New: func() interface{} { var t T; return &t }},
@@ -75,6 +92,21 @@ func (m *GenericManager[T]) analyzeModel() {
 	for i := 0; i < typ.NumField(); i++ {
 		field := typ.Field(i)
 
+		// 检查 prefix 标签, 字符串字段创建前缀索引
+		if field.Tag.Get("prefix") == "1" && field.Type.Kind() == reflect.String {
+			m.prefixIndexes[field.Name] = &PrefixIndex[T]{}
+		}
+
+		// 检查 tree 标签, 父节点字段和物化路径字段
+		switch field.Tag.Get("tree") {
+		case "parent":
+			m.treeParentField = field.Name
+		case "path":
+			if field.Type.Kind() == reflect.String {
+				m.treePathField = field.Name
+			}
+		}
+
 		// 检查 xorm 标签中的 pk（主键）
 		xormTag := field.Tag.Get("xorm")
 		if contains(xormTag, "pk") {
@@ -91,9 +123,15 @@ func (m *GenericManager[T]) analyzeModel() {
 			if contains(hashTag, "unique=1") {
 				// 创建单值索引
 				m.singleIndexes[field.Name] = &SyncMap[any, *T]{}
+				if err := m.aggregates.AddField(field.Name, []string{field.Name}, "", nil); err != nil {
+					log.Println("GenericManager: aggregate", field.Name, err)
+				}
 			} else if contains(hashTag, "unique=0") {
 				// 创建多值索引
 				m.multiIndexes[field.Name] = &SyncMap[any, *SyncMap[*T, bool]]{}
+				if err := m.aggregates.AddField(field.Name, []string{field.Name}, "", nil); err != nil {
+					log.Println("GenericManager: aggregate", field.Name, err)
+				}
 			}
 		}
 	}
@@ -113,6 +151,8 @@ func (m *GenericManager[T]) Insert(obj *T) error {
 
 	// 更新所有索引
 	m.updateIndexes(obj, true)
+	m.runtimeIndexes.Insert(obj)
+	m.aggregates.Insert(obj)
 
 	// 发送同步操作
 	m.syncChan <- &syncOp[T]{op: 1, data: obj}
@@ -175,6 +215,8 @@ func (m *GenericManager[T]) Update(obj *T) error {
 	if exists {
 		// 从索引中删除旧数据
 		m.updateIndexes(old, false)
+		m.runtimeIndexes.Remove(old)
+		m.aggregates.Remove(old)
 	}
 
 	// 存储新数据
@@ -182,6 +224,8 @@ func (m *GenericManager[T]) Update(obj *T) error {
 
 	// 添加到索引
 	m.updateIndexes(obj, true)
+	m.runtimeIndexes.Insert(obj)
+	m.aggregates.Insert(obj)
 
 	// 发送同步操作
 	m.syncChan <- &syncOp[T]{op: 2, data: obj}
@@ -202,6 +246,8 @@ func (m *GenericManager[T]) Delete(obj *T) error {
 
 	// 从索引删除
 	m.updateIndexes(obj, false)
+	m.runtimeIndexes.Remove(obj)
+	m.aggregates.Remove(obj)
 
 	// 发送同步操作
 	m.syncChan <- &syncOp[T]{op: 3, data: obj}
@@ -245,6 +291,18 @@ func (m *GenericManager[T]) RangeByField(fieldName string, value any, f func(*T)
 	})
 }
 
+// PrefixSearch 通过前缀索引查找字段以prefix开头的数据, 按字段字典序, limit<=0不限制. 字段需要prefix:"1"标签
+func (m *GenericManager[T]) PrefixSearch(fieldName, prefix string, limit int) []*T {
+	m.mu.RLock()
+	index, exists := m.prefixIndexes[fieldName]
+	m.mu.RUnlock()
+
+	if !exists {
+		return nil
+	}
+	return index.Search(prefix, limit)
+}
+
 // Count 统计数量
 func (m *GenericManager[T]) Count() int {
 	count := 0
@@ -255,8 +313,12 @@ func (m *GenericManager[T]) Count() int {
 	return count
 }
 
-// CountByField 统计指定字段值的数量
+// CountByField 统计指定字段值的数量, 字段索引使用同名计数聚合
 func (m *GenericManager[T]) CountByField(fieldName string, value any) int {
+	if m.aggregates.Has(fieldName) {
+		v, _ := m.aggregates.Get(fieldName, value)
+		return int(v.Count)
+	}
 	objs := m.GetAllByField(fieldName, value)
 	return len(objs)
 }
@@ -272,7 +334,252 @@ func (m *GenericManager[T]) Clear() {
 	for _, index := range m.multiIndexes {
 		index.Clear()
 	}
+	for _, index := range m.prefixIndexes {
+		index.Clear()
+	}
 	m.mu.Unlock()
+
+	m.treeIndex.Clear()
+
+	m.runtimeIndexes.Clear()
+	m.aggregates.Clear()
+}
+
+// AddIndex 运行时添加字段索引, 用现有数据建立索引, 之后可以使用GetByField等按字段查询
+func (m *GenericManager[T]) AddIndex(fieldName string, unique bool) error {
+	if _, err := FieldIndexFunc[T](fieldName); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.singleIndexes[fieldName]; ok {
+		return errors.New("GenericManager: repeated index " + fieldName)
+	}
+	if _, ok := m.multiIndexes[fieldName]; ok {
+		return errors.New("GenericManager: repeated index " + fieldName)
+	}
+
+	if unique {
+		index := &SyncMap[any, *T]{}
+		m.storage.Range(func(_ any, obj *T) bool {
+			index.Store(m.extractFieldValue(obj, fieldName), obj)
+			return true
+		})
+		m.singleIndexes[fieldName] = index
+	} else {
+		index := &SyncMap[any, *SyncMap[*T, bool]]{}
+		m.storage.Range(func(_ any, obj *T) bool {
+			set, _ := index.LoadOrStore(m.extractFieldValue(obj, fieldName), &SyncMap[*T, bool]{})
+			set.Store(obj, true)
+			return true
+		})
+		m.multiIndexes[fieldName] = index
+	}
+	return m.aggregates.AddField(fieldName, []string{fieldName}, "", m.Range)
+}
+
+// AddIndexFunc 运行时添加计算key的索引, 使用GetByIndex和GetAllByIndex查询
+func (m *GenericManager[T]) AddIndexFunc(name string, unique bool, key func(obj *T) any) error {
+	return m.runtimeIndexes.Add(name, unique, key, m.Range)
+}
+
+// GetByIndex 通过计算key索引获取数据
+func (m *GenericManager[T]) GetByIndex(name string, key any) (*T, bool) {
+	return m.runtimeIndexes.Get(name, key)
+}
+
+// GetAllByIndex 通过计算key索引获取所有数据
+func (m *GenericManager[T]) GetAllByIndex(name string, key any) []*T {
+	return m.runtimeIndexes.GetAll(name, key)
+}
+
+// SetField 修改字段, 维护所有索引并同步到数据库
+func (m *GenericManager[T]) SetField(obj *T, fieldName string, value any) error {
+	if obj == nil {
+		return nil
+	}
+	var t T
+	if field, ok := reflect.TypeOf(t).FieldByName(fieldName); ok && contains(field.Tag.Get("xorm"), "pk") {
+		return errors.New("GenericManager: primary key " + fieldName + " can not be set")
+	}
+	if !m.stored(obj) {
+		return persistCore.EPersistErrorOutOfDate
+	}
+
+	if err := m.setField(obj, fieldName, value); err != nil {
+		return err
+	}
+
+	// 发送同步操作
+	m.syncChan <- &syncOp[T]{op: 2, data: obj}
+
+	return nil
+}
+
+// stored obj是否为管理类中按主键存储的对象, 不是时修改不会维护索引
+func (m *GenericManager[T]) stored(obj *T) bool {
+	current, ok := m.storage.Load(m.extractPrimaryKey(obj))
+	return ok && current == obj
+}
+
+// setField 修改字段并维护所有索引, 不同步到数据库
+func (m *GenericManager[T]) setField(obj *T, fieldName string, value any) error {
+	m.updateIndexes(obj, false)
+	err := m.aggregates.Update(obj, func() error {
+		return m.runtimeIndexes.Update(obj, func() error {
+			return setStructField(obj, fieldName, value)
+		})
+	})
+	m.updateIndexes(obj, true)
+	return err
+}
+
+// AddAggregate 运行时添加按字段分组的聚合, 多个字段为组合分组, valueField为数值字段, 为空只计数
+// 例如 AddAggregate("DeptStatus", []string{"DeptId", "Status"}, "")
+func (m *GenericManager[T]) AddAggregate(name string, groupFields []string, valueField string) error {
+	return m.aggregates.AddField(name, groupFields, valueField, m.Range)
+}
+
+// AddAggregateFunc 运行时添加计算分组的聚合, 分组和值函数只能使用通过SetField修改的字段
+func (m *GenericManager[T]) AddAggregateFunc(name string, group func(obj *T) any, value func(obj *T) float64) error {
+	return m.aggregates.Add(name, group, value, m.Range)
+}
+
+// Aggregate 分组的聚合结果, 字段分组时key依次为各个分组字段的值
+func (m *GenericManager[T]) Aggregate(name string, key ...any) (AggregateValue, bool) {
+	return m.aggregates.Get(name, key...)
+}
+
+// RangeAggregate 遍历聚合的所有分组
+func (m *GenericManager[T]) RangeAggregate(name string, f func(key any, value AggregateValue) bool) {
+	m.aggregates.Range(name, f)
+}
+
+// Children 通过树索引查找直接子节点, 模型需要tree:"parent"标签
+func (m *GenericManager[T]) Children(pk any) []*T {
+	return m.treeIndex.Children(pk)
+}
+
+// Descendants 通过树索引查找所有子孙节点, 按层序
+func (m *GenericManager[T]) Descendants(pk any) []*T {
+	return m.treeIndex.Descendants(pk)
+}
+
+// Ancestors 通过树索引查找所有祖先节点, 从父节点到根节点
+func (m *GenericManager[T]) Ancestors(pk any) []*T {
+	return m.treeIndex.Ancestors(pk)
+}
+
+// MoveSubtree 把节点移动到parent下, 存在tree:"path"字段时重写整个子树的物化路径, 所有修改的数据同步到数据库
+func (m *GenericManager[T]) MoveSubtree(obj *T, parent any) error {
+	if obj == nil {
+		return nil
+	}
+	if m.treeParentField == "" {
+		return errors.New("GenericManager: no tree index")
+	}
+	if !m.stored(obj) {
+		return persistCore.EPersistErrorOutOfDate
+	}
+	var t T
+	field, _ := reflect.TypeOf(t).FieldByName(m.treeParentField)
+	v, err := convertValue(field.Type, parent)
+	if err != nil {
+		return errors.New("GenericManager: " + m.treeParentField + " " + err.Error())
+	}
+	parent = v.Interface()
+
+	m.treeMutex.Lock()
+	defer m.treeMutex.Unlock()
+
+	pk := m.extractPrimaryKey(obj)
+	if m.treeIndex.IsDescendant(parent, pk) {
+		return persistCore.EPersistErrorTreeCycle
+	}
+	if err = m.setField(obj, m.treeParentField, parent); err != nil {
+		return err
+	}
+
+	// 层序遍历, 父节点的路径先于子节点更新
+	list := append([]*T{obj}, m.treeIndex.Descendants(pk)...)
+	if m.treePathField != "" {
+		for _, node := range list {
+			parentPath := ""
+			nodeParent := m.extractFieldValue(node, m.treeParentField)
+			if parentObj, ok := m.storage.Load(nodeParent); ok {
+				parentPath = m.extractFieldValue(parentObj, m.treePathField).(string)
+			}
+			if err = m.setField(node, m.treePathField, JoinTreePath(parentPath, nodeParent)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 发送同步操作
+	for _, node := range list {
+		m.syncChan <- &syncOp[T]{op: 2, data: node}
+	}
+
+	return nil
+}
+
+// Query 内存查询, 存在索引时使用索引, 默认返回深拷贝
+func (m *GenericManager[T]) Query() *Query[T] {
+	return NewQuery[T](m)
+}
+
+// Page 按字段稳定排序分页, 返回总数和下一页游标
+func (m *GenericManager[T]) Page(page PageQuery) (*PageResult[T], error) {
+	return m.Query().Page(page)
+}
+
+// QueryPrimaryField 主键字段名
+func (m *GenericManager[T]) QueryPrimaryField() string {
+	var t T
+	typ := reflect.TypeOf(t)
+	for i := 0; i < typ.NumField(); i++ {
+		if contains(typ.Field(i).Tag.Get("xorm"), "pk") {
+			return typ.Field(i).Name
+		}
+	}
+	return ""
+}
+
+// QueryRange 遍历所有数据
+func (m *GenericManager[T]) QueryRange(f func(obj *T) bool) {
+	m.Range(f)
+}
+
+// QueryLookup 字段等值索引查找, 使用主键和字段索引
+func (m *GenericManager[T]) QueryLookup(fieldName string, value any) ([]*T, bool) {
+	if fieldName == m.QueryPrimaryField() {
+		if obj, ok := m.Get(value); ok {
+			return []*T{obj}, true
+		}
+		return nil, true
+	}
+
+	m.mu.RLock()
+	_, single := m.singleIndexes[fieldName]
+	_, multi := m.multiIndexes[fieldName]
+	m.mu.RUnlock()
+
+	if single {
+		if obj, ok := m.GetByField(fieldName, value); ok {
+			return []*T{obj}, true
+		}
+		return nil, true
+	}
+	if multi {
+		return m.GetAllByField(fieldName, value), true
+	}
+	return m.runtimeIndexes.LookupField(fieldName, value)
+}
+
+// QueryCopy 深拷贝数据, 实现CopyTo时使用CopyTo
+func (m *GenericManager[T]) QueryCopy(obj *T) *T {
+	return copyObject(obj)
 }
 
 // extractPrimaryKey 提取主键值
@@ -356,7 +663,25 @@ func (m *GenericManager[T]) updateIndexes(obj *T, add bool) {
 			}
 		}
 	}
+
+	// 更新前缀索引
+	for fieldName, index := range m.prefixIndexes {
+		if add {
+			index.Insert(val.FieldByName(fieldName).String(), obj)
+		} else {
+			index.Remove(obj)
+		}
+	}
 	m.mu.RUnlock()
+
+	// 更新树索引
+	if m.treeParentField != "" {
+		if add {
+			m.treeIndex.Insert(m.extractPrimaryKey(obj), val.FieldByName(m.treeParentField).Interface(), obj)
+		} else {
+			m.treeIndex.Remove(m.extractPrimaryKey(obj))
+		}
+	}
 }
 
 // processSync 处理同步操作
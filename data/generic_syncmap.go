@@ -136,7 +136,7 @@ func (e *entry[K, V]) tryCompareAndSwap(old, new V) bool {
 	}
 }
 
-// unexpungeLocked 确保entry未标记为expunged
+// unexpungeLocked 确保entry未标记为expunged, 已删除的entry可能不在dirty中, 返回true时调用方加入dirty
 func (e *entry[K, V]) unexpungeLocked() (wasExpunged bool) {
 	return e.p.CompareAndSwap(nil, nil)
 }
@@ -162,11 +162,12 @@ func (m *SyncMap[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
 	read = m.loadReadOnly()
 	if e, ok := read.m[key]; ok {
 		if e.unexpungeLocked() {
+			m.dirtyLocked()
 			m.dirty[key] = e
 		}
-		actual, loaded, _ = e.tryLoadOrStore(value)
+		actual, loaded = e.loadOrStoreLocked(value)
 	} else if e, ok := m.dirty[key]; ok {
-		actual, loaded, _ = e.tryLoadOrStore(value)
+		actual, loaded = e.loadOrStoreLocked(value)
 		m.missLocked()
 	} else {
 		if !read.amended {
@@ -181,6 +182,17 @@ func (m *SyncMap[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
 	return actual, loaded
 }
 
+// loadOrStoreLocked 持有锁时加载或存储值, 已删除的entry重新存储
+// 快速路径不会写入已删除的entry, 持有锁时可以直接存储
+func (e *entry[K, V]) loadOrStoreLocked(i V) (actual V, loaded bool) {
+	if p := e.p.Load(); p != nil {
+		return *p, true
+	}
+	ic := i
+	e.p.Store(&ic)
+	return i, false
+}
+
 // tryLoadOrStore 原子地加载或存储值（如果entry未被expunged）
 func (e *entry[K, V]) tryLoadOrStore(i V) (actual V, loaded, ok bool) {
 	p := e.p.Load()
@@ -281,6 +293,7 @@ func (m *SyncMap[K, V]) Swap(key K, value V) (previous V, loaded bool) {
 	read = m.loadReadOnly()
 	if e, ok := read.m[key]; ok {
 		if e.unexpungeLocked() {
+			m.dirtyLocked()
 			m.dirty[key] = e
 		}
 		if v := e.swapLocked(&value); v != nil {
@@ -0,0 +1,38 @@
+package data
+
+import "testing"
+
+// TestSyncMapStoreDeleted 测试删除的key提升到read之后重新存储
+func TestSyncMapStoreDeleted(t *testing.T) {
+	var m SyncMap[int, int]
+	m.Store(1, 1)
+	m.Store(2, 2)
+	// 未命中次数达到dirty长度后提升到read
+	for i := 0; i < 2; i++ {
+		m.Load(3)
+	}
+	m.Delete(1)
+	if actual, loaded := m.LoadOrStore(1, 10); loaded || actual != 10 {
+		t.Errorf("unexpected load or store %d %v", actual, loaded)
+	}
+	if v, ok := m.Load(1); !ok || v != 10 {
+		t.Errorf("unexpected value %d %v", v, ok)
+	}
+
+	m.Delete(2)
+	for i := 0; i < 2; i++ {
+		m.Load(3)
+	}
+	m.Store(2, 20)
+	if v, ok := m.Load(2); !ok || v != 20 {
+		t.Errorf("unexpected value %d %v", v, ok)
+	}
+	count := 0
+	m.Range(func(int, int) bool {
+		count++
+		return true
+	})
+	if count != 2 {
+		t.Errorf("unexpected count %d", count)
+	}
+}
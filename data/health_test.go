@@ -0,0 +1,48 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+// TestUserShareHealth 测试写回结果和失败队列更新健康状态, 未运行时为非存活
+func TestUserShareHealth(t *testing.T) {
+	t.Chdir(t.TempDir())
+	m := NewUserShareManager(nil)
+	health := m.Health()
+	if health.Name != "UserShare" || health.State != EUserShareManagerStateIdle || !health.Dead || !health.LastWriteBack.IsZero() || health.BombExist {
+		t.Errorf("unexpected initial health %+v", health)
+	}
+
+	// 写回失败进入失败队列
+	atomic.StoreInt32(&m.failQueueLength, 1)
+	m.updateHealth(errors.New("write failed"))
+	health = m.Health()
+	if health.LastError != "write failed" || health.LastErrorTime.IsZero() || !health.LastWriteBack.IsZero() || health.FailQueue != 1 || health.FailQueueSince.IsZero() {
+		t.Errorf("unexpected failed health %+v", health)
+	}
+	since := health.FailQueueSince
+	m.updateHealth(nil)
+	if health = m.Health(); health.FailQueueSince != since || health.LastWriteBack.IsZero() {
+		t.Errorf("fail queue since changed %+v", health)
+	}
+
+	// 失败队列清空
+	atomic.StoreInt32(&m.failQueueLength, 0)
+	m.updateHealth(nil)
+	if health = m.Health(); !health.FailQueueSince.IsZero() || health.FailQueue != 0 || health.LastError != "write failed" {
+		t.Errorf("unexpected recovered health %+v", health)
+	}
+
+	if err := os.MkdirAll("_./_Users_xt_go_pumppill_data", 0770); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("_./_Users_xt_go_pumppill_data/UserShare.bomb", nil, 0660); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Health().BombExist {
+		t.Error("bomb file not found")
+	}
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spelens-gud/persist/model"
+)
+
+// hookLog 记录钩子调用顺序, 导出钩子在写回协程中调用
+type hookLog struct {
+	mutex sync.Mutex
+	ops   []string
+}
+
+func (l *hookLog) add(op string) {
+	l.mutex.Lock()
+	l.ops = append(l.ops, op)
+	l.mutex.Unlock()
+}
+
+func (l *hookLog) get() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string(nil), l.ops...)
+}
+
+// userShareHooks 记录调用的钩子, afterLoadErr不为nil时撤销导入
+func userShareHooks(name string, log *hookLog, afterLoadErr error) UserShareHooks {
+	return UserShareHooks{
+		BeforeLoad: func(Uid int64) error {
+			log.add(name + " before load " + strconv.FormatInt(Uid, 10))
+			return nil
+		},
+		AfterLoad: func(Uid int64, list []*model.UserShare) error {
+			log.add(name + " after load " + strconv.Itoa(len(list)))
+			return afterLoadErr
+		},
+		BeforeUnload: func(Uid int64, list []*model.UserShare) {
+			log.add(name + " before unload " + strconv.Itoa(len(list)))
+		},
+		AfterUnload: func(Uid int64) {
+			log.add(name + " after unload " + strconv.FormatInt(Uid, 10))
+			panic("after unload panic")
+		},
+	}
+}
+
+// TestUserShareHooks 测试导入导出钩子按添加顺序调用, 导出钩子panic不影响写回
+func TestUserShareHooks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	engine, _ := newUserShareTestEngine(t)
+	if _, err := engine.Insert(&model.UserShare{Uid: 1, UserName: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	m := NewUserShareManager(engine)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = m.ExitContext(context.Background()) }()
+	log := &hookLog{}
+	m.AddHooks(userShareHooks("1", log, nil))
+	m.AddHooks(userShareHooks("2", log, nil))
+
+	if err := m.Load(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Unload(1); err != nil {
+		t.Fatal(err)
+	}
+	waitUserShare(t, "unload", func() bool { return m.LoadState(1) == EUserShareLoadStateDisk })
+	waitUserShare(t, "after unload", func() bool { return len(log.get()) == 8 })
+	expected := []string{
+		"1 before load 1", "2 before load 1", "1 after load 1", "2 after load 1",
+		"1 before unload 1", "2 before unload 1", "1 after unload 1", "2 after unload 1",
+	}
+	if ops := log.get(); strings.Join(ops, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected ops %v", ops)
+	}
+	if m.GetUserShareByUid(1) != nil || m.Dead() {
+		t.Error("unexpected state after unload")
+	}
+}
+
+// TestUserShareAfterLoadError 测试AfterLoad返回错误时撤销导入, 不调用后面的钩子
+func TestUserShareAfterLoadError(t *testing.T) {
+	engine, _ := newUserShareTestEngine(t)
+	if _, err := engine.Insert(&model.UserShare{Uid: 1, UserName: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	m := NewUserShareManager(engine)
+	log := &hookLog{}
+	hookErr := errors.New("hook failed")
+	m.AddHooks(userShareHooks("1", log, hookErr))
+	m.AddHooks(userShareHooks("2", log, nil))
+
+	if err := m.Load(1); !errors.Is(err, hookErr) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if m.LoadState(1) != EUserShareLoadStateDisk || m.GetUserShareByUid(1) != nil {
+		t.Error("load not rolled back")
+	}
+	if ops := log.get(); len(ops) != 3 || ops[2] != "1 after load 1" {
+		t.Errorf("unexpected ops %v", ops)
+	}
+}
@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	persistCore "github.com/spelens-gud/persist/core"
+	"github.com/spelens-gud/persist/model"
+	"xorm.io/builder"
+)
+
+// insertUserShareRows 直接向数据库插入Uid为1到n的行
+func insertUserShareRows(t *testing.T, m *UserShareManager, n int64) {
+	t.Helper()
+	for Uid := int64(1); Uid <= n; Uid++ {
+		if _, err := m.engine.Insert(&model.UserShare{Uid: Uid, UserName: "u", Mobile: strconv.FormatInt(Uid, 10), Status: Uid % 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestUserShareLoadAllPages 测试按主键分页导入, 每页回调进度
+func TestUserShareLoadAllPages(t *testing.T) {
+	engine, _ := newUserShareTestEngine(t)
+	m := NewUserShareManager(engine)
+	insertUserShareRows(t, m, 5)
+	m.SetLoadAllPageSize(2)
+
+	var loaded []int64
+	err := m.LoadAllContext(context.Background(), func(progress persistCore.LoadProgress) {
+		if progress.Persist != "UserShare" || progress.Table != "" {
+			t.Errorf("unexpected progress %+v", progress)
+		}
+		loaded = append(loaded, progress.Loaded)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 3 || loaded[0] != 2 || loaded[1] != 4 || loaded[2] != 5 {
+		t.Errorf("unexpected progress %v", loaded)
+	}
+	if len(m.GetAll()) != 5 || m.LoadAllState() != EUserShareTableStateMemory || m.LoadState(100) != EUserShareLoadStateMemory {
+		t.Errorf("unexpected load all %d %d", len(m.GetAll()), m.LoadAllState())
+	}
+	if err = m.LoadAll(); !errors.Is(err, persistCore.EPersistErrorIncorrectState) {
+		t.Errorf("unexpected repeated load all %v", err)
+	}
+}
+
+// TestUserShareLoadAllCancel 测试ctx结束时撤销已导入的数据, 之后可以重新导入
+func TestUserShareLoadAllCancel(t *testing.T) {
+	engine, _ := newUserShareTestEngine(t)
+	m := NewUserShareManager(engine)
+	insertUserShareRows(t, m, 5)
+	m.SetLoadAllPageSize(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := m.LoadAllContext(ctx, func(progress persistCore.LoadProgress) {
+		if len(m.GetAll()) != int(progress.Loaded) {
+			t.Errorf("unexpected objects %d, loaded %d", len(m.GetAll()), progress.Loaded)
+		}
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(m.GetAll()) != 0 || m.GetUserShareByUid(1) != nil || m.LoadAllState() != EUserShareTableStateDisk {
+		t.Errorf("load not rolled back %d %d", len(m.GetAll()), m.LoadAllState())
+	}
+
+	if err = m.LoadAll(); err != nil || len(m.GetAll()) != 5 {
+		t.Errorf("unexpected reload %v %d", err, len(m.GetAll()))
+	}
+}
+
+// TestUserShareLoadAllWhere 测试只导入满足条件的数据, 新增或修改后不满足条件的对象只写回不保留
+func TestUserShareLoadAllWhere(t *testing.T) {
+	engine, _ := newUserShareTestEngine(t)
+	m := NewUserShareManager(engine)
+	insertUserShareRows(t, m, 5)
+
+	cond := builder.Eq{"status": 1}
+	if err := m.LoadAllWhere(cond, nil); err == nil {
+		t.Error("expected cond and match error")
+	}
+	if err := m.LoadAllWhere(cond, func(cls *model.UserShare) bool { return cls.Status == 1 }); err != nil {
+		t.Fatal(err)
+	}
+	if ids := m.GetAll(); len(ids) != 3 || m.GetUserShareByUid(2) != nil || m.LoadAllCond() == nil {
+		t.Fatalf("unexpected objects %d", len(ids))
+	}
+
+	// 不满足条件的新增和修改仍然进入写回队列
+	expectSync := func(Uid int64, op int8) {
+		t.Helper()
+		if persistSync := <-m.syncChan; persistSync.Data.Uid != Uid || persistSync.Op != op {
+			t.Errorf("unexpected sync %d %d", persistSync.Data.Uid, persistSync.Op)
+		}
+	}
+	cls, err := m.NewUserShare(&model.UserShare{Uid: 6, Mobile: "6", Status: 0})
+	if cls != nil || !errors.Is(err, persistCore.EPersistErrorOutOfLoadSet) || m.GetUserShareByUid(6) != nil {
+		t.Errorf("unexpected new out of load set %v %v", cls, err)
+	}
+	expectSync(6, EUserShareOpInsert)
+	if _, err = m.NewUserShare(&model.UserShare{Uid: 7, Mobile: "7", Status: 1}); err != nil || m.GetUserShareByUid(7) == nil {
+		t.Errorf("unexpected new in load set %v", err)
+	}
+	expectSync(7, EUserShareOpInsert)
+
+	cls = m.GetUserShareByUid(1)
+	cls.Status = 0
+	if err = m.MarkUpdateByFieldIndex(cls, EUserShareFieldIndexStatus); !errors.Is(err, persistCore.EPersistErrorOutOfLoadSet) || m.GetUserShareByUid(1) != nil {
+		t.Errorf("unexpected update out of load set %v", err)
+	}
+	expectSync(1, EUserShareOpUpdate)
+}
@@ -0,0 +1,174 @@
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/spelens-gud/persist/utils"
+)
+
+// PageQuery 分页参数, 满足utils.IsValidPageQuery
+type PageQuery struct {
+	Page     int    `json:"page"`      // 从1开始, 使用Cursor时忽略
+	PageSize int    `json:"page_size"` // 每页数量
+	OrderBy  string `json:"order_by"`  // 排序字段, 为空按主键, 在Query的OrderBy之后
+	Desc     bool   `json:"desc"`      // 降序
+	Cursor   string `json:"cursor"`    // 上一页的NextCursor, 不为空时按游标分页
+}
+
+// PageResult 分页结果
+type PageResult[T any] struct {
+	List       []*T   `json:"list"`
+	Total      int    `json:"total"`       // 满足条件的总数
+	Page       int    `json:"page"`        // 游标分页时为0
+	PageSize   int    `json:"page_size"`   // 每页数量
+	NextCursor string `json:"next_cursor"` // 下一页游标, 没有下一页为空
+}
+
+// pageCursor 游标内容, 上一页最后一个对象的所有排序字段值
+type pageCursor struct {
+	Fields []string `json:"f"`
+	Desc   []bool   `json:"d"`
+	Values []any    `json:"v"`
+}
+
+// Page 分页查询, 忽略Limit和Offset. 游标分页从上一页最后一个对象之后开始, 翻页期间并发增删对象不会重复或遗漏
+func (q *Query[T]) Page(page PageQuery) (*PageResult[T], error) {
+	if page.Cursor == "" && !utils.IsValidPageQuery(page) || page.PageSize <= 0 || page.Page < 0 {
+		return nil, errors.New("query: invalid page query")
+	}
+	query := *q
+	if page.OrderBy != "" {
+		query.orders = append(q.orders[:len(q.orders):len(q.orders)], &queryOrder{field: page.OrderBy, desc: page.Desc})
+	}
+	orders, err := query.resolveOrders()
+	if err != nil {
+		return nil, err
+	}
+	list, err := query.match()
+	if err != nil {
+		return nil, err
+	}
+	sortByOrders(orders, list)
+
+	result := &PageResult[T]{Total: len(list), PageSize: page.PageSize}
+	start := 0
+	if page.Cursor != "" {
+		after, err := decodePageCursor[T](orders, page.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		start = sort.Search(len(list), func(i int) bool {
+			return lessByOrders(orders, after, reflect.ValueOf(list[i]).Elem())
+		})
+	} else {
+		result.Page = page.Page
+		start = (page.Page - 1) * page.PageSize
+	}
+	if start > len(list) {
+		start = len(list)
+	}
+	end := start + page.PageSize
+	if end > len(list) {
+		end = len(list)
+	}
+	list = list[start:end]
+	if end < result.Total && len(list) > 0 {
+		if result.NextCursor, err = encodePageCursor(orders, list[len(list)-1]); err != nil {
+			return nil, err
+		}
+	}
+	result.List = query.result(list)
+	return result, nil
+}
+
+func encodePageCursor[T any](orders []*queryOrder, obj *T) (string, error) {
+	v := reflect.ValueOf(obj).Elem()
+	cursor := pageCursor{}
+	for _, order := range orders {
+		cursor.Fields = append(cursor.Fields, order.field)
+		cursor.Desc = append(cursor.Desc, order.desc)
+		cursor.Values = append(cursor.Values, v.FieldByIndex(order.index).Interface())
+	}
+	data, err := json.Marshal(&cursor)
+	if err != nil {
+		return "", fmt.Errorf("query: encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodePageCursor 解析游标为对象值, 排序方式必须和生成游标时相同
+func decodePageCursor[T any](orders []*queryOrder, s string) (reflect.Value, error) {
+	invalid := errors.New("query: invalid cursor")
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return reflect.Value{}, invalid
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	cursor := pageCursor{}
+	if err = decoder.Decode(&cursor); err != nil {
+		return reflect.Value{}, invalid
+	}
+	if len(cursor.Fields) != len(orders) || len(cursor.Desc) != len(orders) || len(cursor.Values) != len(orders) {
+		return reflect.Value{}, invalid
+	}
+	after := reflect.New(reflect.TypeOf((*T)(nil)).Elem()).Elem()
+	for i, order := range orders {
+		if cursor.Fields[i] != order.field || cursor.Desc[i] != order.desc {
+			return reflect.Value{}, errors.New("query: cursor order mismatch")
+		}
+		field := after.FieldByIndex(order.index)
+		if err = setCursorValue(field, cursor.Values[i]); err != nil {
+			return reflect.Value{}, invalid
+		}
+	}
+	return after, nil
+}
+
+func setCursorValue(field reflect.Value, value any) (err error) {
+	var s string
+	switch v := value.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		return errors.New("unknown value")
+	}
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(s, 10, 64); err == nil {
+			field.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(s, 10, 64); err == nil {
+			field.SetUint(n)
+		}
+	case reflect.Float32, reflect.Float64:
+		var n float64
+		if n, err = strconv.ParseFloat(s, 64); err == nil {
+			field.SetFloat(n)
+		}
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(s); err == nil {
+			field.SetBool(b)
+		}
+	default:
+		err = errors.New("unknown kind")
+	}
+	return
+}
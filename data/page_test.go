@@ -0,0 +1,91 @@
+package data
+
+import (
+	"testing"
+)
+
+// TestQueryPage 测试页码分页
+func TestQueryPage(t *testing.T) {
+	source := newQuerySource()
+
+	result, err := NewQuery[queryItem](source).Page(PageQuery{Page: 2, PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 5 || result.Page != 2 || result.PageSize != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if ids := queryIds(result.List); !equalIds(ids, []int64{3, 4}) {
+		t.Errorf("unexpected page %v", ids)
+	}
+	if result.NextCursor == "" {
+		t.Error("expected next cursor")
+	}
+
+	result, err = NewQuery[queryItem](source).Where("Type", QueryEQ, "menu").Page(PageQuery{Page: 1, PageSize: 5, OrderBy: "Score", Desc: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := queryIds(result.List); !equalIds(ids, []int64{1, 5, 3}) || result.NextCursor != "" {
+		t.Errorf("unexpected page %v %q", ids, result.NextCursor)
+	}
+
+	result, err = NewQuery[queryItem](source).Page(PageQuery{Page: 10, PageSize: 2})
+	if err != nil || len(result.List) != 0 || result.NextCursor != "" {
+		t.Errorf("unexpected out of range page %+v %v", result, err)
+	}
+}
+
+// TestQueryPageCursor 测试游标分页, 翻页期间增删对象不会重复或遗漏
+func TestQueryPageCursor(t *testing.T) {
+	source := newQuerySource()
+	page := PageQuery{PageSize: 2, OrderBy: "Score"}
+
+	var ids []int64
+	result, err := NewQuery[queryItem](source).Page(PageQuery{Page: 1, PageSize: page.PageSize, OrderBy: page.OrderBy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = append(ids, queryIds(result.List)...)
+
+	// 在已经返回的位置之前插入, 并删除已经返回的对象
+	source.list = append(source.list, &queryItem{Id: 6, Type: "menu", Score: 0})
+	source.list = source.list[1:]
+
+	for result.NextCursor != "" {
+		page.Cursor = result.NextCursor
+		if result, err = NewQuery[queryItem](source).Page(page); err != nil {
+			t.Fatal(err)
+		}
+		if result.Page != 0 {
+			t.Errorf("unexpected cursor page %d", result.Page)
+		}
+		ids = append(ids, queryIds(result.List)...)
+	}
+	if !equalIds(ids, []int64{4, 3, 2, 1, 5}) {
+		t.Errorf("unexpected pages %v", ids)
+	}
+}
+
+// TestQueryPageError 测试错误的分页参数和游标
+func TestQueryPageError(t *testing.T) {
+	source := newQuerySource()
+
+	if _, err := NewQuery[queryItem](source).Page(PageQuery{Page: 0, PageSize: 2}); err == nil {
+		t.Error("expected invalid page error")
+	}
+	if _, err := NewQuery[queryItem](source).Page(PageQuery{Page: 1, PageSize: 2, OrderBy: "Unknown"}); err == nil {
+		t.Error("expected unknown field error")
+	}
+	if _, err := NewQuery[queryItem](source).Page(PageQuery{PageSize: 2, Cursor: "!"}); err == nil {
+		t.Error("expected invalid cursor error")
+	}
+
+	result, err := NewQuery[queryItem](source).Page(PageQuery{Page: 1, PageSize: 2, OrderBy: "Score"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewQuery[queryItem](source).Page(PageQuery{PageSize: 2, OrderBy: "Score", Desc: true, Cursor: result.NextCursor}); err == nil {
+		t.Error("expected cursor order mismatch error")
+	}
+}
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"sort"
+	"sync"
+)
+
+// prefixNode 前缀树节点
+type prefixNode[T any] struct {
+	children map[byte]*prefixNode[T]
+	objs     []*T // key等于当前路径的对象, 按加入顺序
+}
+
+// PrefixIndex 字符串字段的并发前缀索引, 零值可用. 对象加入和移出管理类时调用Insert和Remove, 修改字段后重新Insert
+type PrefixIndex[T any] struct {
+	mutex sync.RWMutex
+	root  prefixNode[T]
+	keys  map[*T]string // 对象 -> 当前key, 对象修改后按旧key删除
+}
+
+// Insert 按key加入对象, 对象已经存在时先按旧key删除
+func (p *PrefixIndex[T]) Insert(key string, obj *T) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if old, ok := p.keys[obj]; ok {
+		if old == key {
+			return
+		}
+		p.remove(old, obj)
+	}
+	if p.keys == nil {
+		p.keys = make(map[*T]string)
+	}
+	p.keys[obj] = key
+	node := &p.root
+	for i := 0; i < len(key); i++ {
+		child, ok := node.children[key[i]]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[byte]*prefixNode[T])
+			}
+			child = &prefixNode[T]{}
+			node.children[key[i]] = child
+		}
+		node = child
+	}
+	node.objs = append(node.objs, obj)
+}
+
+// Remove 移出对象
+func (p *PrefixIndex[T]) Remove(obj *T) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if key, ok := p.keys[obj]; ok {
+		delete(p.keys, obj)
+		p.remove(key, obj)
+	}
+}
+
+// remove 从key路径删除对象并回收空节点
+func (p *PrefixIndex[T]) remove(key string, obj *T) {
+	path := make([]*prefixNode[T], 0, len(key)+1)
+	node := &p.root
+	path = append(path, node)
+	for i := 0; i < len(key); i++ {
+		child, ok := node.children[key[i]]
+		if !ok {
+			return
+		}
+		node = child
+		path = append(path, node)
+	}
+	for i, v := range node.objs {
+		if v == obj {
+			node.objs = append(node.objs[:i], node.objs[i+1:]...)
+			break
+		}
+	}
+	for i := len(key); i > 0; i-- {
+		node = path[i]
+		if len(node.objs) > 0 || len(node.children) > 0 {
+			break
+		}
+		delete(path[i-1].children, key[i-1])
+	}
+}
+
+// Clear 清空索引
+func (p *PrefixIndex[T]) Clear() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.root = prefixNode[T]{}
+	p.keys = nil
+}
+
+// Len 索引中的对象数量
+func (p *PrefixIndex[T]) Len() int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return len(p.keys)
+}
+
+// Search key以prefix开头的对象, 按key字典序, 相同key按加入顺序. limit<=0不限制
+func (p *PrefixIndex[T]) Search(prefix string, limit int) (list []*T) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	node := &p.root
+	for i := 0; i < len(prefix); i++ {
+		child, ok := node.children[prefix[i]]
+		if !ok {
+			return nil
+		}
+		node = child
+	}
+	var walk func(node *prefixNode[T]) bool
+	walk = func(node *prefixNode[T]) bool {
+		for _, obj := range node.objs {
+			if limit > 0 && len(list) >= limit {
+				return false
+			}
+			list = append(list, obj)
+		}
+		bytes := make([]byte, 0, len(node.children))
+		for b := range node.children {
+			bytes = append(bytes, b)
+		}
+		sort.Slice(bytes, func(i, j int) bool { return bytes[i] < bytes[j] })
+		for _, b := range bytes {
+			if !walk(node.children[b]) {
+				return false
+			}
+		}
+		return true
+	}
+	walk(node)
+	return
+}
@@ -0,0 +1,60 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/spelens-gud/persist/model"
+)
+
+func menuIds(list []*model.MenusGlobal) (ids []int64) {
+	for _, obj := range list {
+		ids = append(ids, obj.AuthId)
+	}
+	return
+}
+
+// TestPrefixIndex 测试前缀查找, 修改key和删除
+func TestPrefixIndex(t *testing.T) {
+	index := &PrefixIndex[model.MenusGlobal]{}
+	a := &model.MenusGlobal{AuthId: 1, Path: "/system/user"}
+	b := &model.MenusGlobal{AuthId: 2, Path: "/system"}
+	c := &model.MenusGlobal{AuthId: 3, Path: "/home"}
+	d := &model.MenusGlobal{AuthId: 4, Path: "/system/role"}
+	for _, obj := range []*model.MenusGlobal{a, b, c, d} {
+		index.Insert(obj.Path, obj)
+	}
+
+	// 按key字典序
+	if ids := menuIds(index.Search("/sys", 0)); !equalIds(ids, []int64{2, 4, 1}) {
+		t.Errorf("unexpected search %v", ids)
+	}
+	if ids := menuIds(index.Search("/sys", 2)); !equalIds(ids, []int64{2, 4}) {
+		t.Errorf("unexpected limit %v", ids)
+	}
+	if list := index.Search("/none", 0); len(list) != 0 {
+		t.Errorf("unexpected search %v", menuIds(list))
+	}
+
+	// 修改key后按旧key删除
+	index.Insert("/home/user", a)
+	if ids := menuIds(index.Search("/system/", 0)); !equalIds(ids, []int64{4}) {
+		t.Errorf("old key not removed %v", ids)
+	}
+	if ids := menuIds(index.Search("/home", 0)); !equalIds(ids, []int64{3, 1}) {
+		t.Errorf("new key not inserted %v", ids)
+	}
+	if index.Len() != 4 {
+		t.Errorf("unexpected len %d", index.Len())
+	}
+
+	index.Remove(c)
+	index.Remove(c)
+	if ids := menuIds(index.Search("/", 0)); !equalIds(ids, []int64{1, 2, 4}) {
+		t.Errorf("unexpected search after remove %v", ids)
+	}
+
+	index.Clear()
+	if index.Len() != 0 || len(index.Search("", 0)) != 0 {
+		t.Error("index not cleared")
+	}
+}
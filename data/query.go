@@ -0,0 +1,454 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// QueryOp 查询条件操作符
+type QueryOp string
+
+const (
+	QueryEQ QueryOp = "="
+	QueryNE QueryOp = "!="
+	QueryLT QueryOp = "<"
+	QueryLE QueryOp = "<="
+	QueryGT QueryOp = ">"
+	QueryGE QueryOp = ">="
+	QueryIN QueryOp = "in" // value为slice
+)
+
+// QuerySource 可查询的管理类, GenericManager和生成的管理类实现
+type QuerySource[T any] interface {
+	QueryPrimaryField() string                                // 主键字段名, 用于稳定排序
+	QueryRange(f func(obj *T) bool)                           // 遍历所有对象
+	QueryLookup(field string, value any) (list []*T, ok bool) // 字段等值索引查找, 没有索引返回false
+	QueryCopy(obj *T) *T                                      // 深拷贝对象
+}
+
+type queryCond struct {
+	field string
+	op    QueryOp
+	value any
+
+	index  []int           // 字段下标
+	values []reflect.Value // 转换为字段类型的值, QueryIN为多个
+}
+
+type queryOrder struct {
+	field string
+	desc  bool
+	index []int
+}
+
+// Query 内存查询, 存在等值索引时使用索引, 否则遍历所有对象. 默认返回深拷贝, Live返回内存中的对象
+type Query[T any] struct {
+	source QuerySource[T]
+	conds  []*queryCond
+	orders []*queryOrder
+	limit  int
+	offset int
+	live   bool
+}
+
+// NewQuery 创建查询
+func NewQuery[T any](source QuerySource[T]) *Query[T] {
+	return &Query[T]{source: source}
+}
+
+// Where 添加条件, 多个条件之间为and
+func (q *Query[T]) Where(field string, op QueryOp, value any) *Query[T] {
+	q.conds = append(q.conds, &queryCond{field: field, op: op, value: value})
+	return q
+}
+
+// And 同Where
+func (q *Query[T]) And(field string, op QueryOp, value any) *Query[T] {
+	return q.Where(field, op, value)
+}
+
+// OrderBy 按字段升序, 多次调用依次排序. 最后总是按主键升序
+func (q *Query[T]) OrderBy(field string) *Query[T] {
+	q.orders = append(q.orders, &queryOrder{field: field})
+	return q
+}
+
+// OrderByDesc 按字段降序
+func (q *Query[T]) OrderByDesc(field string) *Query[T] {
+	q.orders = append(q.orders, &queryOrder{field: field, desc: true})
+	return q
+}
+
+// Limit 最多返回n个, 0不限制
+func (q *Query[T]) Limit(n int) *Query[T] {
+	q.limit = n
+	return q
+}
+
+// Offset 跳过前n个
+func (q *Query[T]) Offset(n int) *Query[T] {
+	q.offset = n
+	return q
+}
+
+// Live 返回内存中的对象, 修改后必须标记写回
+func (q *Query[T]) Live() *Query[T] {
+	q.live = true
+	return q
+}
+
+// All 执行查询
+func (q *Query[T]) All() ([]*T, error) {
+	list, err := q.match()
+	if err != nil {
+		return nil, err
+	}
+	if err = q.sort(list); err != nil {
+		return nil, err
+	}
+	if q.offset > 0 {
+		if q.offset >= len(list) {
+			list = nil
+		} else {
+			list = list[q.offset:]
+		}
+	}
+	if q.limit > 0 && len(list) > q.limit {
+		list = list[:q.limit]
+	}
+	return q.result(list), nil
+}
+
+// First 第一个对象, 不存在返回nil
+func (q *Query[T]) First() (*T, error) {
+	limit := q.limit
+	q.limit = 1
+	list, err := q.All()
+	q.limit = limit
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return list[0], nil
+}
+
+// Count 满足条件的数量, 忽略Limit和Offset
+func (q *Query[T]) Count() (int, error) {
+	list, err := q.match()
+	return len(list), err
+}
+
+func (q *Query[T]) result(list []*T) []*T {
+	if q.live {
+		return list
+	}
+	ret := make([]*T, len(list))
+	for i, obj := range list {
+		ret[i] = q.source.QueryCopy(obj)
+	}
+	return ret
+}
+
+// match 满足所有条件的对象, 未排序
+func (q *Query[T]) match() (list []*T, err error) {
+	if q.source == nil {
+		return nil, errors.New("query: source is nil")
+	}
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	for _, cond := range q.conds {
+		if err = cond.prepare(typ); err != nil {
+			return nil, err
+		}
+	}
+
+	// 选择结果最少的等值索引
+	var candidates []*T
+	indexed := false
+	for _, cond := range q.conds {
+		if cond.op != QueryEQ && cond.op != QueryIN {
+			continue
+		}
+		var found []*T
+		ok := true
+		for _, value := range cond.values {
+			var part []*T
+			if part, ok = q.source.QueryLookup(cond.field, value.Interface()); !ok {
+				break
+			}
+			found = append(found, part...)
+		}
+		if ok && (!indexed || len(found) < len(candidates)) {
+			candidates, indexed = dedup(found), true
+		}
+	}
+
+	filter := func(obj *T) bool {
+		v := reflect.ValueOf(obj).Elem()
+		for _, cond := range q.conds {
+			if !cond.match(v.FieldByIndex(cond.index)) {
+				return false
+			}
+		}
+		return true
+	}
+	if indexed {
+		for _, obj := range candidates {
+			if filter(obj) {
+				list = append(list, obj)
+			}
+		}
+		return
+	}
+	q.source.QueryRange(func(obj *T) bool {
+		if filter(obj) {
+			list = append(list, obj)
+		}
+		return true
+	})
+	return
+}
+
+func (q *Query[T]) sort(list []*T) error {
+	orders, err := q.resolveOrders()
+	if err != nil {
+		return err
+	}
+	sortByOrders(orders, list)
+	return nil
+}
+
+// resolveOrders 所有排序字段, 最后为主键
+func (q *Query[T]) resolveOrders() ([]*queryOrder, error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	orders := q.orders
+	if pk := q.source.QueryPrimaryField(); pk != "" {
+		orders = append(orders[:len(orders):len(orders)], &queryOrder{field: pk})
+	}
+	for _, order := range orders {
+		field, ok := typ.FieldByName(order.field)
+		if !ok {
+			return nil, fmt.Errorf("query: unknown field %s", order.field)
+		}
+		if _, ok := compareValue(reflect.Zero(field.Type), reflect.Zero(field.Type)); !ok {
+			return nil, fmt.Errorf("query: field %s can not be ordered", order.field)
+		}
+		order.index = field.Index
+	}
+	return orders, nil
+}
+
+func sortByOrders[T any](orders []*queryOrder, list []*T) {
+	sort.SliceStable(list, func(i, j int) bool {
+		return lessByOrders(orders, reflect.ValueOf(list[i]).Elem(), reflect.ValueOf(list[j]).Elem())
+	})
+}
+
+func lessByOrders(orders []*queryOrder, a, b reflect.Value) bool {
+	for _, order := range orders {
+		c, _ := compareValue(a.FieldByIndex(order.index), b.FieldByIndex(order.index))
+		if c == 0 {
+			continue
+		}
+		if order.desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+func (c *queryCond) prepare(typ reflect.Type) error {
+	field, ok := typ.FieldByName(c.field)
+	if !ok {
+		return fmt.Errorf("query: unknown field %s", c.field)
+	}
+	if !field.Type.Comparable() {
+		return fmt.Errorf("query: field %s is not comparable", c.field)
+	}
+	c.index = field.Index
+	c.values = c.values[:0]
+	switch c.op {
+	case QueryEQ, QueryNE, QueryLT, QueryLE, QueryGT, QueryGE:
+		v, err := convertValue(field.Type, c.value)
+		if err != nil {
+			return fmt.Errorf("query: field %s: %w", c.field, err)
+		}
+		c.values = append(c.values, v)
+	case QueryIN:
+		list := reflect.ValueOf(c.value)
+		if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+			return fmt.Errorf("query: field %s: in value must be slice", c.field)
+		}
+		for i := 0; i < list.Len(); i++ {
+			v, err := convertValue(field.Type, list.Index(i).Interface())
+			if err != nil {
+				return fmt.Errorf("query: field %s: %w", c.field, err)
+			}
+			c.values = append(c.values, v)
+		}
+	default:
+		return fmt.Errorf("query: unknown op %s", c.op)
+	}
+	if c.op != QueryEQ && c.op != QueryNE && c.op != QueryIN {
+		if _, ok := compareValue(c.values[0], c.values[0]); !ok {
+			return fmt.Errorf("query: field %s can not be compared by %s", c.field, c.op)
+		}
+	}
+	return nil
+}
+
+func (c *queryCond) match(v reflect.Value) bool {
+	switch c.op {
+	case QueryEQ:
+		return v.Interface() == c.values[0].Interface()
+	case QueryNE:
+		return v.Interface() != c.values[0].Interface()
+	case QueryIN:
+		for _, value := range c.values {
+			if v.Interface() == value.Interface() {
+				return true
+			}
+		}
+		return false
+	}
+	r, _ := compareValue(v, c.values[0])
+	switch c.op {
+	case QueryLT:
+		return r < 0
+	case QueryLE:
+		return r <= 0
+	case QueryGT:
+		return r > 0
+	case QueryGE:
+		return r >= 0
+	}
+	return false
+}
+
+// convertValue 转换为字段类型, 只允许相同类型或者数值类型之间的转换
+func convertValue(typ reflect.Type, value any) (reflect.Value, error) {
+	if value == nil {
+		return reflect.Zero(typ), nil
+	}
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Type() == typ:
+		return v, nil
+	case v.Type().ConvertibleTo(typ) && (v.Kind() == typ.Kind() || isNumberKind(v.Kind()) && isNumberKind(typ.Kind())):
+		return v.Convert(typ), nil
+	default:
+		return reflect.Value{}, fmt.Errorf("type %s, got %s", typ, v.Type())
+	}
+}
+
+// compareValue 比较相同类型的值, 不支持的类型返回false
+func compareValue(a, b reflect.Value) (int, bool) {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareOrdered(a.Int(), b.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return compareOrdered(a.Uint(), b.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return compareOrdered(a.Float(), b.Float()), true
+	case reflect.String:
+		return compareOrdered(a.String(), b.String()), true
+	case reflect.Bool:
+		x, y := 0, 0
+		if a.Bool() {
+			x = 1
+		}
+		if b.Bool() {
+			y = 1
+		}
+		return compareOrdered(x, y), true
+	}
+	return 0, false
+}
+
+func compareOrdered[V int | int64 | uint64 | float64 | string](a, b V) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func dedup[T any](list []*T) []*T {
+	seen := make(map[*T]bool, len(list))
+	ret := list[:0]
+	for _, obj := range list {
+		if !seen[obj] {
+			seen[obj] = true
+			ret = append(ret, obj)
+		}
+	}
+	return ret
+}
+
+// copyObject 深拷贝, 实现CopyTo时使用CopyTo, 否则反射拷贝
+func copyObject[T any](obj *T) *T {
+	ret := new(T)
+	if v, ok := any(obj).(interface{ CopyTo(dst *T) }); ok {
+		v.CopyTo(ret)
+	} else {
+		deepCopyValue(reflect.ValueOf(ret).Elem(), reflect.ValueOf(obj).Elem())
+	}
+	return ret
+}
+
+// deepCopyValue 递归拷贝指针, slice, map, interface和导出的结构体字段, 未导出字段, chan和func按值拷贝. 不支持循环引用
+func deepCopyValue(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Pointer:
+		if src.IsNil() {
+			return
+		}
+		v := reflect.New(src.Type().Elem())
+		deepCopyValue(v.Elem(), src.Elem())
+		dst.Set(v)
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		v := reflect.New(src.Elem().Type()).Elem()
+		deepCopyValue(v, src.Elem())
+		dst.Set(v)
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+		v := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			deepCopyValue(v.Index(i), src.Index(i))
+		}
+		dst.Set(v)
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			deepCopyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		v := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			value := reflect.New(src.Type().Elem()).Elem()
+			deepCopyValue(value, iter.Value())
+			v.SetMapIndex(iter.Key(), value)
+		}
+		dst.Set(v)
+	case reflect.Struct:
+		dst.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if src.Type().Field(i).IsExported() {
+				deepCopyValue(dst.Field(i), src.Field(i))
+			}
+		}
+	default:
+		dst.Set(src)
+	}
+}
@@ -0,0 +1,176 @@
+package data
+
+import (
+	"testing"
+)
+
+type queryItem struct {
+	Id    int64
+	Type  string
+	Score float64
+	Tags  []string
+	Attrs map[string]int
+	Inner *queryItem
+}
+
+// querySource 测试用查询源, Type字段有等值索引
+type querySource struct {
+	list    []*queryItem
+	lookups int
+}
+
+func (s *querySource) QueryPrimaryField() string { return "Id" }
+
+func (s *querySource) QueryRange(f func(obj *queryItem) bool) {
+	for _, obj := range s.list {
+		if !f(obj) {
+			return
+		}
+	}
+}
+
+func (s *querySource) QueryLookup(field string, value any) ([]*queryItem, bool) {
+	if field != "Type" {
+		return nil, false
+	}
+	s.lookups++
+	var list []*queryItem
+	for _, obj := range s.list {
+		if obj.Type == value {
+			list = append(list, obj)
+		}
+	}
+	return list, true
+}
+
+func (s *querySource) QueryCopy(obj *queryItem) *queryItem { return copyObject(obj) }
+
+func newQuerySource() *querySource {
+	return &querySource{list: []*queryItem{
+		{Id: 3, Type: "menu", Score: 1.5},
+		{Id: 1, Type: "menu", Score: 3},
+		{Id: 2, Type: "button", Score: 2},
+		{Id: 5, Type: "menu", Score: 3},
+		{Id: 4, Type: "button", Score: 0.5},
+	}}
+}
+
+func queryIds(list []*queryItem) (ids []int64) {
+	for _, obj := range list {
+		ids = append(ids, obj.Id)
+	}
+	return
+}
+
+func equalIds(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestQueryWhereOrder 测试条件, 排序和分段
+func TestQueryWhereOrder(t *testing.T) {
+	source := newQuerySource()
+
+	list, err := NewQuery[queryItem](source).Where("Score", QueryGE, 1).OrderByDesc("Score").All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 相同Score按主键升序
+	if ids := queryIds(list); !equalIds(ids, []int64{1, 5, 2, 3}) {
+		t.Errorf("unexpected order %v", ids)
+	}
+
+	list, err = NewQuery[queryItem](source).Where("Id", QueryIN, []int{1, 2, 4}).Offset(1).Limit(1).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := queryIds(list); !equalIds(ids, []int64{2}) {
+		t.Errorf("unexpected page %v", ids)
+	}
+
+	if n, err := NewQuery[queryItem](source).Where("Type", QueryNE, "menu").Limit(1).Count(); err != nil || n != 2 {
+		t.Errorf("unexpected count %d %v", n, err)
+	}
+
+	first, err := NewQuery[queryItem](source).Where("Id", QueryGT, 100).First()
+	if err != nil || first != nil {
+		t.Errorf("unexpected first %v %v", first, err)
+	}
+}
+
+// TestQueryIndex 测试使用等值索引
+func TestQueryIndex(t *testing.T) {
+	source := newQuerySource()
+
+	list, err := NewQuery[queryItem](source).Where("Type", QueryEQ, "button").And("Score", QueryLT, 1).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := queryIds(list); !equalIds(ids, []int64{4}) {
+		t.Errorf("unexpected result %v", ids)
+	}
+	if source.lookups != 1 {
+		t.Errorf("expected index lookup, got %d", source.lookups)
+	}
+}
+
+// TestQueryCopy 测试默认返回深拷贝, Live返回内存对象
+func TestQueryCopy(t *testing.T) {
+	source := newQuerySource()
+	source.list[0].Tags = []string{"a"}
+	source.list[0].Attrs = map[string]int{"a": 1}
+	source.list[0].Inner = &queryItem{Id: 100}
+
+	obj, err := NewQuery[queryItem](source).Where("Id", QueryEQ, 3).First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj == source.list[0] {
+		t.Fatal("expected copy")
+	}
+	obj.Tags[0] = "b"
+	obj.Attrs["a"] = 2
+	obj.Inner.Id = 200
+	if source.list[0].Tags[0] != "a" || source.list[0].Attrs["a"] != 1 || source.list[0].Inner.Id != 100 {
+		t.Error("copy shares memory with stored object")
+	}
+
+	obj, err = NewQuery[queryItem](source).Live().Where("Id", QueryEQ, 3).First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj != source.list[0] {
+		t.Error("expected stored object")
+	}
+}
+
+// TestQueryError 测试错误的条件和排序
+func TestQueryError(t *testing.T) {
+	source := newQuerySource()
+
+	if _, err := NewQuery[queryItem](source).Where("Unknown", QueryEQ, 1).All(); err == nil {
+		t.Error("expected unknown field error")
+	}
+	if _, err := NewQuery[queryItem](source).Where("Type", QueryEQ, 1).All(); err == nil {
+		t.Error("expected type mismatch error")
+	}
+	if _, err := NewQuery[queryItem](source).Where("Id", QueryIN, 1).All(); err == nil {
+		t.Error("expected in value error")
+	}
+	if _, err := NewQuery[queryItem](source).Where("Tags", QueryEQ, nil).All(); err == nil {
+		t.Error("expected not comparable error")
+	}
+	if _, err := NewQuery[queryItem](source).OrderBy("Attrs").All(); err == nil {
+		t.Error("expected order error")
+	}
+	if _, err := NewQuery[queryItem](nil).All(); err == nil {
+		t.Error("expected nil source error")
+	}
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	persistCore "github.com/spelens-gud/persist/core"
+	"github.com/spelens-gud/persist/model"
+	"xorm.io/xorm"
+)
+
+// TestUserShareReplicaPolicy 测试按策略选择按key导入和全导入的数据库
+func TestUserShareReplicaPolicy(t *testing.T) {
+	primary, replica := new(xorm.Engine), new(xorm.Engine)
+	pk := &UserShareUid{Uid: 1}
+
+	m := NewUserShareManager(nil)
+	m.engine = primary
+	if m.readEngine(nil) != primary || m.readEngine(pk) != primary {
+		t.Error("read replica without replica")
+	}
+
+	m.SetReplica(replica, persistCore.ReplicaPolicy{})
+	if m.readEngine(nil) != replica || m.readEngine(pk) != primary {
+		t.Error("unexpected primary mode")
+	}
+	m.markWrite(*pk)
+	if _, ok := m.lastWriteMap.Load(*pk); ok {
+		t.Error("write marked in primary mode")
+	}
+
+	m.SetReplica(replica, persistCore.ReplicaPolicy{Mode: persistCore.EReplicaModeReplica})
+	if m.readEngine(pk) != replica {
+		t.Error("unexpected replica mode")
+	}
+
+	// 最近写回过的key读主库, 超过MaxStaleness后读从库并删除记录
+	m.SetReplica(replica, persistCore.ReplicaPolicy{Mode: persistCore.EReplicaModeStaleness, MaxStaleness: time.Minute})
+	if m.readEngine(pk) != replica {
+		t.Error("unwritten key read primary")
+	}
+	m.markWrite(*pk)
+	if m.readEngine(pk) != primary {
+		t.Error("written key read replica")
+	}
+	m.lastWriteMap.Store(*pk, time.Now().Add(-2*time.Minute))
+	if m.readEngine(pk) != replica {
+		t.Error("stale key read primary")
+	}
+	if _, ok := m.lastWriteMap.Load(*pk); ok {
+		t.Error("stale write not deleted")
+	}
+}
+
+// TestUserSharePruneWrite 测试清理超过MaxStaleness的写回时间, 只写不读的key不会一直保留
+func TestUserSharePruneWrite(t *testing.T) {
+	m := NewUserShareManager(nil)
+	m.SetReplica(new(xorm.Engine), persistCore.ReplicaPolicy{Mode: persistCore.EReplicaModeStaleness, MaxStaleness: time.Minute})
+	m.lastWriteMap.Store(UserShareUid{Uid: 1}, time.Now().Add(-2*time.Minute))
+	m.markWrite(UserShareUid{Uid: 2})
+
+	m.pruneWrite()
+	if _, ok := m.lastWriteMap.Load(UserShareUid{Uid: 1}); ok {
+		t.Error("stale write not pruned")
+	}
+	if _, ok := m.lastWriteMap.Load(UserShareUid{Uid: 2}); !ok {
+		t.Error("fresh write pruned")
+	}
+
+	// MaxStaleness内不重复清理
+	m.lastWriteMap.Store(UserShareUid{Uid: 1}, time.Now().Add(-2*time.Minute))
+	m.pruneWrite()
+	if _, ok := m.lastWriteMap.Load(UserShareUid{Uid: 1}); !ok {
+		t.Error("pruned within MaxStaleness")
+	}
+}
+
+// TestUserShareReplayReadPrimary 测试重放bomb数据后MaxStaleness内全导入读主库
+func TestUserShareReplayReadPrimary(t *testing.T) {
+	engine, _ := newUserShareTestEngine(t)
+	replica := new(xorm.Engine)
+	m := NewUserShareManager(engine)
+	m.SetReplica(replica, persistCore.ReplicaPolicy{MaxStaleness: time.Minute})
+	if m.readEngine(nil) != replica {
+		t.Fatal("load all read primary before replay")
+	}
+
+	session := engine.NewSession()
+	defer session.Close()
+	persistSync := &UserShareSync{Data: &model.UserShare{Uid: 1, UserName: "a"}, Op: EUserShareOpInsert, BitSet: m.bitSetAll}
+	if err := m.replayDB(session, persistSync); err != nil {
+		t.Fatal(err)
+	}
+	if m.readEngine(nil) != engine {
+		t.Error("load all read replica after replay")
+	}
+	// 重放已经写入的插入改为更新
+	persistSync.Data.UserName = "b"
+	if err := m.replayDB(session, persistSync); err != nil {
+		t.Fatal(err)
+	}
+	cls := &model.UserShare{Uid: 1}
+	if has, err := engine.Get(cls); err != nil || !has || cls.UserName != "b" {
+		t.Errorf("unexpected row %v %v %q", has, err, cls.UserName)
+	}
+}
@@ -0,0 +1,246 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// runtimeIndex 运行时添加的索引
+type runtimeIndex[T any] struct {
+	field  string // 字段索引的字段名, 计算key索引为空
+	unique bool
+	key    func(obj *T) any
+
+	single SyncMap[any, *T]                 // 唯一索引 key -> 对象
+	multi  SyncMap[any, *SyncMap[*T, bool]] // 非唯一索引 key -> 对象集合
+	keys   SyncMap[*T, any]                 // 对象 -> 当前key, 对象修改后按旧key删除
+}
+
+func (idx *runtimeIndex[T]) insert(obj *T) {
+	k := idx.key(obj)
+	idx.keys.Store(obj, k)
+	if idx.unique {
+		idx.single.Store(k, obj)
+		return
+	}
+	set, _ := idx.multi.LoadOrStore(k, &SyncMap[*T, bool]{})
+	set.Store(obj, true)
+}
+
+func (idx *runtimeIndex[T]) remove(obj *T) {
+	k, ok := idx.keys.LoadAndDelete(obj)
+	if !ok {
+		return
+	}
+	if idx.unique {
+		idx.single.CompareAndDelete(k, obj)
+		return
+	}
+	if set, ok := idx.multi.Load(k); ok {
+		set.Delete(obj)
+		has := false
+		set.Range(func(*T, bool) bool {
+			has = true
+			return false
+		})
+		if !has {
+			idx.multi.CompareAndDelete(k, set)
+		}
+	}
+}
+
+// RuntimeIndexes 运行时添加的索引集合, 零值可用. 对象加入和移出管理类时调用Insert和Remove, 修改索引字段时使用Update
+type RuntimeIndexes[T any] struct {
+	mutex   sync.Mutex                                  // 添加索引互斥
+	indexes atomic.Pointer[map[string]*runtimeIndex[T]] // 写时复制
+}
+
+// Add 添加索引并用rangeAll遍历的现有对象建立索引. 建立期间并发删除的对象可能残留在索引中, 建议在修改数据之前添加
+func (r *RuntimeIndexes[T]) Add(name string, unique bool, key func(obj *T) any, rangeAll func(f func(obj *T) bool)) error {
+	return r.add(name, "", unique, key, rangeAll)
+}
+
+// AddField 添加字段索引, 索引名为字段名, 可用于Query等值查询
+func (r *RuntimeIndexes[T]) AddField(field string, unique bool, rangeAll func(f func(obj *T) bool)) error {
+	key, err := FieldIndexFunc[T](field)
+	if err != nil {
+		return err
+	}
+	return r.add(field, field, unique, key, rangeAll)
+}
+
+func (r *RuntimeIndexes[T]) add(name, field string, unique bool, key func(obj *T) any, rangeAll func(f func(obj *T) bool)) error {
+	if key == nil {
+		return errors.New("runtime index: key func is nil")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	old := r.load()
+	if _, ok := old[name]; ok {
+		return errors.New("runtime index: repeated index " + name)
+	}
+	idx := &runtimeIndex[T]{field: field, unique: unique, key: key}
+	indexes := make(map[string]*runtimeIndex[T], len(old)+1)
+	for k, v := range old {
+		indexes[k] = v
+	}
+	indexes[name] = idx
+	// 先发布再建立, 期间新加入的对象由Insert写入索引
+	r.indexes.Store(&indexes)
+	if rangeAll != nil {
+		rangeAll(func(obj *T) bool {
+			idx.insert(obj)
+			return true
+		})
+	}
+	return nil
+}
+
+func (r *RuntimeIndexes[T]) load() map[string]*runtimeIndex[T] {
+	if indexes := r.indexes.Load(); indexes != nil {
+		return *indexes
+	}
+	return nil
+}
+
+// Has 是否存在索引
+func (r *RuntimeIndexes[T]) Has(name string) bool {
+	_, ok := r.load()[name]
+	return ok
+}
+
+// Insert 对象加入所有索引
+func (r *RuntimeIndexes[T]) Insert(obj *T) {
+	for _, idx := range r.load() {
+		idx.insert(obj)
+	}
+}
+
+// Remove 对象移出所有索引
+func (r *RuntimeIndexes[T]) Remove(obj *T) {
+	for _, idx := range r.load() {
+		idx.remove(obj)
+	}
+}
+
+// Update 执行修改并按新的key重建对象的索引
+func (r *RuntimeIndexes[T]) Update(obj *T, apply func() error) (err error) {
+	r.Remove(obj)
+	defer r.Insert(obj)
+	return apply()
+}
+
+// Clear 清空所有索引数据, 保留索引定义
+func (r *RuntimeIndexes[T]) Clear() {
+	for _, idx := range r.load() {
+		idx.single.Clear()
+		idx.multi.Clear()
+		idx.keys.Clear()
+	}
+}
+
+// Get 唯一索引查找, 非唯一索引返回任意一个
+func (r *RuntimeIndexes[T]) Get(name string, key any) (obj *T, ok bool) {
+	idx, exist := r.load()[name]
+	if !exist {
+		return nil, false
+	}
+	if idx.unique {
+		return idx.single.Load(key)
+	}
+	if set, exist := idx.multi.Load(key); exist {
+		set.Range(func(k *T, _ bool) bool {
+			obj, ok = k, true
+			return false
+		})
+	}
+	return
+}
+
+// GetAll 索引查找所有对象
+func (r *RuntimeIndexes[T]) GetAll(name string, key any) (list []*T) {
+	idx, exist := r.load()[name]
+	if !exist {
+		return nil
+	}
+	if idx.unique {
+		if obj, ok := idx.single.Load(key); ok {
+			list = append(list, obj)
+		}
+		return
+	}
+	if set, ok := idx.multi.Load(key); ok {
+		set.Range(func(obj *T, _ bool) bool {
+			list = append(list, obj)
+			return true
+		})
+	}
+	return
+}
+
+// LookupField 字段索引查找, 没有该字段的索引返回false
+func (r *RuntimeIndexes[T]) LookupField(field string, value any) ([]*T, bool) {
+	idx, exist := r.load()[field]
+	if !exist || idx.field != field {
+		return nil, false
+	}
+	return r.GetAll(field, value), true
+}
+
+// IndexFunc 把返回K类型key的函数转换为索引key函数
+func IndexFunc[T any, K comparable](key func(obj *T) K) func(obj *T) any {
+	return func(obj *T) any {
+		return key(obj)
+	}
+}
+
+// FieldIndexFunc 按字段值索引的key函数
+func FieldIndexFunc[T any](field string) (func(obj *T) any, error) {
+	var t T
+	sf, ok := reflect.TypeOf(t).FieldByName(field)
+	if !ok {
+		return nil, fmt.Errorf("runtime index: unknown field %s", field)
+	}
+	if !sf.Type.Comparable() {
+		return nil, fmt.Errorf("runtime index: field %s is not comparable", field)
+	}
+	index := sf.Index
+	return func(obj *T) any {
+		return reflect.ValueOf(obj).Elem().FieldByIndex(index).Interface()
+	}, nil
+}
+
+// setStructField 通过反射修改字段, 只允许相同类型或者数值类型之间的转换
+func setStructField[T any](obj *T, field string, value any) error {
+	f := reflect.ValueOf(obj).Elem().FieldByName(field)
+	if !f.IsValid() || !f.CanSet() {
+		return fmt.Errorf("unknown field %s", field)
+	}
+	if value == nil {
+		f.Set(reflect.Zero(f.Type()))
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Type().AssignableTo(f.Type()):
+		f.Set(v)
+	case isNumberKind(v.Kind()) && isNumberKind(f.Kind()):
+		f.Set(v.Convert(f.Type()))
+	default:
+		return fmt.Errorf("field %s type %s, got %s", field, f.Type(), v.Type())
+	}
+	return nil
+}
+
+func isNumberKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"errors"
+	"testing"
+
+	persistCore "github.com/spelens-gud/persist/core"
+	"github.com/spelens-gud/persist/model"
+)
+
+// TestUserShareRuntimeIndex 测试运行时索引用现有对象建立, 由SetField和SetIndexKey*维护
+func TestUserShareRuntimeIndex(t *testing.T) {
+	engine, _ := newUserShareTestEngine(t)
+	m := NewUserShareManager(engine)
+	insertUserShareRows(t, m, 3)
+	if err := m.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	// 修改进入写回队列, 检查修改的字段
+	expectSync := func(fieldIndex UserShareFieldIndex) {
+		t.Helper()
+		if persistSync := <-m.syncChan; persistSync.Op != EUserShareOpUpdate || !persistSync.BitSet.Get(fieldIndex) {
+			t.Errorf("unexpected sync %d", persistSync.Op)
+		}
+	}
+
+	if err := m.AddIndex("DeptId", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddIndex("Status", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddIndexFunc("NickLen", true, IndexFunc(func(cls *model.UserShare) int { return len(cls.NickName) })); err != nil {
+		t.Fatal(err)
+	}
+	if m.AddIndex("DeptId", false) == nil || m.AddIndex("None", false) == nil {
+		t.Error("expected add index error")
+	}
+	if list := m.GetUserSharesByIndex("DeptId", int64(0)); len(list) != 3 {
+		t.Fatalf("existing objects not indexed %d", len(list))
+	}
+
+	cls := m.GetUserShareByUid(1)
+	// 数值类型转换为字段类型
+	if err := m.SetField(cls, "DeptId", 7); err != nil {
+		t.Fatal(err)
+	}
+	expectSync(EUserShareFieldIndexDeptId)
+	if list := m.GetUserSharesByIndex("DeptId", int64(7)); len(list) != 1 || list[0] != cls || cls.DeptId != 7 {
+		t.Errorf("unexpected index after SetField %v", list)
+	}
+	if list := m.GetUserSharesByIndex("DeptId", int64(0)); len(list) != 2 {
+		t.Errorf("old key not removed %d", len(list))
+	}
+	if list, ok := m.QueryLookup("DeptId", int64(7)); !ok || len(list) != 1 {
+		t.Error("query lookup not using runtime index")
+	}
+	if err := m.SetField(cls, "NickName", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	expectSync(EUserShareFieldIndexNickName)
+	if m.GetUserShareByIndex("NickLen", 3) != cls {
+		t.Error("func index not updated")
+	}
+
+	if err := m.SetIndexKeyStatus(cls, 5); err != nil {
+		t.Fatal(err)
+	}
+	expectSync(EUserShareFieldIndexStatus)
+	if list := m.GetUserSharesByIndex("Status", int64(5)); len(list) != 1 || list[0] != cls {
+		t.Errorf("unexpected index after SetIndexKeyStatus %v", list)
+	}
+
+	// 失败时不修改对象和索引
+	for field, value := range map[string]any{"None": 1, "Mobile": "x", "NickName": 1} {
+		if err := m.SetField(cls, field, value); err == nil {
+			t.Errorf("%s: expected error", field)
+		}
+	}
+	if err := m.SetField(&model.UserShare{Uid: 1}, "DeptId", 8); !errors.Is(err, persistCore.EPersistErrorOutOfDate) {
+		t.Errorf("unexpected unstored error %v", err)
+	}
+	if cls.NickName != "abc" || m.GetUserShareByIndex("DeptId", int64(8)) != nil || len(m.syncChan) != 0 {
+		t.Error("object changed by failed SetField")
+	}
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	persistCore "github.com/spelens-gud/persist/core"
+	"github.com/spelens-gud/persist/model"
+)
+
+// TestUserShareSegmentationRows 测试按行数切换分表, 全导入查询当前和上一个分表, 同一主键以当前分表为准
+func TestUserShareSegmentationRows(t *testing.T) {
+	engine, _ := newUserShareTestEngine(t)
+	config := persistCore.SegmentationConfig{Mode: persistCore.ESegmentationModeRows, MaxRows: 2}
+	m := NewUserShareManager(engine)
+	if err := m.SetSegmentation(config); err != nil {
+		t.Fatal(err)
+	}
+	if m.TableName() != "user_share" {
+		t.Fatalf("unexpected table %s", m.TableName())
+	}
+
+	segmentation := func() {
+		t.Helper()
+		var wg sync.WaitGroup
+		wg.Add(1)
+		if err := m.Segmentation(&wg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 未达到行数不切换
+	if _, err := engine.Insert(&model.UserShare{Uid: 1, UserName: "old", Mobile: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	segmentation()
+	if m.TableName() != "user_share" {
+		t.Fatalf("switched before max rows %s", m.TableName())
+	}
+	if _, err := engine.Insert(&model.UserShare{Uid: 2, UserName: "b", Mobile: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	segmentation()
+	if m.TableName() != "user_share_1" {
+		t.Fatalf("unexpected table after segmentation %s", m.TableName())
+	}
+	if _, err := engine.Table("user_share_1").Insert(&model.UserShare{Uid: 1, UserName: "new", Mobile: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Table("user_share_1").Insert(&model.UserShare{Uid: 3, UserName: "c", Mobile: "3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.GetAll()) != 3 || m.GetUserShareByUid(1).UserName != "new" {
+		t.Errorf("unexpected objects %d", len(m.GetAll()))
+	}
+	for Uid, table := range map[int64]string{1: "user_share_1", 2: "user_share", 3: "user_share_1", 4: "user_share_1"} {
+		if segment := m.segmentTable(UserShareUid{Uid: Uid}); segment != table {
+			t.Errorf("%d: unexpected segment table %s", Uid, segment)
+		}
+	}
+
+	// 重新设置时从最后一张已存在的表开始
+	m2 := NewUserShareManager(engine)
+	if err := m2.SetSegmentation(config); err != nil {
+		t.Fatal(err)
+	}
+	if prev, _ := m2.prevTableName.Load().(string); m2.TableName() != "user_share_1" || prev != "user_share" {
+		t.Errorf("unexpected tables %s %s", m2.TableName(), prev)
+	}
+	if err := m2.SetSegmentation(persistCore.SegmentationConfig{}); err != nil || m2.TableName() != "" {
+		t.Errorf("segmentation not disabled %v %s", err, m2.TableName())
+	}
+}
+
+// TestUserShareSegmentationDaily 测试按天分表创建当天的表, 不能和分片同时使用
+func TestUserShareSegmentationDaily(t *testing.T) {
+	engine, _ := newUserShareTestEngine(t)
+	m := NewUserShareManager(engine)
+	if err := m.SetSegmentation(persistCore.SegmentationConfig{Mode: persistCore.ESegmentationModeDaily}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	table := "user_share_" + now.Format("20060102")
+	if prev, _ := m.prevTableName.Load().(string); m.TableName() != table || prev != "user_share_"+now.AddDate(0, 0, -1).Format("20060102") {
+		t.Errorf("unexpected tables %s %s", m.TableName(), prev)
+	}
+	if exist, err := engine.IsTableExist(table); err != nil || !exist {
+		t.Errorf("table not created %v %v", exist, err)
+	}
+	if err := m.SetShardRouter(userShareParityRouter{{Engine: engine}, {Engine: engine}}); err == nil {
+		t.Error("shard router set with segmentation")
+	}
+}
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"fmt"
+
+	"xorm.io/xorm"
+)
+
+// Shard 分片, 数据所在的数据库和表名
+type Shard struct {
+	Engine *xorm.Engine
+	Table  string
+}
+
+// ShardRouter 按主键路由到分片, 实现必须支持并发调用
+type ShardRouter[K any] interface {
+	Route(pk K) Shard // 主键所在分片
+	Shards() []Shard  // 所有分片, 用于建表和全导入
+}
+
+// HashShardRouter 按主键哈希路由. 表名为 base_00 ... base_{n-1}, 按顺序平均分布在多个数据库
+// 例如16张表2个数据库: user_share_00..07 在第一个数据库, user_share_08..15 在第二个数据库
+type HashShardRouter[K any] struct {
+	shards []Shard
+	hash   func(pk K) uint64
+}
+
+// NewHashShardRouter 创建哈希分片路由, hash为主键哈希函数
+func NewHashShardRouter[K any](base string, tableCount int, engines []*xorm.Engine, hash func(pk K) uint64) *HashShardRouter[K] {
+	if tableCount <= 0 || len(engines) == 0 || hash == nil {
+		panic("NewHashShardRouter: invalid argument")
+	}
+	r := &HashShardRouter[K]{hash: hash}
+	for i := 0; i < tableCount; i++ {
+		r.shards = append(r.shards, Shard{
+			Engine: engines[i*len(engines)/tableCount],
+			Table:  fmt.Sprintf("%s_%02d", base, i),
+		})
+	}
+	return r
+}
+
+// Route 主键所在分片
+func (r *HashShardRouter[K]) Route(pk K) Shard {
+	return r.shards[r.hash(pk)%uint64(len(r.shards))]
+}
+
+// Shards 所有分片
+func (r *HashShardRouter[K]) Shards() []Shard {
+	return r.shards
+}
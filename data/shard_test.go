@@ -0,0 +1,91 @@
+package data
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/spelens-gud/persist/model"
+	"xorm.io/xorm"
+)
+
+// userShareParityRouter 偶数uid在第一个数据库, 奇数uid在第二个数据库, 两个分片表名相同
+type userShareParityRouter []Shard
+
+func (r userShareParityRouter) Route(pk UserShareUid) Shard { return r[pk.Uid%2] }
+
+func (r userShareParityRouter) Shards() []Shard { return r }
+
+// TestHashShardRouter 测试表名和数据库平均分布
+func TestHashShardRouter(t *testing.T) {
+	engines := []*xorm.Engine{new(xorm.Engine), new(xorm.Engine)}
+	r := NewHashShardRouter[int64]("user_share", 4, engines, func(pk int64) uint64 { return uint64(pk) })
+
+	shards := r.Shards()
+	if len(shards) != 4 || shards[0].Table != "user_share_00" || shards[3].Table != "user_share_03" {
+		t.Fatalf("unexpected shards %v", shards)
+	}
+	for i, shard := range shards {
+		if shard.Engine != engines[i/2] {
+			t.Errorf("shard %d on wrong engine", i)
+		}
+	}
+	if shard := r.Route(6); shard != shards[2] {
+		t.Errorf("unexpected route %v", shard)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	NewHashShardRouter[int64]("user_share", 0, engines, nil)
+}
+
+// TestUserShareShardInsert 测试批量插入按数据库和表名分组, 任一数据库失败时全部回滚, 单条插入时已存在的数据改为更新
+func TestUserShareShardInsert(t *testing.T) {
+	t.Chdir(t.TempDir())
+	engine1, _ := newUserShareTestEngine(t)
+	engine2, _ := newUserShareTestEngine(t)
+	router := userShareParityRouter{{Engine: engine1, Table: "user_share_shard"}, {Engine: engine2, Table: "user_share_shard"}}
+	m := NewUserShareManager(engine1)
+	if err := m.SetShardRouter(router); err != nil {
+		t.Fatal(err)
+	}
+	// 主库分片已经存在uid 2, 批量插入失败
+	if _, err := engine1.Table("user_share_shard").Insert(&model.UserShare{Uid: 2, UserName: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	for uid, name := range map[int64]string{1: "a", 2: "b", 3: "c"} {
+		m.SetLoadState2Memory(uid)
+		if _, err := m.NewUserShare(&model.UserShare{Uid: uid, UserName: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.ExitContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if stat := m.QueueStat(); stat.Fail != 0 || stat.Insert != 0 {
+		t.Errorf("unexpected queue %+v", stat)
+	}
+	if _, err := os.Stat("_./_Users_xt_go_pumppill_data/UserShare.bomb"); !os.IsNotExist(err) {
+		t.Error("unexpected bomb file")
+	}
+	for uid, name := range map[int64]string{1: "a", 2: "b", 3: "c"} {
+		shard := router.Route(UserShareUid{Uid: uid})
+		cls := &model.UserShare{Uid: uid}
+		has, err := shard.Engine.Table(shard.Table).Get(cls)
+		if err != nil || !has || cls.UserName != name {
+			t.Errorf("uid %d: unexpected row %v %v %q", uid, has, err, cls.UserName)
+		}
+		other := router[1-uid%2]
+		if has, _ = other.Engine.Table(other.Table).Exist(&model.UserShare{Uid: uid}); has {
+			t.Errorf("uid %d written to wrong engine", uid)
+		}
+	}
+}
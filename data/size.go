@@ -0,0 +1,64 @@
+package data
+
+import (
+	"reflect"
+)
+
+// EstimateSize 估算对象占用内存字节数, 包括字符串, 切片, map和指针指向的数据. 同一个指针只计算一次, 不计算map和分配器的额外开销
+func EstimateSize(obj any) int64 {
+	if obj == nil {
+		return 0
+	}
+	v := reflect.ValueOf(obj)
+	seen := map[uintptr]bool{}
+	return int64(v.Type().Size()) + indirectSize(v, seen)
+}
+
+// indirectSize 值之外间接引用的字节数
+func indirectSize(v reflect.Value, seen map[uintptr]bool) (size int64) {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Pointer:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		return int64(v.Type().Elem().Size()) + indirectSize(v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		elem := v.Elem()
+		return int64(elem.Type().Size()) + indirectSize(elem, seen)
+	case reflect.Slice:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		size = int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			size += indirectSize(v.Index(i), seen)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			size += indirectSize(v.Index(i), seen)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			size += indirectSize(v.Field(i), seen)
+		}
+	case reflect.Map:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		entry := int64(v.Type().Key().Size() + v.Type().Elem().Size())
+		iter := v.MapRange()
+		for iter.Next() {
+			size += entry + indirectSize(iter.Key(), seen) + indirectSize(iter.Value(), seen)
+		}
+	default:
+	}
+	return
+}
@@ -0,0 +1,23 @@
+package data
+
+import "testing"
+
+// TestEstimateSize 测试估算字符串, 切片, map和指针引用的数据, 同一个指针只计算一次
+func TestEstimateSize(t *testing.T) {
+	empty := EstimateSize(&queryItem{})
+	if empty <= 8 {
+		t.Fatalf("unexpected empty size %d", empty)
+	}
+	item := &queryItem{Type: "menu", Tags: make([]string, 1, 2)}
+	item.Tags[0] = "ab"
+	if size := EstimateSize(item); size != empty+4+2*16+2 {
+		t.Errorf("unexpected size %d", size)
+	}
+	item.Inner = item
+	if size := EstimateSize(item); size != empty+4+2*16+2 {
+		t.Errorf("unexpected size with cycle %d", size)
+	}
+	if EstimateSize(nil) != 0 {
+		t.Error("unexpected nil size")
+	}
+}
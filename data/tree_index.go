@@ -0,0 +1,145 @@
+package data
+
+import (
+	"fmt"
+	"sync"
+)
+
+// TreeIndex 按父节点字段的树索引, 零值可用. 对象加入和移出管理类时调用Insert和Remove, 修改父节点后重新Insert
+// 父节点不在索引中的节点为根节点, 节点移出后子节点保留, 重新加入时恢复
+type TreeIndex[T any, K comparable] struct {
+	mutex    sync.RWMutex
+	nodes    map[K]*T
+	parents  map[K]K   // 节点 -> 当前父节点
+	children map[K][]K // 父节点 -> 子节点, 按加入顺序
+}
+
+// Insert 加入节点, 节点已经存在时移到新的父节点下
+func (t *TreeIndex[T, K]) Insert(key, parent K, obj *T) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.nodes == nil {
+		t.nodes = make(map[K]*T)
+		t.parents = make(map[K]K)
+		t.children = make(map[K][]K)
+	}
+	if old, ok := t.parents[key]; ok {
+		if old == parent {
+			t.nodes[key] = obj
+			return
+		}
+		t.detach(key, old)
+	}
+	t.nodes[key] = obj
+	t.parents[key] = parent
+	t.children[parent] = append(t.children[parent], key)
+}
+
+// Remove 移出节点
+func (t *TreeIndex[T, K]) Remove(key K) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if parent, ok := t.parents[key]; ok {
+		t.detach(key, parent)
+		delete(t.parents, key)
+		delete(t.nodes, key)
+	}
+}
+
+func (t *TreeIndex[T, K]) detach(key, parent K) {
+	list := t.children[parent]
+	for i, k := range list {
+		if k == key {
+			list = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(t.children, parent)
+	} else {
+		t.children[parent] = list
+	}
+}
+
+// Clear 清空索引
+func (t *TreeIndex[T, K]) Clear() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.nodes, t.parents, t.children = nil, nil, nil
+}
+
+// Children 直接子节点, 按加入顺序
+func (t *TreeIndex[T, K]) Children(key K) (list []*T) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	for _, k := range t.children[key] {
+		list = append(list, t.nodes[k])
+	}
+	return
+}
+
+// Descendants 所有子孙节点, 按层序, 父节点在子节点之前
+func (t *TreeIndex[T, K]) Descendants(key K) (list []*T) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	visited := map[K]bool{key: true}
+	queue := append([]K(nil), t.children[key]...)
+	for len(queue) > 0 {
+		k := queue[0]
+		queue = queue[1:]
+		if visited[k] {
+			continue
+		}
+		visited[k] = true
+		list = append(list, t.nodes[k])
+		queue = append(queue, t.children[k]...)
+	}
+	return
+}
+
+// Ancestors 所有祖先节点, 从父节点到根节点
+func (t *TreeIndex[T, K]) Ancestors(key K) (list []*T) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	visited := map[K]bool{key: true}
+	for {
+		parent, ok := t.parents[key]
+		if !ok || visited[parent] {
+			return
+		}
+		obj, ok := t.nodes[parent]
+		if !ok {
+			return
+		}
+		visited[parent] = true
+		list = append(list, obj)
+		key = parent
+	}
+}
+
+// IsDescendant key是否为ancestor本身或其子孙节点
+func (t *TreeIndex[T, K]) IsDescendant(key, ancestor K) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	visited := map[K]bool{}
+	for !visited[key] {
+		if key == ancestor {
+			return true
+		}
+		visited[key] = true
+		parent, ok := t.parents[key]
+		if !ok {
+			return false
+		}
+		key = parent
+	}
+	return false
+}
+
+// JoinTreePath 物化路径, 父节点路径加父节点key, 逗号分隔. 父节点路径为空时只有父节点key, 如ParentId为0的根节点为"0"
+func JoinTreePath(parentPath string, parent any) string {
+	if parentPath == "" {
+		return fmt.Sprint(parent)
+	}
+	return parentPath + "," + fmt.Sprint(parent)
+}
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/spelens-gud/persist/model"
+)
+
+func newTreeIndex(list ...*model.MenusGlobal) *TreeIndex[model.MenusGlobal, int64] {
+	index := &TreeIndex[model.MenusGlobal, int64]{}
+	for _, obj := range list {
+		index.Insert(obj.AuthId, obj.ParentId, obj)
+	}
+	return index
+}
+
+// TestTreeIndex 测试子节点, 子孙节点和祖先节点查找
+func TestTreeIndex(t *testing.T) {
+	// 1 -> 2 -> 4
+	//   -> 3 -> 5
+	index := newTreeIndex(
+		&model.MenusGlobal{AuthId: 1, ParentId: 0},
+		&model.MenusGlobal{AuthId: 2, ParentId: 1},
+		&model.MenusGlobal{AuthId: 3, ParentId: 1},
+		&model.MenusGlobal{AuthId: 4, ParentId: 2},
+		&model.MenusGlobal{AuthId: 5, ParentId: 3},
+	)
+
+	if ids := menuIds(index.Children(1)); !equalIds(ids, []int64{2, 3}) {
+		t.Errorf("unexpected children %v", ids)
+	}
+	if ids := menuIds(index.Children(0)); !equalIds(ids, []int64{1}) {
+		t.Errorf("unexpected roots %v", ids)
+	}
+	// 层序
+	if ids := menuIds(index.Descendants(1)); !equalIds(ids, []int64{2, 3, 4, 5}) {
+		t.Errorf("unexpected descendants %v", ids)
+	}
+	if ids := menuIds(index.Ancestors(5)); !equalIds(ids, []int64{3, 1}) {
+		t.Errorf("unexpected ancestors %v", ids)
+	}
+
+	if !index.IsDescendant(4, 1) || !index.IsDescendant(1, 1) || index.IsDescendant(4, 3) || index.IsDescendant(1, 4) {
+		t.Error("unexpected IsDescendant")
+	}
+}
+
+// TestTreeIndexMove 测试移动节点和移出节点
+func TestTreeIndexMove(t *testing.T) {
+	a := &model.MenusGlobal{AuthId: 1, ParentId: 0}
+	b := &model.MenusGlobal{AuthId: 2, ParentId: 1}
+	c := &model.MenusGlobal{AuthId: 3, ParentId: 2}
+	d := &model.MenusGlobal{AuthId: 4, ParentId: 0}
+	index := newTreeIndex(a, b, c, d)
+
+	// 子树随节点移动
+	index.Insert(b.AuthId, d.AuthId, b)
+	if list := index.Children(1); len(list) != 0 {
+		t.Errorf("old parent keeps child %v", menuIds(list))
+	}
+	if ids := menuIds(index.Descendants(4)); !equalIds(ids, []int64{2, 3}) {
+		t.Errorf("unexpected descendants %v", ids)
+	}
+	if ids := menuIds(index.Ancestors(3)); !equalIds(ids, []int64{2, 4}) {
+		t.Errorf("unexpected ancestors %v", ids)
+	}
+
+	// 移出后子节点保留, 重新加入时恢复
+	index.Remove(b.AuthId)
+	if list := index.Descendants(4); len(list) != 0 {
+		t.Errorf("removed node still in tree %v", menuIds(list))
+	}
+	if list := index.Ancestors(3); len(list) != 0 {
+		t.Errorf("unexpected ancestors %v", menuIds(list))
+	}
+	index.Insert(b.AuthId, a.AuthId, b)
+	if ids := menuIds(index.Descendants(1)); !equalIds(ids, []int64{2, 3}) {
+		t.Errorf("unexpected descendants after reinsert %v", ids)
+	}
+
+	index.Clear()
+	if list := index.Descendants(1); len(list) != 0 {
+		t.Error("index not cleared")
+	}
+}
+
+// TestJoinTreePath 测试物化路径
+func TestJoinTreePath(t *testing.T) {
+	if path := JoinTreePath("", int64(0)); path != "0" {
+		t.Errorf("unexpected root path %q", path)
+	}
+	if path := JoinTreePath("0,1", int64(5)); path != "0,1,5" {
+		t.Errorf("unexpected path %q", path)
+	}
+}
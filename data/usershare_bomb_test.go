@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	persistCore "github.com/spelens-gud/persist/core"
+	"github.com/spelens-gud/persist/model"
+	"xorm.io/xorm"
+)
+
+// newUserShareTestEngine 临时目录中的sqlite数据库, 返回数据库文件路径
+func newUserShareTestEngine(t *testing.T) (*xorm.Engine, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "persist.db")
+	engine, err := xorm.NewEngine("sqlite3", path+"?_busy_timeout=10000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = engine.Close() })
+	if err = engine.Sync2(&model.UserShare{}); err != nil {
+		t.Fatal(err)
+	}
+	return engine, path
+}
+
+// waitUserShare 等待条件成立, 超时失败
+func waitUserShare(t *testing.T, name string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("wait %s timeout", name)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestUserShareExitDump 测试退出超时时剩余队列写入bomb文件, 重新运行时重放到数据库
+func TestUserShareExitDump(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("_.", 0770); err != nil {
+		t.Fatal(err)
+	}
+	engine, path := newUserShareTestEngine(t)
+	m := NewUserShareManager(engine)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 其他连接持有写锁, 写回协程阻塞在批量插入中
+	lockDB, err := sql.Open("sqlite3", path+"?_txlock=exclusive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lockDB.Close()
+	lock, err := lockDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.SetLoadState2Memory(1)
+	m.SetLoadState2Memory(2)
+	if _, err = m.NewUserShare(&model.UserShare{Uid: 1, UserName: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	waitUserShare(t, "insert queue", func() bool { return m.QueueStat().Insert == 1 })
+	if _, err = m.NewUserShare(&model.UserShare{Uid: 2, UserName: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	waitUserShare(t, "cache queue", func() bool { return m.QueueStat().Cache == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = m.ExitContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected exit error %v", err)
+	}
+	if !m.Dead() {
+		t.Error("manager not dead")
+	}
+	data, err := os.ReadFile("_./_Users_xt_go_pumppill_data/UserShare.bomb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failQueue []*UserShareSync
+	if err = m.UnmarshalFailQueue(data[len("UserShare "):], &failQueue); err != nil {
+		t.Fatal(err)
+	}
+	if len(failQueue) != 2 || failQueue[0].Data.Uid != 1 || failQueue[1].Data.Uid != 2 {
+		t.Fatalf("unexpected bomb queue %d", len(failQueue))
+	}
+
+	// 写回协程结束前不能重新运行
+	if err = m.Run(); !errors.Is(err, persistCore.EPersistErrorSaving) {
+		t.Fatalf("unexpected run error %v", err)
+	}
+
+	// 阻塞的批量插入在导出后写入数据库, 之后写回协程结束且不删除bomb文件
+	if err = lock.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	waitUserShare(t, "collect exit", func() bool { return atomic.LoadInt32(&m.collecting) == 0 })
+	if _, err = os.Stat("_./_Users_xt_go_pumppill_data/UserShare.bomb"); err != nil {
+		t.Fatal("bomb file removed")
+	}
+
+	// 重新运行时重放bomb文件, 已经写入的插入改为更新
+	if err = m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = m.ExitContext(context.Background()) }()
+	if _, err = os.Stat("_./_Users_xt_go_pumppill_data/UserShare.bomb"); !os.IsNotExist(err) {
+		t.Error("bomb file not removed")
+	}
+	for uid, name := range map[int64]string{1: "a", 2: "b"} {
+		cls := &model.UserShare{Uid: uid}
+		has, err := engine.Get(cls)
+		if err != nil || !has || cls.UserName != name {
+			t.Errorf("uid %d not replayed %v %v %q", uid, has, err, cls.UserName)
+		}
+	}
+}
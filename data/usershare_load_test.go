@@ -0,0 +1,105 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	persistCore "github.com/spelens-gud/persist/core"
+)
+
+// waitUserShareLoadState 等待导入状态, 超时失败
+func waitUserShareLoadState(t *testing.T, m *UserShareManager, Uid int64, state int32) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for m.LoadState(Uid) != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("load state %d, expected %d", m.LoadState(Uid), state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestUserShareLoadContextError 测试并发导入的调用方都得到本次导入的错误
+func TestUserShareLoadContextError(t *testing.T) {
+	m := NewUserShareManager(nil)
+	loadErr := errors.New("load failed")
+	release := make(chan struct{})
+	m.AddHooks(UserShareHooks{BeforeLoad: func(Uid int64) error {
+		<-release
+		return loadErr
+	}})
+
+	const callers = 5
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = m.LoadContext(context.Background(), 1)
+		}(i)
+	}
+	waitUserShareLoadState(t, m, 1, EUserShareLoadStateLoading)
+
+	// ctx结束的调用方立即返回, 不影响正在进行的导入
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.LoadContext(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected canceled error %v", err)
+	}
+	if m.LoadState(1) != EUserShareLoadStateLoading {
+		t.Error("load canceled")
+	}
+
+	close(release)
+	wg.Wait()
+	for i, err := range errs {
+		if !errors.Is(err, loadErr) {
+			t.Errorf("caller %d: unexpected error %v", i, err)
+		}
+	}
+	if m.LoadState(1) != EUserShareLoadStateDisk {
+		t.Errorf("unexpected load state %d", m.LoadState(1))
+	}
+}
+
+// TestUserShareLoadContextPanic 测试导入panic时返回错误并回到导出状态
+func TestUserShareLoadContextPanic(t *testing.T) {
+	m := NewUserShareManager(nil)
+	m.AddHooks(UserShareHooks{BeforeLoad: func(Uid int64) error {
+		panic("hook panic")
+	}})
+
+	err := m.LoadContext(context.Background(), 1)
+	if err == nil || !strings.Contains(err.Error(), "hook panic") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if m.LoadState(1) != EUserShareLoadStateDisk {
+		t.Errorf("unexpected load state %d", m.LoadState(1))
+	}
+}
+
+// TestUserSharePersistUser 测试通过注册表按用户导入导出, 用户uid作为Uid
+func TestUserSharePersistUser(t *testing.T) {
+	engine, _ := newUserShareTestEngine(t)
+	m := NewUserShareManager(engine)
+	r := persistCore.NewRegistry()
+	r.RegisterPersist("UserShare", m)
+	if len(r.GetPersistUserList()) != 1 {
+		t.Fatal("user persist not registered")
+	}
+
+	if err := r.Load(1); err != nil {
+		t.Fatal(err)
+	}
+	if m.LoadState(1) != EUserShareLoadStateMemory || m.LoadState(2) != EUserShareLoadStateDisk {
+		t.Error("uid not converted")
+	}
+	if states := r.LoadState(1); len(states) != 1 || states[0] != EUserShareLoadStateMemory {
+		t.Errorf("unexpected states %v", states)
+	}
+}
@@ -4,10 +4,12 @@ go 1.25.1
 
 require (
 	github.com/Anniext/Arkitektur v1.0.1
-	github.com/Anniext/Verktyg v0.0.0-20250721061244-648ab4a0c01d
+	github.com/getsentry/sentry-go v0.33.0
 	github.com/gin-gonic/gin v1.11.0
 	github.com/go-sql-driver/mysql v1.9.3
 	github.com/json-iterator/go v1.1.12
+	github.com/mattn/go-sqlite3 v1.14.32
+	xorm.io/builder v0.3.13
 	xorm.io/core v0.7.3
 	xorm.io/xorm v1.3.11
 )
@@ -18,7 +20,6 @@ require (
 	github.com/bytedance/sonic/loader v0.3.0 // indirect
 	github.com/cloudwego/base64x v0.1.6 // indirect
 	github.com/gabriel-vasile/mimetype v1.4.8 // indirect
-	github.com/getsentry/sentry-go v0.33.0 // indirect
 	github.com/gin-contrib/sse v1.1.0 // indirect
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
@@ -50,5 +51,4 @@ require (
 	golang.org/x/tools v0.34.0 // indirect
 	google.golang.org/protobuf v1.36.9 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
-	xorm.io/builder v0.3.13 // indirect
 )
@@ -7,12 +7,12 @@ const (
 
 type MenusGlobal struct {
 	AuthId             int64  `xorm:"pk" hash:"group=1;unique=1" hash:"group=3;unique=0"` // 权限id
-	ParentId           int64  `xorm:""`                                                   // 父菜单ID
-	TreePath           string `xorm:""`                                                   // 父节点ID路径
+	ParentId           int64  `xorm:"" tree:"parent"`                                     // 父菜单ID
+	TreePath           string `xorm:"" tree:"path"`                                       // 父节点ID路径, 逗号分隔, 如 0,1,5
 	Name               string `xorm:""`                                                   // 菜单名称
 	Type               string `xorm:"" hash:"group=3;unique=0"`                           // 菜单类型
 	RouteName          string `xorm:""`                                                   // 路由名称（Vue Router 中用于命名路由）
-	Path               string `xorm:""`                                                   // 路由路径（Vue Router 中定义的 URL 路径）
+	Path               string `xorm:"" prefix:"1"`                                        // 路由路径（Vue Router 中定义的 URL 路径）
 	Component          string `xorm:""`                                                   // 组件路径（组件页面完整路径，相对于 src/views/，缺省后缀 .vue）
 	Perm               string `xorm:""`                                                   // [按钮]权限标识
 	Status             int64  `xorm:""`                                                   // 显示状态（1-显示 2-隐藏）
@@ -8,7 +8,7 @@ const (
 // UserShare 用户共享数据
 type UserShare struct {
 	Uid           int64  `xorm:"pk" hash:"group=1;unique=1"`
-	UserName      string `xorm:"" hash:"group=3;unique=1"` // 用户名
+	UserName      string `xorm:"" hash:"group=3;unique=1" prefix:"1"` // 用户名
 	NickName      string `xorm:""`                         // 昵称
 	Password      string `xorm:""`                         // 密码
 	Mobile        string `xorm:"" hash:"group=2;unique=1"` // 绑定手机,使用手机登录